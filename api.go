@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxAPIEvents = 500
+
+// apiTarget is the latest state of one domain and record type, with one
+// result per server column.
+type apiTarget struct {
+	Domain     string
+	Type       string
+	Results    map[string]Observation
+	LastChange time.Time
+}
+
+func (t *apiTarget) MarshalJSON() ([]byte, error) {
+	results := make([]Observation, 0, len(t.Results))
+	for _, server := range sortedKeys(t.Results) {
+		results = append(results, t.Results[server])
+	}
+	var lastChange *time.Time
+	if !t.LastChange.IsZero() {
+		lastChange = &t.LastChange
+	}
+	return json.Marshal(struct {
+		Domain     string        `json:"domain"`
+		Type       string        `json:"type"`
+		LastChange *time.Time    `json:"last_change,omitempty"`
+		Results    []Observation `json:"results"`
+	}{t.Domain, t.Type, lastChange, results})
+}
+
+// APIServer exposes the monitor's state over HTTP: a REST API under /api
+// and a status page at /. It keeps its own copy of the latest observations,
+// so requests never wait for a check in progress.
+type APIServer struct {
+	monitor *Monitor
+
+	mu      sync.Mutex
+	targets map[string]*apiTarget
+	order   []string
+	events  []Observation
+
+	seq         uint64
+	replay      []sseMessage
+	subscribers map[chan sseMessage]sseFilter
+}
+
+func NewAPIServer(monitor *Monitor) *APIServer {
+	api := &APIServer{
+		monitor: monitor,
+		targets: make(map[string]*apiTarget),
+	}
+	for _, t := range monitor.config.targets() {
+		api.target(t.Domain, t.Type)
+	}
+	return api
+}
+
+// target returns the entry for domain and recordType, creating it if
+// needed. The caller must hold api.mu, except during construction.
+func (api *APIServer) target(domain, recordType string) *apiTarget {
+	key := domain + ":" + recordType
+	t, ok := api.targets[key]
+	if !ok {
+		t = &apiTarget{Domain: domain, Type: recordType, Results: make(map[string]Observation)}
+		api.targets[key] = t
+		api.order = append(api.order, key)
+	}
+	return t
+}
+
+// Observe records obs as the latest result for its server and, unless
+// nothing happened, as an event. It is also sent on the /events stream.
+func (api *APIServer) Observe(obs Observation) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	column := ""
+	if len(api.monitor.config.Servers) > 1 {
+		column = obs.Server
+	}
+	t := api.target(obs.Domain, obs.Type)
+	t.Results[column] = obs
+	if obs.Status == StatusChanged || obs.Status == StatusFlapping {
+		t.LastChange = obs.Time
+	}
+
+	if obs.Status != StatusUnchanged || obs.Message != "" {
+		api.events = append(api.events, obs)
+		if len(api.events) > maxAPIEvents {
+			api.events = api.events[len(api.events)-maxAPIEvents:]
+		}
+	}
+	api.publish(EventObservation, obs.Domain, obs.Type, obs)
+}
+
+func (api *APIServer) addTarget(target Target) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.target(target.Domain, target.Type)
+}
+
+func (api *APIServer) removeTarget(target Target) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	key := target.Key()
+	if _, ok := api.targets[key]; !ok {
+		return
+	}
+	delete(api.targets, key)
+	for i, k := range api.order {
+		if k == key {
+			api.order = append(api.order[:i:i], api.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (api *APIServer) snapshot() ([]*apiTarget, []Observation) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	targets := make([]*apiTarget, 0, len(api.order))
+	for _, key := range api.order {
+		t := *api.targets[key]
+		t.Results = make(map[string]Observation, len(api.targets[key].Results))
+		for server, obs := range api.targets[key].Results {
+			t.Results[server] = obs
+		}
+		targets = append(targets, &t)
+	}
+	return targets, append([]Observation(nil), api.events...)
+}
+
+// do runs fn on the monitor loop, which owns the monitor's state, and waits
+// for it to finish. It returns false if ctx is done first.
+func (m *Monitor) do(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case m.control <- func() { fn(); close(done) }:
+	case <-ctx.Done():
+		return false
+	}
+	<-done
+	return true
+}
+
+// addDomain starts monitoring domain from the next check, with the global
+// record type and expected values. It returns false if the domain is already
+// monitored. Reloading the configuration keeps the target.
+func (m *Monitor) addDomain(domain string) bool {
+	target := Target{Domain: domain, Type: m.config.RecordType, Expected: m.config.Expected}
+	if hasTarget(m.config.targets(), target) {
+		return false
+	}
+	m.config.Targets = append(m.config.Targets, target)
+	m.added = append(m.added, target)
+	if m.api != nil {
+		m.api.addTarget(target)
+	}
+	m.logger.Printf("Added target: %s", domain)
+	return true
+}
+
+// removeDomain stops monitoring every target of domain and forgets
+// everything recorded for them. It returns false if the domain is not
+// monitored.
+func (m *Monitor) removeDomain(domain string) bool {
+	var removed []Target
+	for _, t := range m.config.targets() {
+		if strings.EqualFold(t.Domain, domain) {
+			removed = append(removed, t)
+		}
+	}
+	if len(removed) == 0 {
+		return false
+	}
+
+	domains := m.config.Domains[:0:0]
+	for _, d := range m.config.Domains {
+		if !strings.EqualFold(d, domain) {
+			domains = append(domains, d)
+		}
+	}
+	m.config.Domains = domains
+	targets := m.config.Targets[:0:0]
+	for _, t := range m.config.Targets {
+		if !strings.EqualFold(t.Domain, domain) {
+			targets = append(targets, t)
+		}
+	}
+	m.config.Targets = targets
+	added := m.added[:0:0]
+	for _, t := range m.added {
+		if !strings.EqualFold(t.Domain, domain) {
+			added = append(added, t)
+		}
+	}
+	m.added = added
+
+	for _, t := range removed {
+		m.forget(t)
+	}
+	m.logger.Printf("Removed target: %s", domain)
+	return true
+}
+
+func (api *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/targets", api.handleListTargets)
+	mux.HandleFunc("POST /api/targets", api.authorize(api.handleAddTarget))
+	mux.HandleFunc("GET /api/targets/{domain}", api.handleGetTarget)
+	mux.HandleFunc("DELETE /api/targets/{domain}", api.authorize(api.handleRemoveTarget))
+	mux.HandleFunc("GET /api/events", api.handleEvents)
+	mux.HandleFunc("GET /events", api.handleStream)
+	mux.HandleFunc("GET /{$}", api.handleStatusPage)
+	if api.monitor.metrics != nil {
+		mux.Handle("GET /metrics", api.monitor.metrics)
+	}
+	return mux
+}
+
+// authorize only lets requests carrying the configured API token as a
+// bearer token through to next. Without a token, changes are refused.
+func (api *APIServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := api.monitor.config.APIToken
+		if token == "" {
+			writeError(w, http.StatusForbidden, "changing targets requires --api-token")
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dns-monitor"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ListenAndServe starts serving on addr in the background. Errors binding
+// the address are returned immediately.
+func (api *APIServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for API on %s: %v", addr, err)
+	}
+	go func() {
+		if err := http.Serve(ln, api.Handler()); err != nil {
+			log.Printf("Warning: API server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (api *APIServer) handleListTargets(w http.ResponseWriter, r *http.Request) {
+	targets, _ := api.snapshot()
+	writeJSON(w, http.StatusOK, map[string]any{"targets": targets})
+}
+
+func (api *APIServer) handleGetTarget(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	targets, _ := api.snapshot()
+	var found []*apiTarget
+	for _, t := range targets {
+		if strings.EqualFold(t.Domain, domain) {
+			found = append(found, t)
+		}
+	}
+	if len(found) == 0 {
+		writeError(w, http.StatusNotFound, "unknown target: "+domain)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"targets": found})
+}
+
+func (api *APIServer) handleAddTarget(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	domain := strings.TrimSpace(req.Domain)
+	if domain == "" || strings.ContainsAny(domain, " \t/") {
+		writeError(w, http.StatusBadRequest, "invalid domain: "+req.Domain)
+		return
+	}
+
+	var added bool
+	var recordType string
+	if !api.monitor.do(r.Context(), func() {
+		added = api.monitor.addDomain(domain)
+		recordType = api.monitor.config.RecordType
+	}) {
+		writeError(w, http.StatusServiceUnavailable, "monitor is not running")
+		return
+	}
+	if !added {
+		writeError(w, http.StatusConflict, "target already exists: "+domain)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &apiTarget{Domain: domain, Type: recordType})
+}
+
+func (api *APIServer) handleRemoveTarget(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+
+	var removed bool
+	if !api.monitor.do(r.Context(), func() { removed = api.monitor.removeDomain(domain) }) {
+		writeError(w, http.StatusServiceUnavailable, "monitor is not running")
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, "unknown target: "+domain)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	_, events := api.snapshot()
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit: "+s)
+			return
+		}
+		limit = n
+	}
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"events": events})
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"values": formatValues,
+	"rtt":    formatRTT,
+	"clock":  func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>DNS Monitor</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 12px; border-bottom: 1px solid #ddd; text-align: left; font-family: monospace; }
+th { font-family: sans-serif; }
+.initial, .unchanged { color: #1a7f37; }
+.changed, .error, .flapping { color: #cf222e; font-weight: bold; }
+.warning, .pending, .suppressed { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>DNS Monitor v{{.Version}}</h1>
+<p>{{.RecordType}} records every {{.Interval}} &middot; updated {{clock .Now}}</p>
+<table>
+<tr><th>Domain</th><th>Type</th><th>Server</th><th>Values</th><th>Status</th><th>RTT</th><th>Last change</th></tr>
+{{range .Targets}}{{$t := .}}{{range $server, $obs := .Results}}<tr>
+<td>{{$t.Domain}}</td><td>{{$t.Type}}</td><td>{{$obs.Server}}</td>
+<td>{{if $obs.Error}}{{$obs.Error}}{{else}}{{values $obs.Values}}{{end}}</td>
+<td class="{{$obs.Status}}">{{$obs.Status}}</td><td>{{if $obs.RTT}}{{rtt $obs.RTT}}{{end}}</td>
+<td>{{if not $t.LastChange.IsZero}}{{clock $t.LastChange}}{{else}}-{{end}}</td>
+</tr>{{else}}<tr><td>{{$t.Domain}}</td><td>{{$t.Type}}</td><td colspan="5">waiting for first check</td></tr>{{end}}
+{{end}}</table>
+<h2>Recent events</h2>
+<table>
+<tr><th>Time</th><th>Domain</th><th>Type</th><th>Server</th><th>Status</th><th>Details</th></tr>
+{{range .Events}}<tr>
+<td>{{clock .Time}}</td><td>{{.Domain}}</td><td>{{.Type}}</td><td>{{.Server}}</td><td class="{{.Status}}">{{.Status}}</td>
+<td>{{if .Error}}{{.Error}}{{else if eq .Status "changed"}}{{values .Previous}} &rarr; {{values .Values}}{{else if .Message}}{{.Message}}{{else}}{{values .Values}}{{end}}</td>
+</tr>{{else}}<tr><td colspan="6">No events yet</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+func (api *APIServer) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	var recordType string
+	var interval time.Duration
+	if !api.monitor.do(r.Context(), func() {
+		recordType = api.monitor.config.RecordType
+		interval = api.monitor.config.Interval
+	}) {
+		http.Error(w, "monitor is not running", http.StatusServiceUnavailable)
+		return
+	}
+	targets, events := api.snapshot()
+	if len(events) > 50 {
+		events = events[len(events)-50:]
+	}
+	// Newest events first.
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := statusPage.Execute(w, map[string]any{
+		"Version":    Version,
+		"RecordType": recordType,
+		"Interval":   interval,
+		"Now":        time.Now(),
+		"Targets":    targets,
+		"Events":     events,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to render status page: %v", err)
+	}
+}
+
+func runServeCommand(args []string) int {
+	// A --listen given on the command line overrides the default.
+	args = append([]string{args[0], "--listen", "127.0.0.1:8080"}, args[1:]...)
+	config, err := ParseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if config.ShowHelp {
+		printServeUsage()
+		return 0
+	}
+	if config.ShowVersion {
+		fmt.Printf("DNS Monitor Tool v%s\n", Version)
+		return 0
+	}
+
+	monitor := NewMonitor(config)
+	monitor.reload = func() (*Config, error) { return ParseArgs(args) }
+	if err := monitor.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printServeUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor serve [--listen ADDR] [OPTIONS] [DOMAIN...]
+
+Runs the monitor and serves its state over HTTP. Accepts every monitoring
+option; domains can also be added and removed through the API.
+
+OPTIONS:
+    --listen ADDR           Address to serve on [default: 127.0.0.1:8080]
+    --api-token TOKEN       Bearer token required to add and remove targets [env: DNS_MONITOR_API_TOKEN]
+    -h, --help              Display help
+
+ENDPOINTS:
+    GET    /                       Status page
+    GET    /api/targets            Current values per server for every target
+    GET    /api/targets/DOMAIN     Current values for one domain
+    POST   /api/targets            Add a target: {"domain": "example.com"} (needs token)
+    DELETE /api/targets/DOMAIN     Remove a target (needs token)
+    GET    /api/events?limit=N     Recent changes, errors and warnings [default: 100]
+    GET    /events                 Server-Sent Events stream of observations and events
+                                   (filters: domain, type, kind; replay=N on connect)
+
+EXAMPLES:
+    DNS_MONITOR_API_TOKEN=s3cret dns-monitor serve --all-servers example.com
+    curl -X POST -H 'Authorization: Bearer s3cret' -d '{"domain":"api.example.com"}' http://localhost:8080/api/targets
+`)
+}
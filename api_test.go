@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPI returns a monitor with an API server and a goroutine standing in
+// for the monitor loop, running control functions until the test ends.
+func newTestAPI(t *testing.T, domains ...string) (*Monitor, *httptest.Server) {
+	t.Helper()
+	config := &Config{
+		Domains:    domains,
+		RecordType: "A",
+		Interval:   time.Minute,
+		Servers:    []string{"192.0.2.1:53", "192.0.2.2:53"},
+		Listen:     "127.0.0.1:0",
+		APIToken:   "s3cret",
+	}
+	monitor := NewMonitor(config)
+	monitor.logger = log.New(io.Discard, "", 0)
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case fn := <-monitor.control:
+				fn()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	srv := httptest.NewServer(monitor.api.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		close(stop)
+	})
+	return monitor, srv
+}
+
+func getJSON(t *testing.T, url string, v any) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type targetsResponse struct {
+	Targets []struct {
+		Domain     string     `json:"domain"`
+		Type       string     `json:"type"`
+		LastChange *time.Time `json:"last_change"`
+		Results    []struct {
+			Server string   `json:"server"`
+			Status string   `json:"status"`
+			Values []string `json:"values"`
+		} `json:"results"`
+	} `json:"targets"`
+}
+
+func TestAPIServer_targets(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com", "example.org")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusInitial, Values: []string{"1.1.1.1"}})
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.2:53", Status: StatusChanged, Values: []string{"2.2.2.2"}, Previous: []string{"1.1.1.1"}})
+
+	var all targetsResponse
+	if status := getJSON(t, srv.URL+"/api/targets", &all); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(all.Targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(all.Targets))
+	}
+	if all.Targets[1].Domain != "example.org" || len(all.Targets[1].Results) != 0 {
+		t.Errorf("expected unchecked example.org, got %+v", all.Targets[1])
+	}
+
+	var one targetsResponse
+	if status := getJSON(t, srv.URL+"/api/targets/example.com", &one); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	target := one.Targets[0]
+	if len(target.Results) != 2 {
+		t.Fatalf("expected one result per server, got %+v", target.Results)
+	}
+	if target.Results[1].Server != "192.0.2.2:53" || target.Results[1].Status != StatusChanged {
+		t.Errorf("expected changed result for 192.0.2.2:53, got %+v", target.Results[1])
+	}
+	if target.LastChange == nil || !target.LastChange.Equal(now) {
+		t.Errorf("expected last change %v, got %v", now, target.LastChange)
+	}
+
+	if status := getJSON(t, srv.URL+"/api/targets/missing.example", nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
+
+func TestAPIServer_events(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com")
+	now := time.Now()
+
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusUnchanged, Values: []string{"1.1.1.1"}})
+	for i := 0; i < 3; i++ {
+		monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusError, Error: "timeout"})
+	}
+
+	var resp struct {
+		Events []Observation `json:"events"`
+	}
+	if status := getJSON(t, srv.URL+"/api/events", &resp); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(resp.Events) != 3 {
+		t.Errorf("expected unchanged observations to be left out, got %d events", len(resp.Events))
+	}
+
+	var limited struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	getJSON(t, srv.URL+"/api/events?limit=2", &limited)
+	if len(limited.Events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(limited.Events))
+	}
+
+	if status := getJSON(t, srv.URL+"/api/events?limit=zero", nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+}
+
+func TestAPIServer_addRemoveTarget(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com")
+
+	post := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/targets", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	remove := func(domain string) int {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/targets/"+domain, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("DELETE failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(`{"domain": "example.org"}`); status != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", status)
+	}
+	if status := post(`{"domain": "EXAMPLE.org"}`); status != http.StatusConflict {
+		t.Errorf("expected status 409 for duplicate, got %d", status)
+	}
+	if status := post(`{"domain": ""}`); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for empty domain, got %d", status)
+	}
+	if status := post(`not json`); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid body, got %d", status)
+	}
+	if targets := monitor.config.targets(); len(targets) != 2 || targets[1].Key() != "example.org:A" || len(monitor.added) != 1 {
+		t.Errorf("expected example.org to be added, got %v", targets)
+	}
+
+	monitor.lastRecords["example.com:A@192.0.2.1:53"] = &DNSRecord{Domain: "example.com", Type: "A"}
+	monitor.lastRecords["example.community:A@192.0.2.1:53"] = &DNSRecord{Domain: "example.community", Type: "A"}
+
+	if status := remove("example.com"); status != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", status)
+	}
+	if status := remove("example.com"); status != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown target, got %d", status)
+	}
+	if targets := monitor.config.targets(); len(targets) != 1 || targets[0].Domain != "example.org" {
+		t.Errorf("expected only example.org to remain, got %v", targets)
+	}
+	if _, ok := monitor.lastRecords["example.com:A@192.0.2.1:53"]; ok {
+		t.Error("expected last records of removed domain to be forgotten")
+	}
+	if _, ok := monitor.lastRecords["example.community:A@192.0.2.1:53"]; !ok {
+		t.Error("expected last records of other domains to be kept")
+	}
+
+	var all targetsResponse
+	getJSON(t, srv.URL+"/api/targets", &all)
+	if len(all.Targets) != 1 || all.Targets[0].Domain != "example.org" {
+		t.Errorf("expected only example.org in targets, got %+v", all.Targets)
+	}
+}
+
+func TestAPIServer_authorize(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com")
+
+	request := func(method, path, authorization string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(`{"domain": "example.org"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+		if resp := request(http.MethodPost, "/api/targets", authorization); resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("POST with %q: expected 401 with a challenge, got %d", authorization, resp.StatusCode)
+		}
+		if resp := request(http.MethodDelete, "/api/targets/example.com", authorization); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("DELETE with %q: expected 401, got %d", authorization, resp.StatusCode)
+		}
+	}
+	if len(monitor.config.Domains) != 1 || monitor.config.Domains[0] != "example.com" {
+		t.Errorf("expected unauthorized requests not to change targets, got %v", monitor.config.Domains)
+	}
+	if resp := request(http.MethodGet, "/api/targets", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected reads without a token to succeed, got %d", resp.StatusCode)
+	}
+
+	monitor.config.APIToken = ""
+	if resp := request(http.MethodPost, "/api/targets", "Bearer "); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected changes to be refused without a configured token, got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIServer_statusPage(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com")
+	monitor.emit(Observation{Time: time.Now(), Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusChanged, Values: []string{"2.2.2.2"}, Previous: []string{"1.1.1.1"}})
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("GET / failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected HTML content type, got %q", ct)
+	}
+	for _, want := range []string{"example.com", "2.2.2.2", "[1.1.1.1] &rarr; [2.2.2.2]", `class="changed"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected status page to contain %q", want)
+		}
+	}
+
+	if status := getJSON(t, srv.URL+"/missing", nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type BenchConfig struct {
+	Servers     []string
+	Domains     []string
+	RecordType  string
+	Queries     int
+	Concurrency int
+	Rate        int
+	Timeout     time.Duration
+	JSON        bool
+	ShowHelp    bool
+}
+
+// BenchResult summarises the queries sent to one server. Mismatches count
+// answers that differ from the answer most servers gave for the same domain.
+type BenchResult struct {
+	Server     string
+	Queries    int
+	Timeouts   int
+	ServFails  int
+	Errors     int
+	Answered   int
+	Mismatches int
+	Latency    ServerStats
+}
+
+// ParseBenchArgs parses the arguments following the "bench" subcommand.
+func ParseBenchArgs(args []string) (*BenchConfig, error) {
+	config := &BenchConfig{
+		RecordType:  "A",
+		Queries:     100,
+		Concurrency: 10,
+		Timeout:     2 * time.Second,
+	}
+
+	i := 1
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "-h" || arg == "--help":
+			config.ShowHelp = true
+			return config, nil
+		case arg == "-t" || arg == "--type":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.RecordType = strings.ToUpper(args[i+1])
+			i += 2
+		case arg == "-s" || arg == "--server":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			server := args[i+1]
+			if !strings.Contains(server, ":") {
+				server += ":53"
+			}
+			config.Servers = append(config.Servers, server)
+			i += 2
+		case arg == "--all-servers":
+			config.Servers = []string{"8.8.8.8:53", "1.1.1.1:53", "1.0.0.1:53"}
+			i++
+		case arg == "--domains":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			domains, err := readDomainList(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			config.Domains = append(config.Domains, domains...)
+			i += 2
+		case arg == "-n" || arg == "--queries" || arg == "-c" || arg == "--concurrency" || arg == "-r" || arg == "--rate":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid value for %s: %s", arg, args[i+1])
+			}
+			switch arg {
+			case "-n", "--queries":
+				config.Queries = n
+			case "-c", "--concurrency":
+				config.Concurrency = n
+			default:
+				config.Rate = n
+			}
+			i += 2
+		case arg == "--timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %v", err)
+			}
+			config.Timeout = duration
+			i += 2
+		case arg == "--json":
+			config.JSON = true
+			i++
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown option: %s", arg)
+		default:
+			config.Domains = append(config.Domains, arg)
+			i++
+		}
+	}
+
+	if len(config.Servers) == 0 {
+		return nil, fmt.Errorf("at least one server must be specified")
+	}
+	if len(config.Domains) == 0 {
+		return nil, fmt.Errorf("at least one domain must be specified")
+	}
+	if config.Queries < 1 || config.Concurrency < 1 {
+		return nil, fmt.Errorf("queries and concurrency must be at least 1")
+	}
+	if _, ok := stringToType(config.RecordType); !ok || !isValidRecordType(config.RecordType) {
+		return nil, fmt.Errorf("unsupported record type: %s", config.RecordType)
+	}
+
+	return config, nil
+}
+
+// readDomainList reads one domain per line, ignoring blank lines and
+// comments starting with '#'.
+func readDomainList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain list: %v", err)
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			domains = append(domains, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain list: %v", err)
+	}
+	return domains, nil
+}
+
+type benchAnswer struct {
+	domain string
+	answer string
+}
+
+// RunBench sends config.Queries queries to every server, cycling through
+// the domain list. Servers are benchmarked in parallel, each with its own
+// pool of config.Concurrency workers and, when config.Rate is set, its own
+// rate limit in queries per second.
+func RunBench(config *BenchConfig) []BenchResult {
+	client := NewDNSClient(config.Servers, config.Timeout)
+	results := make([]BenchResult, len(config.Servers))
+	answers := make([][]benchAnswer, len(config.Servers))
+
+	var wg sync.WaitGroup
+	for i, server := range config.Servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			results[i], answers[i] = benchServer(client, server, config)
+		}(i, server)
+	}
+	wg.Wait()
+
+	// The reference answer for each domain is the one seen most often across
+	// all servers.
+	counts := make(map[string]map[string]int)
+	for _, serverAnswers := range answers {
+		for _, a := range serverAnswers {
+			if counts[a.domain] == nil {
+				counts[a.domain] = make(map[string]int)
+			}
+			counts[a.domain][a.answer]++
+		}
+	}
+	consensus := make(map[string]string)
+	for domain, byAnswer := range counts {
+		best := -1
+		for answer, n := range byAnswer {
+			if n > best || (n == best && answer < consensus[domain]) {
+				best = n
+				consensus[domain] = answer
+			}
+		}
+	}
+
+	stats := make(map[string]ServerStats)
+	for _, s := range client.Stats() {
+		stats[s.Server] = s
+	}
+	for i := range results {
+		for _, a := range answers[i] {
+			if a.answer != consensus[a.domain] {
+				results[i].Mismatches++
+			}
+		}
+		results[i].Latency = stats[results[i].Server]
+	}
+	return results
+}
+
+func benchServer(client *DNSClient, server string, config *BenchConfig) (BenchResult, []benchAnswer) {
+	result := BenchResult{Server: server, Queries: config.Queries}
+
+	jobs := make(chan string)
+	go func() {
+		var throttle <-chan time.Time
+		if config.Rate > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(config.Rate))
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+		for n := 0; n < config.Queries; n++ {
+			if throttle != nil && n > 0 {
+				<-throttle
+			}
+			jobs <- config.Domains[n%len(config.Domains)]
+		}
+		close(jobs)
+	}()
+
+	var mu sync.Mutex
+	var answers []benchAnswer
+	var wg sync.WaitGroup
+	for w := 0; w < config.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range jobs {
+				answer, err := benchQuery(client, server, domain, config.RecordType)
+
+				mu.Lock()
+				var netErr net.Error
+				var respErr *ResponseError
+				switch {
+				case err == nil:
+					result.Answered++
+					answers = append(answers, benchAnswer{domain, answer})
+				case errors.As(err, &netErr) && netErr.Timeout():
+					result.Timeouts++
+				case errors.As(err, &respErr) && respErr.Rcode == RcodeServFail:
+					result.ServFails++
+				default:
+					result.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return result, answers
+}
+
+// benchQuery returns the answer to domain as a comparable string. NXDOMAIN
+// and empty answers are valid answers for comparison purposes.
+func benchQuery(client *DNSClient, server, domain, recordType string) (string, error) {
+	record, err := client.QueryServer(server, domain, recordType)
+	var respErr *ResponseError
+	var noRecords *NoRecordsError
+	switch {
+	case err == nil:
+		return record.String(), nil
+	case errors.As(err, &respErr) && respErr.Rcode == RcodeNXDomain:
+		return "NXDOMAIN", nil
+	case errors.As(err, &noRecords):
+		return "NODATA", nil
+	}
+	return "", err
+}
+
+func (r BenchResult) rate(n int) float64 {
+	if r.Queries == 0 {
+		return 0
+	}
+	return float64(n) / float64(r.Queries)
+}
+
+func (r BenchResult) mismatchRate() float64 {
+	if r.Answered == 0 {
+		return 0
+	}
+	return float64(r.Mismatches) / float64(r.Answered)
+}
+
+func (r BenchResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Server       string  `json:"server"`
+		Queries      int     `json:"queries"`
+		Answered     int     `json:"answered"`
+		Timeouts     int     `json:"timeouts"`
+		ServFails    int     `json:"servfails"`
+		Errors       int     `json:"errors"`
+		Mismatches   int     `json:"mismatches"`
+		TimeoutRate  float64 `json:"timeout_rate"`
+		ServFailRate float64 `json:"servfail_rate"`
+		MismatchRate float64 `json:"mismatch_rate"`
+		P50          float64 `json:"p50_ms"`
+		P95          float64 `json:"p95_ms"`
+		Max          float64 `json:"max_ms"`
+	}{
+		r.Server, r.Queries, r.Answered, r.Timeouts, r.ServFails, r.Errors, r.Mismatches,
+		r.rate(r.Timeouts), r.rate(r.ServFails), r.mismatchRate(),
+		millis(r.Latency.P50), millis(r.Latency.P95), millis(r.Latency.Max),
+	})
+}
+
+func printBenchResults(w io.Writer, results []BenchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tQUERIES\tP50\tP95\tMAX\tTIMEOUTS\tSERVFAIL\tERRORS\tMISMATCH")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f%%\n",
+			r.Server, r.Queries, formatRTT(r.Latency.P50), formatRTT(r.Latency.P95), formatRTT(r.Latency.Max),
+			r.rate(r.Timeouts)*100, r.rate(r.ServFails)*100, r.rate(r.Errors)*100, r.mismatchRate()*100)
+	}
+	tw.Flush()
+}
+
+func runBenchCommand(args []string) int {
+	config, err := ParseBenchArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if config.ShowHelp {
+		printBenchUsage()
+		return 0
+	}
+
+	if !config.JSON {
+		fmt.Printf("Benchmarking %d server(s) with %d %s queries each over %d domain(s), concurrency %d\n",
+			len(config.Servers), config.Queries, config.RecordType, len(config.Domains), config.Concurrency)
+	}
+	start := time.Now()
+	results := RunBench(config)
+
+	if config.JSON {
+		b, err := json.Marshal(struct {
+			Results []BenchResult `json:"results"`
+		}{results})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(b))
+		return 0
+	}
+
+	fmt.Printf("Completed in %s\n\n", time.Since(start).Round(time.Millisecond))
+	printBenchResults(os.Stdout, results)
+	return 0
+}
+
+func printBenchUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor bench [OPTIONS] -s SERVER [-s SERVER...] [DOMAIN...]
+
+OPTIONS:
+    -s, --server SERVER      DNS server to benchmark (multiple allowed)
+    --all-servers           Benchmark all major DNS servers (8.8.8.8, 1.1.1.1, 1.0.0.1)
+    --domains FILE          Read domains from FILE, one per line
+    -t, --type TYPE          DNS record type [default: A]
+    -n, --queries N          Queries per server [default: 100]
+    -c, --concurrency N      Concurrent queries per server [default: 10]
+    -r, --rate QPS           Maximum queries per second per server [default: unlimited]
+    --timeout DURATION      Query timeout [default: 2s]
+    --json                  Print results as JSON
+    -h, --help              Display help
+
+EXAMPLES:
+    dns-monitor bench -s 8.8.8.8 -s 1.1.1.1 example.com
+    dns-monitor bench --all-servers -n 1000 -c 50 -r 200 --domains domains.txt
+`)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseBenchArgs(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "domains.txt")
+	if err := os.WriteFile(list, []byte("# inventory\nexample.com\n\napi.example.com A  # trailing comment\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseBenchArgs([]string{"bench", "-s", "8.8.8.8", "-s", "1.1.1.1:5353", "--domains", list, "-n", "50", "-c", "5", "-r", "20", "--timeout", "3s", "www.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.Servers) != 2 || config.Servers[0] != "8.8.8.8:53" || config.Servers[1] != "1.1.1.1:5353" {
+		t.Errorf("unexpected servers: %v", config.Servers)
+	}
+	if len(config.Domains) != 3 || config.Domains[0] != "example.com" || config.Domains[1] != "api.example.com" || config.Domains[2] != "www.example.com" {
+		t.Errorf("unexpected domains: %v", config.Domains)
+	}
+	if config.Queries != 50 || config.Concurrency != 5 || config.Rate != 20 || config.Timeout != 3*time.Second {
+		t.Errorf("unexpected options: %+v", config)
+	}
+
+	errorCases := []struct {
+		name string
+		args []string
+	}{
+		{"no servers", []string{"bench", "example.com"}},
+		{"no domains", []string{"bench", "-s", "8.8.8.8"}},
+		{"zero concurrency", []string{"bench", "-s", "8.8.8.8", "-c", "0", "example.com"}},
+		{"invalid queries", []string{"bench", "-s", "8.8.8.8", "-n", "many", "example.com"}},
+		{"missing domain list", []string{"bench", "-s", "8.8.8.8", "--domains", filepath.Join(dir, "missing.txt")}},
+		{"unknown option", []string{"bench", "-s", "8.8.8.8", "--bogus", "example.com"}},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBenchArgs(tt.args); err == nil {
+				t.Error("expected error but got none")
+			}
+		})
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	answering := func(last byte) func(q *dnsMessage) *dnsMessage {
+		return func(q *dnsMessage) *dnsMessage {
+			if q.Questions[0].Name == "fail.example.com." {
+				return &dnsMessage{Rcode: RcodeServFail}
+			}
+			return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, last})}}
+		}
+	}
+	good1 := startStubServer(t, answering(1))
+	good2 := startStubServer(t, answering(1))
+	stale := startStubServer(t, answering(9))
+	silent := startStubServer(t, func(q *dnsMessage) *dnsMessage { return nil })
+
+	config := &BenchConfig{
+		Servers:     []string{good1, good2, stale, silent},
+		Domains:     []string{"example.com", "fail.example.com"},
+		RecordType:  "A",
+		Queries:     10,
+		Concurrency: 4,
+		Timeout:     200 * time.Millisecond,
+	}
+	results := RunBench(config)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+
+	if r := results[0]; r.Answered != 5 || r.ServFails != 5 || r.Mismatches != 0 {
+		t.Errorf("unexpected result for consistent server: %+v", r)
+	}
+	if r := results[0]; r.Latency.Queries != 10 || r.Latency.P50 <= 0 {
+		t.Errorf("expected latency statistics, got %+v", r.Latency)
+	}
+	if r := results[2]; r.Mismatches != 5 || r.mismatchRate() != 1 {
+		t.Errorf("expected every answer from the stale server to mismatch, got %+v", r)
+	}
+	if r := results[3]; r.Timeouts != 10 || r.Answered != 0 {
+		t.Errorf("expected only timeouts from the silent server, got %+v", r)
+	}
+}
+
+func TestRunBench_Rate(t *testing.T) {
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, 1})}}
+	})
+
+	start := time.Now()
+	RunBench(&BenchConfig{
+		Servers:     []string{server},
+		Domains:     []string{"example.com"},
+		RecordType:  "A",
+		Queries:     6,
+		Concurrency: 6,
+		Rate:        50,
+		Timeout:     time.Second,
+	})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected rate limit to spread 6 queries over at least 100ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxChatEvents bounds the events rendered into one chat message; Slack
+// rejects messages with more than 50 blocks.
+const maxChatEvents = 40
+
+// ChatRoute sends events for domains matching Pattern to URL. An empty
+// pattern matches every domain not claimed by another route.
+type ChatRoute struct {
+	Pattern string
+	URL     string
+}
+
+func (r ChatRoute) String() string {
+	if r.Pattern == "" {
+		return r.URL
+	}
+	return r.Pattern + "=" + r.URL
+}
+
+// parseChatRoute parses "[PATTERN=]URL", where PATTERN is a domain or a
+// wildcard such as "*.example.com".
+func parseChatRoute(value string) (ChatRoute, error) {
+	var route ChatRoute
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		pattern, url, ok := strings.Cut(value, "=")
+		if !ok || pattern == "" {
+			return route, fmt.Errorf("invalid URL: %s", value)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return route, fmt.Errorf("invalid domain pattern: %s", pattern)
+		}
+		route.Pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		value = url
+	}
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return route, fmt.Errorf("invalid URL: %s", value)
+	}
+	route.URL = value
+	return route, nil
+}
+
+type chatMessage struct {
+	url    string
+	events []Event
+}
+
+// ChatNotifier posts the events of each tick as a single formatted message
+// per destination, for chat services such as Slack and Microsoft Teams.
+type ChatNotifier struct {
+	httpPoster
+	name   string
+	render func(events []Event) ([]byte, error)
+	routes []ChatRoute
+	logger *log.Logger
+	queue  chan chatMessage
+	done   sync.WaitGroup
+}
+
+func NewSlackNotifier(routes []ChatRoute, timeout time.Duration, retries int, logger *log.Logger) *ChatNotifier {
+	return newChatNotifier("SLACK", renderSlack, routes, timeout, retries, logger)
+}
+
+func NewTeamsNotifier(routes []ChatRoute, timeout time.Duration, retries int, logger *log.Logger) *ChatNotifier {
+	return newChatNotifier("TEAMS", renderTeams, routes, timeout, retries, logger)
+}
+
+func newChatNotifier(name string, render func([]Event) ([]byte, error), routes []ChatRoute, timeout time.Duration, retries int, logger *log.Logger) *ChatNotifier {
+	n := &ChatNotifier{
+		httpPoster: newHTTPPoster(timeout, retries),
+		name:       name,
+		render:     render,
+		routes:     routes,
+		logger:     logger,
+		queue:      make(chan chatMessage, webhookQueueSize),
+	}
+
+	n.done.Add(1)
+	go func() {
+		defer n.done.Done()
+		dropped := 0
+		for msg := range n.queue {
+			if n.ctx.Err() != nil {
+				dropped += len(msg.events)
+				continue
+			}
+			body, err := n.render(msg.events)
+			if err == nil {
+				err = n.post(msg.url, body, nil)
+			}
+			if err != nil {
+				n.logger.Printf("%s: delivery of %d event(s) to %s failed: %v", n.name, len(msg.events), msg.url, err)
+			}
+		}
+		if dropped > 0 {
+			n.logger.Printf("%s: shutdown timed out, dropping %d queued event(s)", n.name, dropped)
+		}
+	}()
+	return n
+}
+
+// route returns the URL events for domain are sent to, or "" when no route
+// matches. Routes with a pattern take precedence over the default route.
+func (n *ChatNotifier) route(domain string) string {
+	fallback := ""
+	for _, route := range n.routes {
+		if route.Pattern == "" {
+			if fallback == "" {
+				fallback = route.URL
+			}
+			continue
+		}
+		if matchDomain(route.Pattern, domain) {
+			return route.URL
+		}
+	}
+	return fallback
+}
+
+func (n *ChatNotifier) Notify(events []Event) {
+	var urls []string
+	grouped := make(map[string][]Event)
+	for _, event := range events {
+		if !firstFailure(event) {
+			continue
+		}
+		url := n.route(event.Domain)
+		if url == "" {
+			continue
+		}
+		if _, ok := grouped[url]; !ok {
+			urls = append(urls, url)
+		}
+		grouped[url] = append(grouped[url], event)
+	}
+
+	for _, url := range urls {
+		select {
+		case n.queue <- chatMessage{url: url, events: grouped[url]}:
+		default:
+			n.logger.Printf("%s: queue full, dropping %d event(s) for %s", n.name, len(grouped[url]), url)
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries to finish,
+// without retrying them and for at most closeTimeout.
+func (n *ChatNotifier) Close() {
+	close(n.queue)
+	n.shutdown(&n.done)
+}
+
+// summarizeEvents returns a one-line summary such as
+// "DNS Monitor: 2 changes, 1 error".
+func summarizeEvents(events []Event) string {
+	changes, errs, flapping, recovered := 0, 0, 0, 0
+	for _, event := range events {
+		switch event.Kind {
+		case EventError:
+			errs++
+		case EventFlapping:
+			flapping++
+		case EventRecovered:
+			recovered++
+		default:
+			changes++
+		}
+	}
+
+	var parts []string
+	if changes > 0 {
+		parts = append(parts, plural(changes, "change"))
+	}
+	if errs > 0 {
+		parts = append(parts, plural(errs, "error"))
+	}
+	if flapping > 0 {
+		parts = append(parts, fmt.Sprintf("%d flapping", flapping))
+	}
+	if recovered > 0 {
+		parts = append(parts, fmt.Sprintf("%d recovered", recovered))
+	}
+	return "DNS Monitor: " + strings.Join(parts, ", ")
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// eventTitle describes where an event happened, e.g.
+// "example.com (A) @ 8.8.8.8:53".
+func eventTitle(event Event) string {
+	title := fmt.Sprintf("%s (%s)", event.Domain, event.Type)
+	if event.Server != "" {
+		title += " @ " + event.Server
+	}
+	return title
+}
+
+// eventDiff renders the values of a change event one per line, prefixed
+// with "-" when removed, "+" when added and blanks when unchanged.
+func eventDiff(event Event) string {
+	added := make(map[string]bool, len(event.Added))
+	for _, v := range event.Added {
+		added[v] = true
+	}
+
+	var lines []string
+	for _, v := range event.Removed {
+		lines = append(lines, "- "+v)
+	}
+	for _, v := range event.After {
+		if added[v] {
+			lines = append(lines, "+ "+v)
+		} else {
+			lines = append(lines, "  "+v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderSlack(events []Event) ([]byte, error) {
+	summary := summarizeEvents(events)
+	blocks := []map[string]any{
+		{"type": "header", "text": map[string]any{"type": "plain_text", "text": summary}},
+	}
+
+	for i, event := range events {
+		if i == maxChatEvents {
+			blocks = append(blocks, map[string]any{
+				"type":     "context",
+				"elements": []map[string]any{{"type": "mrkdwn", "text": fmt.Sprintf("…and %d more", len(events)-i)}},
+			})
+			break
+		}
+
+		var text string
+		switch event.Kind {
+		case EventError:
+			text = fmt.Sprintf(":x: *%s* lookup failed\n```%s```", eventTitle(event), event.Error)
+		case EventFlapping:
+			text = fmt.Sprintf(":warning: *%s* is flapping: %s\n```%s```", eventTitle(event), event.Message, strings.Join(event.After, "\n"))
+		case EventRecovered:
+			text = fmt.Sprintf(":white_check_mark: *%s* recovered after %s", eventTitle(event), plural(event.Failures, "failed check"))
+		default:
+			text = fmt.Sprintf(":arrows_counterclockwise: *%s* changed\n```%s```", eventTitle(event), eventDiff(event))
+		}
+		blocks = append(blocks, map[string]any{
+			"type": "section",
+			"text": map[string]any{"type": "mrkdwn", "text": text},
+		})
+	}
+
+	return json.Marshal(map[string]any{"text": summary, "blocks": blocks})
+}
+
+func renderTeams(events []Event) ([]byte, error) {
+	summary := summarizeEvents(events)
+	themeColor := "FFA500"
+	var sections []map[string]any
+
+	for i, event := range events {
+		if i == maxChatEvents {
+			sections = append(sections, map[string]any{"text": fmt.Sprintf("…and %d more", len(events)-i)})
+			break
+		}
+
+		var facts []map[string]string
+		switch event.Kind {
+		case EventError:
+			themeColor = "D70000"
+			facts = append(facts, map[string]string{"name": "Error", "value": event.Error})
+		case EventFlapping:
+			facts = append(facts,
+				map[string]string{"name": "Flapping", "value": event.Message},
+				map[string]string{"name": "Current", "value": strings.Join(event.After, ", ")},
+			)
+		case EventRecovered:
+			facts = append(facts, map[string]string{"name": "Recovered", "value": "after " + plural(event.Failures, "failed check")})
+		default:
+			facts = append(facts,
+				map[string]string{"name": "Before", "value": strings.Join(event.Before, ", ")},
+				map[string]string{"name": "After", "value": strings.Join(event.After, ", ")},
+			)
+			if len(event.Added) > 0 {
+				facts = append(facts, map[string]string{"name": "Added", "value": strings.Join(event.Added, ", ")})
+			}
+			if len(event.Removed) > 0 {
+				facts = append(facts, map[string]string{"name": "Removed", "value": strings.Join(event.Removed, ", ")})
+			}
+		}
+		sections = append(sections, map[string]any{
+			"activityTitle":    "**" + eventTitle(event) + "**",
+			"activitySubtitle": event.Timestamp.Format(time.RFC3339),
+			"facts":            facts,
+		})
+	}
+
+	return json.Marshal(map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    summary,
+		"title":      summary,
+		"themeColor": themeColor,
+		"sections":   sections,
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseChatRoute(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ChatRoute
+		wantErr  bool
+	}{
+		{"https://hooks.slack.com/services/T/B/X", ChatRoute{URL: "https://hooks.slack.com/services/T/B/X"}, false},
+		{"*.Example.com.=https://hooks.slack.com/a?x=1", ChatRoute{Pattern: "*.example.com", URL: "https://hooks.slack.com/a?x=1"}, false},
+		{"example.com=ftp://example.com", ChatRoute{}, true},
+		{"hooks.slack.com/services", ChatRoute{}, true},
+		{"[=https://hooks.slack.com", ChatRoute{}, true},
+	}
+
+	for _, tt := range tests {
+		route, err := parseChatRoute(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseChatRoute(%q): expected error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseChatRoute(%q): unexpected error: %v", tt.input, err)
+		} else if route != tt.expected {
+			t.Errorf("parseChatRoute(%q): expected %+v, got %+v", tt.input, tt.expected, route)
+		}
+	}
+}
+
+func TestChatNotifier_route(t *testing.T) {
+	n := &ChatNotifier{routes: []ChatRoute{
+		{URL: "default"},
+		{Pattern: "*.example.com", URL: "example"},
+		{Pattern: "example.org", URL: "org"},
+	}}
+
+	tests := map[string]string{
+		"www.example.com":  "example",
+		"a.b.example.com.": "example",
+		"example.org":      "org",
+		"EXAMPLE.ORG":      "org",
+		"example.net":      "default",
+	}
+	for domain, expected := range tests {
+		if got := n.route(domain); got != expected {
+			t.Errorf("route(%s): expected %s, got %s", domain, expected, got)
+		}
+	}
+
+	n.routes = n.routes[1:]
+	if got := n.route("example.net"); got != "" {
+		t.Errorf("expected unrouted domain to be dropped, got %s", got)
+	}
+}
+
+func TestEventDiff(t *testing.T) {
+	event := Event{
+		Before:  []string{"192.0.2.1", "192.0.2.2"},
+		After:   []string{"192.0.2.2", "192.0.2.3"},
+		Added:   []string{"192.0.2.3"},
+		Removed: []string{"192.0.2.1"},
+	}
+	expected := "- 192.0.2.1\n  192.0.2.2\n+ 192.0.2.3"
+	if got := eventDiff(event); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSummarizeEvents(t *testing.T) {
+	events := []Event{{Kind: EventChange}, {Kind: EventChange}, {Kind: EventError}}
+	if got := summarizeEvents(events); got != "DNS Monitor: 2 changes, 1 error" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+	if got := summarizeEvents([]Event{{Kind: EventRecovered}}); got != "DNS Monitor: 1 recovered" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+}
+
+func TestChatNotifier_GroupsAndRoutes(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]map[string]any)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], payload)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	n := NewSlackNotifier([]ChatRoute{
+		{URL: srv.URL + "/ops"},
+		{Pattern: "*.example.org", URL: srv.URL + "/org"},
+	}, time.Second, 0, log.New(&logs, "", 0))
+
+	n.Notify([]Event{
+		{Kind: EventChange, Domain: "example.com", Type: "A", Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2"}, Added: []string{"192.0.2.2"}, Removed: []string{"192.0.2.1"}},
+		{Kind: EventError, Domain: "www.example.org", Type: "A", Error: "SERVFAIL", Failures: 1},
+		{Kind: EventError, Domain: "example.com", Type: "A", Error: "SERVFAIL", Failures: 2},
+		{Kind: EventChange, Domain: "api.example.com", Type: "A", After: []string{"192.0.2.9"}, Added: []string{"192.0.2.9"}},
+	})
+	n.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received["/ops"]) != 1 || len(received["/org"]) != 1 {
+		t.Fatalf("expected one message per destination, got %v (log: %s)", received, logs.String())
+	}
+
+	ops := received["/ops"][0]
+	if ops["text"] != "DNS Monitor: 2 changes" {
+		t.Errorf("unexpected summary: %v", ops["text"])
+	}
+	blocks := ops["blocks"].([]any)
+	if len(blocks) != 3 {
+		t.Fatalf("expected header and two sections, got %d blocks", len(blocks))
+	}
+	text := blocks[1].(map[string]any)["text"].(map[string]any)["text"].(string)
+	if !strings.Contains(text, "*example.com (A)* changed") || !strings.Contains(text, "- 192.0.2.1\n+ 192.0.2.2") {
+		t.Errorf("unexpected section text: %q", text)
+	}
+}
+
+func TestRenderTeams(t *testing.T) {
+	body, err := renderTeams([]Event{
+		{Kind: EventChange, Domain: "example.com", Type: "MX", Server: "1.1.1.1:53", Before: []string{"10 mx1.example.com"}, After: []string{"10 mx2.example.com"}, Added: []string{"10 mx2.example.com"}, Removed: []string{"10 mx1.example.com"}},
+		{Kind: EventError, Domain: "example.net", Type: "A", Error: "TIMEOUT"},
+	})
+	if err != nil {
+		t.Fatalf("renderTeams failed: %v", err)
+	}
+
+	var card struct {
+		Type       string `json:"@type"`
+		Summary    string `json:"summary"`
+		ThemeColor string `json:"themeColor"`
+		Sections   []struct {
+			ActivityTitle string `json:"activityTitle"`
+			Facts         []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"facts"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal(body, &card); err != nil {
+		t.Fatalf("invalid card: %v", err)
+	}
+	if card.Type != "MessageCard" || card.Summary != "DNS Monitor: 1 change, 1 error" || card.ThemeColor != "D70000" {
+		t.Errorf("unexpected card header: %+v", card)
+	}
+	if len(card.Sections) != 2 || card.Sections[0].ActivityTitle != "**example.com (MX) @ 1.1.1.1:53**" {
+		t.Fatalf("unexpected sections: %+v", card.Sections)
+	}
+	if f := card.Sections[0].Facts; len(f) != 4 || f[0].Name != "Before" || f[0].Value != "10 mx1.example.com" || f[2].Name != "Added" {
+		t.Errorf("unexpected facts: %+v", f)
+	}
+	if f := card.Sections[1].Facts; len(f) != 1 || f[0].Value != "TIMEOUT" {
+		t.Errorf("unexpected error facts: %+v", f)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes defined by the monitoring plugin API used by Nagios, Icinga
+// and compatible systems.
+const (
+	CheckOK       = 0
+	CheckWarning  = 1
+	CheckCritical = 2
+	CheckUnknown  = 3
+)
+
+var checkStateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type checkResult struct {
+	label   string
+	record  *DNSRecord
+	state   int
+	problem string
+}
+
+// RunCheck queries every target once and returns the plugin state together
+// with the single summary line to print. Failed lookups and answers that
+// don't match --expect are CRITICAL; slow answers, and servers disagreeing
+// when nothing is expected, are WARNING.
+func RunCheck(config *Config) (int, string) {
+	client := NewDNSClient(config.Servers, 5*time.Second)
+	var results []checkResult
+
+	for _, target := range config.targets() {
+		domain := target.Domain
+		answers := make(map[string]bool)
+		var domainResults []int
+
+		for _, server := range config.queryServers() {
+			result := checkResult{label: target.String()}
+			if server != "" {
+				result.label += " @" + server
+			}
+
+			var err error
+			if server == "" {
+				result.record, err = client.Query(domain, target.Type)
+			} else {
+				result.record, err = client.QueryServer(server, domain, target.Type)
+			}
+
+			switch {
+			case err != nil:
+				result.state = CheckCritical
+				result.problem = err.Error()
+			case len(target.Expected) > 0 && !result.record.Matches(target.Expected):
+				result.state = CheckCritical
+				result.problem = fmt.Sprintf("expected %s, got %s", formatValues(target.Expected), result.record.String())
+			case config.RTTCrit > 0 && result.record.RTT > config.RTTCrit:
+				result.state = CheckCritical
+				result.problem = fmt.Sprintf("answered in %s (critical %s)", formatRTT(result.record.RTT), config.RTTCrit)
+			case config.RTTWarn > 0 && result.record.RTT > config.RTTWarn:
+				result.state = CheckWarning
+				result.problem = fmt.Sprintf("answered in %s (warning %s)", formatRTT(result.record.RTT), config.RTTWarn)
+			}
+			if err == nil {
+				answers[result.record.String()] = true
+			}
+
+			domainResults = append(domainResults, len(results))
+			results = append(results, result)
+		}
+
+		if len(target.Expected) == 0 && len(answers) > 1 {
+			for _, i := range domainResults {
+				if results[i].state == CheckOK && results[i].record != nil {
+					results[i].state = CheckWarning
+					results[i].problem = fmt.Sprintf("servers disagree, got %s", results[i].record.String())
+				}
+			}
+		}
+	}
+
+	state := CheckOK
+	var problems []string
+	for _, r := range results {
+		if r.state > state {
+			state = r.state
+		}
+		if r.problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", r.label, r.problem))
+		}
+	}
+
+	var summary string
+	switch {
+	case len(problems) > 0:
+		summary = strings.Join(problems, "; ")
+	case len(results) == 1:
+		summary = fmt.Sprintf("%s: %s in %s", results[0].label, results[0].record.String(), formatRTT(results[0].record.RTT))
+	default:
+		summary = fmt.Sprintf("%d of %d queries OK", len(results), len(results))
+	}
+
+	return state, fmt.Sprintf("DNS %s - %s | %s", checkStateNames[state], summary, checkPerfdata(config, results))
+}
+
+// checkPerfdata formats the round-trip times as plugin performance data:
+// 'label'=value[UOM];warn;crit;min
+func checkPerfdata(config *Config, results []checkResult) string {
+	threshold := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.6f", d.Seconds())
+	}
+
+	var perf []string
+	for _, r := range results {
+		if r.record == nil {
+			continue
+		}
+		label := fmt.Sprintf("%s_%s@%s", r.record.Domain, r.record.Type, r.record.Server)
+		perf = append(perf, fmt.Sprintf("'%s'=%.6fs;%s;%s;0", label, r.record.RTT.Seconds(), threshold(config.RTTWarn), threshold(config.RTTCrit)))
+	}
+	perf = append(perf, fmt.Sprintf("failures=%d;;;0;%d", countFailures(results), len(results)))
+	return strings.Join(perf, " ")
+}
+
+func countFailures(results []checkResult) int {
+	n := 0
+	for _, r := range results {
+		if r.record == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func runCheckCommand(args []string) int {
+	config, err := ParseArgs(args)
+	if err != nil {
+		fmt.Printf("DNS UNKNOWN - %v\n", err)
+		return CheckUnknown
+	}
+	if config.ShowHelp {
+		printCheckUsage()
+		return CheckOK
+	}
+	if config.ShowVersion {
+		fmt.Printf("DNS Monitor Tool v%s\n", Version)
+		return CheckOK
+	}
+
+	state, line := RunCheck(config)
+	fmt.Println(line)
+	return state
+}
+
+func printCheckUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor check [OPTIONS] DOMAIN [DOMAIN...]
+
+Runs a single round of queries and exits with a monitoring plugin status:
+0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN.
+
+OPTIONS:
+    -t, --type TYPE          DNS record type [default: A]
+    -s, --server SERVER      DNS server to query (multiple allowed) [default: nameservers in /etc/resolv.conf]
+    --all-servers           Query all major DNS servers (8.8.8.8, 1.1.1.1, 1.0.0.1)
+    -e, --expect VALUE      Expected record value (multiple allowed); CRITICAL on mismatch
+    --rtt-warn DURATION     WARNING when a query takes longer than DURATION
+    --rtt-crit DURATION     CRITICAL when a query takes longer than DURATION
+    -h, --help              Display help
+
+EXAMPLES:
+    dns-monitor check -e 203.0.113.1 example.com
+    dns-monitor check --all-servers --rtt-warn 200ms --rtt-crit 1s example.com
+`)
+}
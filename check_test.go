@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCheck(t *testing.T) {
+	answering := func(last byte, delay time.Duration) func(q *dnsMessage) *dnsMessage {
+		return func(q *dnsMessage) *dnsMessage {
+			time.Sleep(delay)
+			if q.Questions[0].Name == "missing.example.com." {
+				return &dnsMessage{Rcode: RcodeNXDomain}
+			}
+			return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, last})}}
+		}
+	}
+	primary := startStubServer(t, answering(1, 0))
+	secondary := startStubServer(t, answering(1, 0))
+	stale := startStubServer(t, answering(9, 0))
+	slow := startStubServer(t, answering(1, 100*time.Millisecond))
+
+	tests := []struct {
+		name     string
+		config   *Config
+		expected int
+		contains []string
+	}{
+		{
+			name:     "single answer ok",
+			config:   &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{primary}},
+			expected: CheckOK,
+			contains: []string{"DNS OK - example.com (A): [192.0.2.1] in ", "| 'example.com_A@" + primary + "'=", "failures=0;;;0;1"},
+		},
+		{
+			name:     "expected value matches",
+			config:   &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{primary, secondary}, Expected: []string{"192.0.2.1"}},
+			expected: CheckOK,
+			contains: []string{"DNS OK - 2 of 2 queries OK"},
+		},
+		{
+			name:     "expected value mismatch",
+			config:   &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{stale}, Expected: []string{"192.0.2.1"}},
+			expected: CheckCritical,
+			contains: []string{"DNS CRITICAL - example.com (A): expected [192.0.2.1], got [192.0.2.9]"},
+		},
+		{
+			name:     "lookup failure",
+			config:   &Config{Domains: []string{"missing.example.com"}, RecordType: "A", Servers: []string{primary}},
+			expected: CheckCritical,
+			contains: []string{"NXDOMAIN", "failures=1;;;0;1"},
+		},
+		{
+			name:     "servers disagree",
+			config:   &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{primary, stale}},
+			expected: CheckWarning,
+			contains: []string{"DNS WARNING - ", "@" + stale + ": servers disagree, got [192.0.2.9]"},
+		},
+		{
+			name:     "slow answer",
+			config:   &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{slow}, RTTWarn: 20 * time.Millisecond, RTTCrit: 5 * time.Second},
+			expected: CheckWarning,
+			contains: []string{"(warning 20ms)", ";0.020000;5.000000;0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, line := RunCheck(tt.config)
+			if state != tt.expected {
+				t.Errorf("expected state %d, got %d (%s)", tt.expected, state, line)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(line, want) {
+					t.Errorf("expected %q in %q", want, line)
+				}
+			}
+			if strings.Count(line, "\n") != 0 {
+				t.Errorf("expected a single line, got %q", line)
+			}
+		})
+	}
+}
+
+func TestRunCheckCommand_Unknown(t *testing.T) {
+	if state := runCheckCommand([]string{"check", "--bogus", "example.com"}); state != CheckUnknown {
+		t.Errorf("expected UNKNOWN for invalid arguments, got %d", state)
+	}
+}
@@ -1,9 +1,40 @@
 package main
 
+import "os"
+
 const (
 	ColorReset  = "\033[0m"
 	ColorRed    = "\033[31m"
 	ColorGreen  = "\033[32m"
 	ColorYellow = "\033[33m"
 	ColorBlue   = "\033[34m"
-)
\ No newline at end of file
+	ColorBold   = "\033[1m"
+	ColorDim    = "\033[2m"
+)
+
+// Color modes accepted by --color.
+const (
+	ColorModeAuto   = "auto"
+	ColorModeAlways = "always"
+	ColorModeNever  = "never"
+)
+
+// colorEnabled decides whether output written to f is colored. An explicit
+// always or never wins. In auto mode a non-empty NO_COLOR disables colors
+// and a FORCE_COLOR other than "0" or "false" enables them; otherwise colors
+// are used only when f is a terminal.
+func colorEnabled(mode string, f *os.File) bool {
+	switch mode {
+	case ColorModeAlways:
+		return true
+	case ColorModeNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" && force != "0" && force != "false" {
+		return true
+	}
+	return isTerminal(f)
+}
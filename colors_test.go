@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestColorEnabled(t *testing.T) {
+	// Test output is not a terminal, so auto mode depends on the environment.
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		mode       string
+		noColor    string
+		forceColor string
+		expected   bool
+	}{
+		{ColorModeAuto, "", "", false},
+		{ColorModeAuto, "", "1", true},
+		{ColorModeAuto, "", "0", false},
+		{ColorModeAuto, "1", "1", false},
+		{ColorModeAlways, "1", "", true},
+		{ColorModeNever, "", "1", false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("NO_COLOR", tt.noColor)
+		t.Setenv("FORCE_COLOR", tt.forceColor)
+		if got := colorEnabled(tt.mode, f); got != tt.expected {
+			t.Errorf("colorEnabled(%s) with NO_COLOR=%q FORCE_COLOR=%q: expected %t, got %t", tt.mode, tt.noColor, tt.forceColor, tt.expected, got)
+		}
+	}
+}
+
+func TestMonitor_paint(t *testing.T) {
+	m := &Monitor{config: &Config{}}
+	if got := m.paint("header", ColorBold); got != ColorBold+"header"+ColorReset {
+		t.Errorf("unexpected painted text: %q", got)
+	}
+	m.config.NoColor = true
+	if got := m.paint("header", ColorBold); got != "header" {
+		t.Errorf("expected plain text without colors, got %q", got)
+	}
+}
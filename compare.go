@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CompareExact    = "exact"
+	CompareUnion    = "union"
+	CompareContains = "contains"
+
+	defaultUnionWindow = 10
+)
+
+// ComparePolicy selects which values of an answer are tracked for changes:
+// the answer itself (exact), the union of the last Window answers (union),
+// or only the expected values present in it (contains).
+type ComparePolicy struct {
+	Mode   string
+	Window int
+}
+
+func (p ComparePolicy) String() string {
+	if p.Mode == CompareUnion {
+		return fmt.Sprintf("%s:%d", p.Mode, p.Window)
+	}
+	return p.Mode
+}
+
+// CompareRule applies Policy to domains matching Pattern. An empty pattern
+// matches every domain not matched by another rule.
+type CompareRule struct {
+	Pattern string
+	Policy  ComparePolicy
+}
+
+func (r CompareRule) String() string {
+	if r.Pattern == "" {
+		return r.Policy.String()
+	}
+	return r.Pattern + "=" + r.Policy.String()
+}
+
+// parseCompareRule parses "[PATTERN=]MODE", where MODE is exact, contains,
+// union or union:N.
+func parseCompareRule(value string) (CompareRule, error) {
+	var rule CompareRule
+	if pattern, mode, ok := strings.Cut(value, "="); ok {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return rule, fmt.Errorf("invalid domain pattern: %s", pattern)
+		}
+		rule.Pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		value = mode
+	}
+
+	mode, window, hasWindow := strings.Cut(strings.ToLower(value), ":")
+	switch mode {
+	case CompareExact, CompareContains:
+		if hasWindow {
+			return rule, fmt.Errorf("%s does not take a window", mode)
+		}
+		rule.Policy = ComparePolicy{Mode: mode}
+	case CompareUnion:
+		rule.Policy = ComparePolicy{Mode: mode, Window: defaultUnionWindow}
+		if hasWindow {
+			n, err := strconv.Atoi(window)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid union window: %s", window)
+			}
+			rule.Policy.Window = n
+		}
+	default:
+		return rule, fmt.Errorf("unknown comparison mode: %s (use exact, union[:N] or contains)", value)
+	}
+	return rule, nil
+}
+
+// matchDomain reports whether domain matches pattern, a domain name or a
+// wildcard such as "*.example.com". Both are compared case-insensitively and
+// without trailing dots.
+func matchDomain(pattern, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	ok, _ := path.Match(pattern, domain)
+	return ok
+}
+
+// comparePolicy returns the comparison policy for domain: the first rule
+// whose pattern matches it, else the first rule without a pattern, else exact.
+func (c *Config) comparePolicy(domain string) ComparePolicy {
+	policy := ComparePolicy{Mode: CompareExact}
+	fallback := false
+	for _, rule := range c.Compare {
+		if rule.Pattern == "" {
+			if !fallback {
+				policy = rule.Policy
+				fallback = true
+			}
+			continue
+		}
+		if matchDomain(rule.Pattern, domain) {
+			return rule.Policy
+		}
+	}
+	return policy
+}
+
+// compareState is what the union and contains policies remember per record.
+type compareState struct {
+	window   [][]string
+	baseline []string
+}
+
+// compareRecord returns the record whose values are tracked for changes
+// under the policy for record's domain. While a union pool is still filling
+// up, up to and including the answer that completes it, it also returns a
+// message, and the pool should not be reported as changed. The contains
+// policy looks for expected, or else for the first answer seen.
+func (m *Monitor) compareRecord(key string, record *DNSRecord, expected []string) (*DNSRecord, string) {
+	policy := m.config.comparePolicy(record.Domain)
+	if policy.Mode == CompareExact {
+		return record, ""
+	}
+
+	if m.compare == nil {
+		m.compare = make(map[string]*compareState)
+	}
+	state, ok := m.compare[key]
+	if !ok {
+		state = &compareState{}
+		m.compare[key] = state
+	}
+
+	tracked := *record
+	switch policy.Mode {
+	case CompareUnion:
+		learning := len(state.window) < policy.Window
+		state.window = append(state.window, record.Values)
+		if len(state.window) > policy.Window {
+			state.window = state.window[1:]
+		}
+		tracked.Values = unionValues(state.window)
+		if learning {
+			return &tracked, fmt.Sprintf("learning pool %d/%d", len(state.window), policy.Window)
+		}
+
+	case CompareContains:
+		if state.baseline == nil {
+			state.baseline = expected
+			if len(state.baseline) == 0 {
+				state.baseline = record.Values
+			}
+		}
+		tracked.Values = presentValues(record.Values, state.baseline)
+	}
+	return &tracked, ""
+}
+
+func unionValues(answers [][]string) []string {
+	seen := make(map[string]bool)
+	var values []string
+	for _, answer := range answers {
+		for _, v := range answer {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// presentValues returns the values of expected found in answer, ignoring
+// trailing dots on names.
+func presentValues(answer, expected []string) []string {
+	have := make(map[string]bool, len(answer))
+	for _, v := range answer {
+		have[strings.TrimSuffix(v, ".")] = true
+	}
+	values := []string{}
+	for _, v := range expected {
+		if have[strings.TrimSuffix(v, ".")] {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCompareRule(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected CompareRule
+		wantErr  bool
+	}{
+		{"exact", CompareRule{Policy: ComparePolicy{Mode: CompareExact}}, false},
+		{"Contains", CompareRule{Policy: ComparePolicy{Mode: CompareContains}}, false},
+		{"union", CompareRule{Policy: ComparePolicy{Mode: CompareUnion, Window: defaultUnionWindow}}, false},
+		{"*.cdn.example.com=union:20", CompareRule{Pattern: "*.cdn.example.com", Policy: ComparePolicy{Mode: CompareUnion, Window: 20}}, false},
+		{"union:0", CompareRule{}, true},
+		{"exact:3", CompareRule{}, true},
+		{"subset", CompareRule{}, true},
+		{"=exact", CompareRule{}, true},
+	}
+
+	for _, tt := range tests {
+		rule, err := parseCompareRule(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCompareRule(%q): expected error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCompareRule(%q): unexpected error: %v", tt.input, err)
+		} else if rule != tt.expected {
+			t.Errorf("parseCompareRule(%q): expected %+v, got %+v", tt.input, tt.expected, rule)
+		}
+	}
+}
+
+func TestConfig_comparePolicy(t *testing.T) {
+	config := &Config{Compare: []CompareRule{
+		{Policy: ComparePolicy{Mode: CompareContains}},
+		{Pattern: "*.cdn.example.com", Policy: ComparePolicy{Mode: CompareUnion, Window: 5}},
+	}}
+
+	if p := config.comparePolicy("img.CDN.example.com."); p.Mode != CompareUnion || p.Window != 5 {
+		t.Errorf("expected union:5 for CDN host, got %s", p)
+	}
+	if p := config.comparePolicy("example.com"); p.Mode != CompareContains {
+		t.Errorf("expected default rule for other domains, got %s", p)
+	}
+	if p := (&Config{}).comparePolicy("example.com"); p.Mode != CompareExact {
+		t.Errorf("expected exact without rules, got %s", p)
+	}
+}
+
+// testAddrs returns the addresses 192.0.2.N for each last octet N.
+func testAddrs(lasts ...byte) [][]byte {
+	var addrs [][]byte
+	for _, last := range lasts {
+		addrs = append(addrs, []byte{192, 0, 2, last})
+	}
+	return addrs
+}
+
+func newCompareMonitor(t *testing.T, config *Config, lasts ...byte) (*Monitor, *stubAnswer) {
+	t.Helper()
+	server, answer := startAnswerServer(t, testAddrs(lasts...)...)
+
+	config.Domains = []string{"example.com"}
+	config.RecordType = "A"
+	config.Servers = []string{server}
+	config.NoColor = true
+	monitor, _ := newTestMonitor(t, config, server)
+	return monitor, answer
+}
+
+func TestMonitor_compareUnion(t *testing.T) {
+	monitor, answer := newCompareMonitor(t, &Config{Compare: []CompareRule{{Policy: ComparePolicy{Mode: CompareUnion, Window: 3}}}}, 1, 2)
+
+	steps := []struct {
+		answer []byte
+		status string
+		values int
+	}{
+		{[]byte{1, 2}, StatusInitial, 2},
+		{[]byte{2, 3}, StatusUnchanged, 3}, // still learning the pool
+		{[]byte{1, 3}, StatusUnchanged, 3},
+		{[]byte{3, 1}, StatusUnchanged, 3}, // rotating within the pool
+		{[]byte{2, 1}, StatusUnchanged, 3},
+		{[]byte{4, 1}, StatusChanged, 4}, // a value outside the pool
+		{[]byte{4, 1}, StatusChanged, 3}, // 3 aged out
+		{[]byte{4, 1}, StatusChanged, 2}, // 2 aged out
+		{[]byte{4, 1}, StatusUnchanged, 2},
+	}
+	for i, step := range steps {
+		answer.set(testAddrs(step.answer...)...)
+		obs := monitor.observe(monitor.config.targets()[0], "")
+		if obs.Status != step.status || len(obs.Values) != step.values {
+			t.Errorf("step %d: expected %s with %d values, got %s with %v", i, step.status, step.values, obs.Status, obs.Values)
+		}
+	}
+}
+
+func TestMonitor_compareUnionFillingTick(t *testing.T) {
+	monitor, answer := newCompareMonitor(t, &Config{Compare: []CompareRule{{Policy: ComparePolicy{Mode: CompareUnion, Window: 3}}}}, 1)
+
+	// The pool only completes on the last learning answer, which becomes the
+	// baseline rather than a change.
+	for i, last := range []byte{1, 2, 3, 1} {
+		answer.set(testAddrs(last)...)
+		obs := monitor.observe(monitor.config.targets()[0], "")
+		if obs.Status == StatusChanged {
+			t.Errorf("step %d: unexpected change %v -> %v (%s)", i, obs.Previous, obs.Values, obs.Message)
+		}
+	}
+	if got := monitor.lastRecords["example.com:A"]; got == nil || len(got.Values) != 3 {
+		t.Errorf("expected the full pool as baseline, got %+v", got)
+	}
+
+	// The pool survives a restart through the state file.
+	monitor.config.StateFile = filepath.Join(t.TempDir(), "state.json")
+	monitor.saveState()
+	restarted, _ := newCompareMonitor(t, &Config{Compare: monitor.config.Compare, StateFile: monitor.config.StateFile})
+	restarted.dnsClient = monitor.dnsClient
+	if _, err := restarted.restoreState(); err != nil {
+		t.Fatalf("restoreState failed: %v", err)
+	}
+	answer.set(testAddrs(2)...)
+	if obs := restarted.observe(restarted.config.targets()[0], ""); obs.Status != StatusUnchanged || obs.Message != "" || len(obs.Values) != 3 {
+		t.Errorf("expected the restored pool to be used without learning, got %+v", obs)
+	}
+}
+
+func TestMonitor_compareContains(t *testing.T) {
+	monitor, answer := newCompareMonitor(t, &Config{
+		Compare:  []CompareRule{{Policy: ComparePolicy{Mode: CompareContains}}},
+		Expected: []string{"192.0.2.1", "192.0.2.2"},
+	}, 1, 2, 3)
+
+	steps := []struct {
+		answer  []byte
+		status  string
+		matches bool
+	}{
+		{[]byte{1, 2, 3}, StatusInitial, true},
+		{[]byte{1, 2, 9}, StatusUnchanged, true}, // extra values are ignored
+		{[]byte{2, 9}, StatusChanged, false},     // expected 192.0.2.1 is missing
+		{[]byte{1, 2}, StatusChanged, true},
+	}
+	for i, step := range steps {
+		answer.set(testAddrs(step.answer...)...)
+		obs := monitor.observe(monitor.config.targets()[0], "")
+		if obs.Status != step.status || obs.Matches == nil || *obs.Matches != step.matches {
+			t.Errorf("step %d: expected %s (matches %t), got %s (%+v)", i, step.status, step.matches, obs.Status, obs)
+		}
+	}
+}
+
+func TestMonitor_compareContainsBaseline(t *testing.T) {
+	monitor, answer := newCompareMonitor(t, &Config{Compare: []CompareRule{{Policy: ComparePolicy{Mode: CompareContains}}}}, 1, 2)
+
+	monitor.observe(monitor.config.targets()[0], "")
+	answer.set(testAddrs(1, 2, 3)...)
+	if obs := monitor.observe(monitor.config.targets()[0], ""); obs.Status != StatusUnchanged {
+		t.Errorf("additions to the first answer should be ignored, got %s", obs.Status)
+	}
+	answer.set(testAddrs(2, 3)...)
+	if obs := monitor.observe(monitor.config.targets()[0], ""); obs.Status != StatusChanged || len(obs.Values) != 1 {
+		t.Errorf("expected removal from the first answer to be a change, got %s %v", obs.Status, obs.Values)
+	}
+}
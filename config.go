@@ -2,29 +2,87 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Domains      []string
-	RecordType   string
-	Interval     time.Duration
-	Servers      []string
-	AllServers   bool
-	UntilChange  bool
-	OutputFile   string
-	NoColor      bool
-	ShowHelp     bool
-	ShowVersion  bool
+	Domains         []string
+	Targets         []Target
+	DomainsFiles    []string
+	ZoneFiles       []string
+	ZoneOrigin      string
+	RecordType      string
+	Interval        time.Duration
+	Servers         []string
+	AllServers      bool
+	UntilChange     bool
+	Confirm         int
+	FlapThreshold   int
+	FlapWindow      time.Duration
+	Compare         []CompareRule
+	OutputFile      string
+	StateFile       string
+	HistoryFile     string
+	NoColor         bool
+	Color           string
+	JSON            bool
+	TUI             bool
+	DNSSEC          bool
+	FCrDNS          bool
+	MailAuth        bool
+	TransferZones   []string
+	TransferServer  string
+	TSIGKey         *TSIGKey
+	NotifyListen    string
+	NotifyFrom      []string
+	PropagationWait time.Duration
+	SigExpiryWarn   time.Duration
+	Expected        []string
+	RTTWarn         time.Duration
+	RTTCrit         time.Duration
+	MetricsListen   string
+	Listen          string
+	APIToken        string
+	Webhooks        []string
+	WebhookSecret   string
+	WebhookTimeout  time.Duration
+	WebhookRetries  int
+	SlackRoutes     []ChatRoute
+	TeamsRoutes     []ChatRoute
+	SMTP            SMTPConfig
+	OnChange        string
+	OnChangeTimeout time.Duration
+	ShowHelp        bool
+	ShowVersion     bool
 }
 
 func ParseArgs(args []string) (*Config, error) {
 	config := &Config{
-		RecordType: "A",
-		Interval:   5 * time.Second,
-		Servers:    []string{},
+		RecordType:      "A",
+		Interval:        5 * time.Second,
+		Servers:         []string{},
+		Color:           ColorModeAuto,
+		SigExpiryWarn:   72 * time.Hour,
+		Confirm:         1,
+		FlapWindow:      10 * time.Minute,
+		PropagationWait: 10 * time.Minute,
+		WebhookSecret:   os.Getenv("DNS_MONITOR_WEBHOOK_SECRET"),
+		WebhookTimeout:  10 * time.Second,
+		WebhookRetries:  3,
+		HistoryFile:     os.Getenv("DNS_MONITOR_HISTORY"),
+		APIToken:        os.Getenv("DNS_MONITOR_API_TOKEN"),
+		OnChangeTimeout: 30 * time.Second,
+		SMTP: SMTPConfig{
+			Password:   os.Getenv("DNS_MONITOR_SMTP_PASSWORD"),
+			Digest:     time.Minute,
+			ErrorAfter: 3,
+			Timeout:    30 * time.Second,
+		},
 	}
 
 	i := 1
@@ -70,15 +128,334 @@ func ParseArgs(args []string) (*Config, error) {
 		case arg == "--until-change":
 			config.UntilChange = true
 			i++
+		case arg == "--confirm" || arg == "--flap-threshold":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 || (arg == "--confirm" && n < 1) {
+				return nil, fmt.Errorf("invalid value for %s: %s", arg, args[i+1])
+			}
+			if arg == "--confirm" {
+				config.Confirm = n
+			} else {
+				config.FlapThreshold = n
+			}
+			i += 2
+		case arg == "--flap-window":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid flap window: %v", err)
+			}
+			config.FlapWindow = duration
+			i += 2
+		case arg == "--compare":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			rule, err := parseCompareRule(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid comparison: %v", err)
+			}
+			config.Compare = append(config.Compare, rule)
+			i += 2
 		case arg == "-o" || arg == "--output":
 			if i+1 >= len(args) {
 				return nil, fmt.Errorf("option %s requires a value", arg)
 			}
 			config.OutputFile = args[i+1]
 			i += 2
+		case arg == "--state-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.StateFile = args[i+1]
+			i += 2
+		case arg == "--history":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.HistoryFile = args[i+1]
+			i += 2
 		case arg == "--no-color":
 			config.NoColor = true
+			config.Color = ColorModeNever
+			i++
+		case arg == "--color":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			mode := strings.ToLower(args[i+1])
+			if mode != ColorModeAuto && mode != ColorModeAlways && mode != ColorModeNever {
+				return nil, fmt.Errorf("invalid color mode: %s (use auto, always or never)", args[i+1])
+			}
+			config.Color = mode
+			config.NoColor = mode == ColorModeNever
+			i += 2
+		case arg == "--json":
+			config.JSON = true
+			i++
+		case arg == "--tui":
+			config.TUI = true
 			i++
+		case arg == "--dnssec":
+			config.DNSSEC = true
+			i++
+		case arg == "--fcrdns":
+			config.FCrDNS = true
+			i++
+		case arg == "--mail-auth":
+			config.MailAuth = true
+			i++
+		case arg == "--sig-expiry":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature expiry window: %v", err)
+			}
+			config.SigExpiryWarn = duration
+			i += 2
+		case arg == "--axfr":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.TransferZones = append(config.TransferZones, strings.ToLower(strings.TrimSuffix(args[i+1], ".")))
+			i += 2
+		case arg == "--xfr-server":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			server := args[i+1]
+			if !strings.Contains(server, ":") {
+				server += ":53"
+			}
+			config.TransferServer = server
+			i += 2
+		case arg == "--tsig":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			key, err := parseTSIGKey(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			config.TSIGKey = key
+			i += 2
+		case arg == "--notify-listen":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.NotifyListen = args[i+1]
+			i += 2
+		case arg == "--notify-from":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			if _, _, err := net.ParseCIDR(args[i+1]); err != nil && net.ParseIP(args[i+1]) == nil {
+				return nil, fmt.Errorf("invalid NOTIFY source: %s", args[i+1])
+			}
+			config.NotifyFrom = append(config.NotifyFrom, args[i+1])
+			i += 2
+		case arg == "--propagation-timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid propagation timeout: %v", err)
+			}
+			config.PropagationWait = duration
+			i += 2
+		case arg == "-e" || arg == "--expect":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.Expected = append(config.Expected, args[i+1])
+			i += 2
+		case arg == "--metrics-listen":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.MetricsListen = args[i+1]
+			i += 2
+		case arg == "--domains-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.DomainsFiles = append(config.DomainsFiles, args[i+1])
+			i += 2
+		case arg == "--zone-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.ZoneFiles = append(config.ZoneFiles, args[i+1])
+			i += 2
+		case arg == "--origin":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.ZoneOrigin = strings.ToLower(strings.TrimSuffix(args[i+1], "."))
+			i += 2
+		case arg == "--listen":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.Listen = args[i+1]
+			i += 2
+		case arg == "--api-token":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.APIToken = args[i+1]
+			i += 2
+		case arg == "--webhook":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			if !strings.HasPrefix(args[i+1], "http://") && !strings.HasPrefix(args[i+1], "https://") {
+				return nil, fmt.Errorf("invalid webhook URL: %s", args[i+1])
+			}
+			config.Webhooks = append(config.Webhooks, args[i+1])
+			i += 2
+		case arg == "--webhook-secret":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.WebhookSecret = args[i+1]
+			i += 2
+		case arg == "--webhook-timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid webhook timeout: %v", err)
+			}
+			config.WebhookTimeout = duration
+			i += 2
+		case arg == "--webhook-retries":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			retries, err := strconv.Atoi(args[i+1])
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("invalid webhook retries: %s", args[i+1])
+			}
+			config.WebhookRetries = retries
+			i += 2
+		case arg == "--slack" || arg == "--teams":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			route, err := parseChatRoute(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s destination: %v", arg[2:], err)
+			}
+			if arg == "--slack" {
+				config.SlackRoutes = append(config.SlackRoutes, route)
+			} else {
+				config.TeamsRoutes = append(config.TeamsRoutes, route)
+			}
+			i += 2
+		case arg == "--smtp":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			addr := args[i+1]
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, "587")
+			}
+			config.SMTP.Addr = addr
+			i += 2
+		case arg == "--smtp-starttls":
+			config.SMTP.StartTLS = true
+			i++
+		case arg == "--smtp-user":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.SMTP.Username = args[i+1]
+			i += 2
+		case arg == "--smtp-password":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.SMTP.Password = args[i+1]
+			i += 2
+		case arg == "--mail-from":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.SMTP.From = args[i+1]
+			i += 2
+		case arg == "--mail-to":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			for _, to := range strings.Split(args[i+1], ",") {
+				if to = strings.TrimSpace(to); to != "" {
+					config.SMTP.To = append(config.SMTP.To, to)
+				}
+			}
+			i += 2
+		case arg == "--mail-digest":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid mail digest window: %v", err)
+			}
+			config.SMTP.Digest = duration
+			i += 2
+		case arg == "--mail-errors-after":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid error count: %s", args[i+1])
+			}
+			config.SMTP.ErrorAfter = count
+			i += 2
+		case arg == "--on-change":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.OnChange = args[i+1]
+			i += 2
+		case arg == "--on-change-timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid command timeout: %v", err)
+			}
+			config.OnChangeTimeout = duration
+			i += 2
+		case arg == "--rtt-warn" || arg == "--rtt-crit":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid RTT threshold: %v", err)
+			}
+			if arg == "--rtt-warn" {
+				config.RTTWarn = duration
+			} else {
+				config.RTTCrit = duration
+			}
+			i += 2
+		case arg == "--":
+			config.Domains = append(config.Domains, args[i+1:]...)
+			i = len(args)
 		case strings.HasPrefix(arg, "-"):
 			return nil, fmt.Errorf("unknown option: %s", arg)
 		default:
@@ -91,10 +468,50 @@ func ParseArgs(args []string) (*Config, error) {
 		config.Servers = []string{"8.8.8.8:53", "1.1.1.1:53", "1.0.0.1:53"}
 	}
 
-	if len(config.Domains) == 0 && !config.ShowHelp && !config.ShowVersion {
+	// Files are read once all options are known, since entries without a
+	// type or expected values use the global ones.
+	for _, path := range config.DomainsFiles {
+		targets, err := readDomainsFile(path, config.RecordType, config.Expected)
+		if err != nil {
+			return nil, err
+		}
+		config.addTargets(targets)
+	}
+	for _, path := range config.ZoneFiles {
+		records, err := readZoneFile(path, config.ZoneOrigin)
+		if err != nil {
+			return nil, err
+		}
+		targets, skipped := zoneTargets(records, isValidRecordType)
+		if len(skipped) > 0 {
+			log.Printf("Warning: %s: not monitoring record types %s", path, strings.Join(skipped, ", "))
+		}
+		config.addTargets(targets)
+	}
+
+	if config.TSIGKey == nil && os.Getenv("DNS_MONITOR_TSIG") != "" {
+		key, err := parseTSIGKey(os.Getenv("DNS_MONITOR_TSIG"))
+		if err != nil {
+			return nil, fmt.Errorf("DNS_MONITOR_TSIG: %v", err)
+		}
+		config.TSIGKey = key
+	}
+	if len(config.TransferZones) > 0 && config.transferServer() == "" {
+		return nil, fmt.Errorf("--axfr requires --xfr-server or --server")
+	}
+	if config.NotifyListen != "" && len(config.NotifyFrom) == 0 {
+		return nil, fmt.Errorf("--notify-listen requires --notify-from")
+	}
+
+	// With the API listening, targets can be added at runtime.
+	if len(config.targets()) == 0 && len(config.TransferZones) == 0 && config.Listen == "" && !config.ShowHelp && !config.ShowVersion {
 		return nil, fmt.Errorf("at least one domain must be specified")
 	}
 
+	if config.SMTP.Addr != "" && (config.SMTP.From == "" || len(config.SMTP.To) == 0) {
+		return nil, fmt.Errorf("--smtp requires --mail-from and --mail-to")
+	}
+
 	if !isValidRecordType(config.RecordType) {
 		return nil, fmt.Errorf("unsupported record type: %s", config.RecordType)
 	}
@@ -103,6 +520,13 @@ func ParseArgs(args []string) (*Config, error) {
 }
 
 func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "ms") {
+		milliseconds, err := strconv.Atoi(s[:len(s)-2])
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(milliseconds) * time.Millisecond, nil
+	}
 	if strings.HasSuffix(s, "s") {
 		seconds, err := strconv.Atoi(s[:len(s)-1])
 		if err != nil {
@@ -124,10 +548,17 @@ func parseDuration(s string) (time.Duration, error) {
 		}
 		return time.Duration(hours) * time.Hour, nil
 	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
 
 	seconds, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, fmt.Errorf("invalid duration format: %s (use formats like 5s, 2m, 1h)", s)
+		return 0, fmt.Errorf("invalid duration format: %s (use formats like 500ms, 5s, 2m, 1h, 3d)", s)
 	}
 	return time.Duration(seconds) * time.Second, nil
 }
@@ -142,14 +573,91 @@ func isValidRecordType(recordType string) bool {
 	return false
 }
 
+// queryServers returns the servers to check every domain against. With a
+// single server (or none) queries go through the client's failover order,
+// represented by the empty string.
+func (c *Config) queryServers() []string {
+	if len(c.Servers) > 1 {
+		return c.Servers
+	}
+	return []string{""}
+}
+
 func (c *Config) Print() {
 	fmt.Printf("Domains: %v\n", c.Domains)
+	for _, path := range c.DomainsFiles {
+		fmt.Printf("Domains File: %s\n", path)
+	}
+	for _, path := range c.ZoneFiles {
+		fmt.Printf("Zone File: %s\n", path)
+	}
 	fmt.Printf("Record Type: %s\n", c.RecordType)
 	fmt.Printf("Interval: %s\n", c.Interval)
 	fmt.Printf("Servers: %v\n", c.Servers)
 	fmt.Printf("Until Change: %t\n", c.UntilChange)
+	if c.Confirm > 1 {
+		fmt.Printf("Confirm: %d\n", c.Confirm)
+	}
+	for _, rule := range c.Compare {
+		fmt.Printf("Compare: %s\n", rule)
+	}
+	if c.FlapThreshold > 0 {
+		fmt.Printf("Flap Detection: more than %d changes within %s\n", c.FlapThreshold, c.FlapWindow)
+	}
 	if c.OutputFile != "" {
 		fmt.Printf("Output File: %s\n", c.OutputFile)
 	}
-	fmt.Printf("No Color: %t\n", c.NoColor)
-}
\ No newline at end of file
+	if c.StateFile != "" {
+		fmt.Printf("State File: %s\n", c.StateFile)
+	}
+	if c.HistoryFile != "" {
+		fmt.Printf("History File: %s\n", c.HistoryFile)
+	}
+	fmt.Printf("Color: %s\n", c.Color)
+	fmt.Printf("JSON: %t\n", c.JSON)
+	if c.DNSSEC {
+		fmt.Printf("DNSSEC: signature expiry window %s\n", c.SigExpiryWarn)
+	}
+	if c.FCrDNS {
+		fmt.Println("FCrDNS: reverse DNS of every A/AAAA value")
+	}
+	if c.MailAuth {
+		fmt.Println("Mail Authentication: SPF, DMARC and DKIM analysis of TXT values")
+	}
+	for _, zone := range c.TransferZones {
+		fmt.Printf("Zone Transfer: %s from %s\n", zone, c.transferServer())
+	}
+	if c.TSIGKey != nil {
+		fmt.Printf("TSIG Key: %s\n", c.TSIGKey)
+	}
+	if c.NotifyListen != "" {
+		fmt.Printf("NOTIFY Listen: %s (propagation timeout %s)\n", c.NotifyListen, c.PropagationWait)
+	}
+	for _, source := range c.NotifyFrom {
+		fmt.Printf("NOTIFY From: %s\n", source)
+	}
+	if len(c.Expected) > 0 {
+		fmt.Printf("Expected: %v\n", c.Expected)
+	}
+	if c.MetricsListen != "" {
+		fmt.Printf("Metrics Listen: %s\n", c.MetricsListen)
+	}
+	if c.Listen != "" {
+		fmt.Printf("API Listen: %s\n", c.Listen)
+	}
+	for _, url := range c.Webhooks {
+		fmt.Printf("Webhook: %s\n", url)
+	}
+	for _, route := range c.SlackRoutes {
+		fmt.Printf("Slack: %s\n", route)
+	}
+	for _, route := range c.TeamsRoutes {
+		fmt.Printf("Teams: %s\n", route)
+	}
+	if c.OnChange != "" {
+		fmt.Printf("On Change: %s (timeout %s)\n", c.OnChange, c.OnChangeTimeout)
+	}
+	if c.SMTP.Addr != "" {
+		fmt.Printf("Mail: %v via %s (digest %s)\n", c.SMTP.To, c.SMTP.Addr, c.SMTP.Digest)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -136,6 +138,22 @@ func TestParseArgs(t *testing.T) {
 				ShowVersion: true,
 			},
 		},
+		{
+			name: "dnssec mode",
+			args: []string{"dns-monitor", "--dnssec", "example.com"},
+			expected: &Config{
+				Domains:    []string{"example.com"},
+				RecordType: "A",
+				Interval:   5 * time.Second,
+				Servers:    []string{},
+				DNSSEC:     true,
+			},
+		},
+		{
+			name:        "invalid signature expiry window",
+			args:        []string{"dns-monitor", "--sig-expiry", "soon", "example.com"},
+			expectError: true,
+		},
 		{
 			name:        "no domains",
 			args:        []string{"dns-monitor"},
@@ -186,6 +204,539 @@ func TestParseArgs(t *testing.T) {
 	}
 }
 
+func TestParseArgs_SigExpiry(t *testing.T) {
+	config, err := ParseArgs([]string{"dns-monitor", "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.SigExpiryWarn != 72*time.Hour {
+		t.Errorf("expected default window 72h, got %s", config.SigExpiryWarn)
+	}
+
+	config, err = ParseArgs([]string{"dns-monitor", "--dnssec", "--sig-expiry", "7d", "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.SigExpiryWarn != 7*24*time.Hour {
+		t.Errorf("expected window 168h, got %s", config.SigExpiryWarn)
+	}
+}
+
+func TestParseArgs_EndOfOptions(t *testing.T) {
+	config, err := ParseArgs([]string{"dns-monitor", "-t", "AAAA", "--", "check", "serve"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.RecordType != "AAAA" || len(config.Domains) != 2 || config.Domains[0] != "check" || config.Domains[1] != "serve" {
+		t.Errorf("expected command names after -- to be domains, got %s %v", config.RecordType, config.Domains)
+	}
+}
+
+func TestParseArgs_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    []string
+		rttWarn     time.Duration
+		rttCrit     time.Duration
+		expectError bool
+	}{
+		{
+			name:     "expected values and thresholds",
+			args:     []string{"check", "-e", "192.0.2.1", "--expect", "192.0.2.2", "--rtt-warn", "200ms", "--rtt-crit", "1s", "example.com"},
+			expected: []string{"192.0.2.1", "192.0.2.2"},
+			rttWarn:  200 * time.Millisecond,
+			rttCrit:  time.Second,
+		},
+		{
+			name:        "invalid threshold",
+			args:        []string{"check", "--rtt-warn", "fast", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(config.Expected, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected values %v, got %v", tt.expected, config.Expected)
+			}
+			if config.RTTWarn != tt.rttWarn || config.RTTCrit != tt.rttCrit {
+				t.Errorf("expected thresholds %s/%s, got %s/%s", tt.rttWarn, tt.rttCrit, config.RTTWarn, config.RTTCrit)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Metrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		listen string
+	}{
+		{"listen address", []string{"dns-monitor", "--metrics-listen", ":9153", "example.com"}, ":9153"},
+		{"disabled by default", []string{"dns-monitor", "example.com"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.MetricsListen != tt.listen {
+				t.Errorf("expected metrics address %q, got %q", tt.listen, config.MetricsListen)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Webhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		webhooks    []string
+		secret      string
+		timeout     time.Duration
+		retries     int
+		expectError bool
+	}{
+		{
+			name:     "webhook options",
+			args:     []string{"dns-monitor", "--webhook", "https://hooks.example.com/dns", "--webhook-secret", "s3cret", "--webhook-timeout", "3s", "--webhook-retries", "5", "example.com"},
+			webhooks: []string{"https://hooks.example.com/dns"},
+			secret:   "s3cret",
+			timeout:  3 * time.Second,
+			retries:  5,
+		},
+		{
+			name:        "URL without scheme",
+			args:        []string{"dns-monitor", "--webhook", "hooks.example.com", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(config.Webhooks, ",") != strings.Join(tt.webhooks, ",") {
+				t.Errorf("expected webhooks %v, got %v", tt.webhooks, config.Webhooks)
+			}
+			if config.WebhookSecret != tt.secret {
+				t.Errorf("expected secret %q, got %q", tt.secret, config.WebhookSecret)
+			}
+			if config.WebhookTimeout != tt.timeout {
+				t.Errorf("expected timeout %s, got %s", tt.timeout, config.WebhookTimeout)
+			}
+			if config.WebhookRetries != tt.retries {
+				t.Errorf("expected %d retries, got %d", tt.retries, config.WebhookRetries)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Chat(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		slack       []ChatRoute
+		teams       []ChatRoute
+		expectError bool
+	}{
+		{
+			name: "Slack and Teams routes",
+			args: []string{"dns-monitor", "--slack", "https://hooks.slack.com/services/T/B/X", "--slack", "*.example.org=https://hooks.slack.com/services/T/B/Y", "--teams", "https://example.webhook.office.com/webhookb2/Z", "example.com"},
+			slack: []ChatRoute{
+				{URL: "https://hooks.slack.com/services/T/B/X"},
+				{Pattern: "*.example.org", URL: "https://hooks.slack.com/services/T/B/Y"},
+			},
+			teams: []ChatRoute{{URL: "https://example.webhook.office.com/webhookb2/Z"}},
+		},
+		{
+			name:        "Teams URL without scheme",
+			args:        []string{"dns-monitor", "--teams", "example.com=hooks.example.com", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(config.SlackRoutes, tt.slack) {
+				t.Errorf("expected Slack routes %v, got %v", tt.slack, config.SlackRoutes)
+			}
+			if !slices.Equal(config.TeamsRoutes, tt.teams) {
+				t.Errorf("expected Teams routes %v, got %v", tt.teams, config.TeamsRoutes)
+			}
+		})
+	}
+}
+
+func TestParseArgs_SMTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expected    SMTPConfig
+		expectError bool
+	}{
+		{
+			name: "SMTP options",
+			args: []string{"dns-monitor", "--smtp", "mail.example.com", "--smtp-starttls", "--smtp-user", "monitor", "--mail-from", "dns@example.com", "--mail-to", "ops@example.com, noc@example.com", "--mail-digest", "5m", "--mail-errors-after", "2", "example.com"},
+			expected: SMTPConfig{
+				Addr:       "mail.example.com:587",
+				StartTLS:   true,
+				Username:   "monitor",
+				From:       "dns@example.com",
+				To:         []string{"ops@example.com", "noc@example.com"},
+				Digest:     5 * time.Minute,
+				ErrorAfter: 2,
+			},
+		},
+		{
+			name:        "no recipients",
+			args:        []string{"dns-monitor", "--smtp", "mail.example.com:25", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, want := config.SMTP, tt.expected
+			if got.Addr != want.Addr || got.StartTLS != want.StartTLS || got.Username != want.Username {
+				t.Errorf("expected server %s (STARTTLS %t, user %q), got %s (STARTTLS %t, user %q)", want.Addr, want.StartTLS, want.Username, got.Addr, got.StartTLS, got.Username)
+			}
+			if got.From != want.From || !slices.Equal(got.To, want.To) {
+				t.Errorf("expected mail from %s to %v, got from %s to %v", want.From, want.To, got.From, got.To)
+			}
+			if got.Digest != want.Digest || got.ErrorAfter != want.ErrorAfter {
+				t.Errorf("expected digest %s and errors after %d, got %s and %d", want.Digest, want.ErrorAfter, got.Digest, got.ErrorAfter)
+			}
+		})
+	}
+}
+
+func TestParseArgs_OnChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		command string
+		timeout time.Duration
+	}{
+		{"command and timeout", []string{"dns-monitor", "--on-change", "purge-cache --zone example.com", "--on-change-timeout", "5s", "example.com"}, "purge-cache --zone example.com", 5 * time.Second},
+		{"default timeout", []string{"dns-monitor", "--on-change", "./notify.sh", "example.com"}, "./notify.sh", 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.OnChange != tt.command {
+				t.Errorf("expected command %q, got %q", tt.command, config.OnChange)
+			}
+			if config.OnChangeTimeout != tt.timeout {
+				t.Errorf("expected timeout %s, got %s", tt.timeout, config.OnChangeTimeout)
+			}
+		})
+	}
+}
+
+func TestParseArgs_ChangeFiltering(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		confirm       int
+		flapThreshold int
+		flapWindow    time.Duration
+		expectError   bool
+	}{
+		{
+			name:          "confirm and flap detection",
+			args:          []string{"dns-monitor", "--confirm", "3", "--flap-threshold", "4", "--flap-window", "30m", "example.com"},
+			confirm:       3,
+			flapThreshold: 4,
+			flapWindow:    30 * time.Minute,
+		},
+		{
+			name:       "defaults",
+			args:       []string{"dns-monitor", "example.com"},
+			confirm:    1,
+			flapWindow: 10 * time.Minute,
+		},
+		{
+			name:        "confirm 0",
+			args:        []string{"dns-monitor", "--confirm", "0", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Confirm != tt.confirm {
+				t.Errorf("expected confirm %d, got %d", tt.confirm, config.Confirm)
+			}
+			if config.FlapThreshold != tt.flapThreshold || config.FlapWindow != tt.flapWindow {
+				t.Errorf("expected flap threshold %d in %s, got %d in %s", tt.flapThreshold, tt.flapWindow, config.FlapThreshold, config.FlapWindow)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Color(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		color       string
+		noColor     bool
+		expectError bool
+	}{
+		{name: "auto by default", args: []string{"dns-monitor", "example.com"}, color: ColorModeAuto},
+		{name: "mode is case-insensitive", args: []string{"dns-monitor", "--color", "Always", "example.com"}, color: ColorModeAlways},
+		{name: "no-color selects never", args: []string{"dns-monitor", "--no-color", "example.com"}, color: ColorModeNever, noColor: true},
+		{name: "invalid mode", args: []string{"dns-monitor", "--color", "sometimes", "example.com"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Color != tt.color || config.NoColor != tt.noColor {
+				t.Errorf("expected color %s (no color %t), got %s (no color %t)", tt.color, tt.noColor, config.Color, config.NoColor)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Serve(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    string
+		listen string
+		token  string
+	}{
+		{name: "listen without domains", args: []string{"serve", "--listen", ":8080"}, listen: ":8080"},
+		{name: "API token from the environment", args: []string{"serve", "--listen", ":8080"}, env: "from-env", listen: ":8080", token: "from-env"},
+		{name: "API token flag overrides the environment", args: []string{"serve", "--listen", ":8080", "--api-token", "s3cret"}, env: "from-env", listen: ":8080", token: "s3cret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DNS_MONITOR_API_TOKEN", tt.env)
+			config, err := ParseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Listen != tt.listen || len(config.Domains) != 0 {
+				t.Errorf("expected listen %s and no domains, got %q %v", tt.listen, config.Listen, config.Domains)
+			}
+			if config.APIToken != tt.token {
+				t.Errorf("expected API token %q, got %q", tt.token, config.APIToken)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Transfer(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		zones         []string
+		server        string
+		tsigAlgorithm string
+		expectError   bool
+	}{
+		{
+			name:          "zone without domains",
+			args:          []string{"dns-monitor", "--axfr", "Example.com.", "-s", "192.0.2.53", "--tsig", "hmac-sha512:xfr-key:c2VjcmV0"},
+			zones:         []string{"example.com"},
+			server:        "192.0.2.53:53",
+			tsigAlgorithm: "hmac-sha512.",
+		},
+		{
+			name:        "no server",
+			args:        []string{"dns-monitor", "--axfr", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(config.TransferZones, tt.zones) {
+				t.Errorf("expected zones %v, got %v", tt.zones, config.TransferZones)
+			}
+			if config.transferServer() != tt.server {
+				t.Errorf("expected transfer server %s, got %s", tt.server, config.transferServer())
+			}
+			if config.TSIGKey == nil || config.TSIGKey.Algorithm != tt.tsigAlgorithm {
+				t.Errorf("expected TSIG algorithm %s, got %+v", tt.tsigAlgorithm, config.TSIGKey)
+			}
+		})
+	}
+}
+
+func TestParseArgs_Notify(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		listen      string
+		from        []string
+		wait        time.Duration
+		expectError bool
+	}{
+		{
+			name:   "listen, sources and timeout",
+			args:   []string{"dns-monitor", "--notify-listen", ":5300", "--notify-from", "192.0.2.53", "--notify-from", "2001:db8::/32", "--propagation-timeout", "2m", "example.com"},
+			listen: ":5300",
+			from:   []string{"192.0.2.53", "2001:db8::/32"},
+			wait:   2 * time.Minute,
+		},
+		{
+			name:        "invalid source",
+			args:        []string{"dns-monitor", "--notify-from", "primary", "example.com"},
+			expectError: true,
+		},
+		{
+			name:        "listen without sources",
+			args:        []string{"dns-monitor", "--notify-listen", ":5300", "example.com"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.NotifyListen != tt.listen || !slices.Equal(config.NotifyFrom, tt.from) {
+				t.Errorf("expected NOTIFY on %q from %v, got %q from %v", tt.listen, tt.from, config.NotifyListen, config.NotifyFrom)
+			}
+			if config.PropagationWait != tt.wait {
+				t.Errorf("expected propagation timeout %s, got %s", tt.wait, config.PropagationWait)
+			}
+		})
+	}
+}
+
+func TestParseArgs_FCrDNS(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		fcrdns bool
+	}{
+		{"enabled", []string{"dns-monitor", "--fcrdns", "-t", "AAAA", "mail.example.com"}, true},
+		{"disabled by default", []string{"dns-monitor", "mail.example.com"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.FCrDNS != tt.fcrdns {
+				t.Errorf("expected FCrDNS %t, got %t", tt.fcrdns, config.FCrDNS)
+			}
+		})
+	}
+}
+
+func TestParseArgs_MailAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		mailAuth bool
+	}{
+		{"enabled", []string{"dns-monitor", "--mail-auth", "-t", "TXT", "example.com", "_dmarc.example.com"}, true},
+		{"disabled by default", []string{"dns-monitor", "-t", "TXT", "example.com"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseArgs(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.MailAuth != tt.mailAuth {
+				t.Errorf("expected MailAuth %t, got %t", tt.mailAuth, config.MailAuth)
+			}
+		})
+	}
+}
+
 func TestParseDuration(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -196,6 +747,8 @@ func TestParseDuration(t *testing.T) {
 		{"seconds with suffix", "30s", 30 * time.Second, false},
 		{"minutes with suffix", "5m", 5 * time.Minute, false},
 		{"hours with suffix", "2h", 2 * time.Hour, false},
+		{"days with suffix", "3d", 72 * time.Hour, false},
+		{"milliseconds with suffix", "250ms", 250 * time.Millisecond, false},
 		{"seconds without suffix", "45", 45 * time.Second, false},
 		{"invalid format", "abc", 0, true},
 		{"invalid number", "5x", 0, true},
@@ -282,6 +835,7 @@ func configsEqual(a, b *Config) bool {
 		a.UntilChange == b.UntilChange &&
 		a.OutputFile == b.OutputFile &&
 		a.NoColor == b.NoColor &&
+		a.DNSSEC == b.DNSSEC &&
 		a.ShowHelp == b.ShowHelp &&
 		a.ShowVersion == b.ShowVersion
 }
\ No newline at end of file
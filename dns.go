@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -11,140 +15,265 @@ import (
 type DNSClient struct {
 	servers []string
 	timeout time.Duration
+	stats   *queryStats
 }
 
 type DNSRecord struct {
 	Domain string
 	Type   string
 	Values []string
+	Server string
+	RTT    time.Duration
 }
 
+// ResponseError is returned when a server answered with an error rcode.
+type ResponseError struct {
+	Rcode int
+}
+
+func (e *ResponseError) Error() string {
+	return rcodeToString(e.Rcode)
+}
+
+// NoRecordsError is returned when the name exists but has no records of the
+// requested type.
+type NoRecordsError struct {
+	Domain string
+	Type   string
+}
+
+func (e *NoRecordsError) Error() string {
+	return fmt.Sprintf("no %s records found for %s", e.Type, e.Domain)
+}
+
+// errorCode classifies a lookup error by response code, or by the kind of
+// failure when no response was received.
+func errorCode(err error) string {
+	var respErr *ResponseError
+	var noRecords *NoRecordsError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &respErr):
+		return rcodeToString(respErr.Rcode)
+	case errors.As(err, &noRecords):
+		return "NODATA"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "TIMEOUT"
+	}
+	return "NETWORK"
+}
+
+// resolvConf lists the system's nameservers, which are queried when no
+// server is given.
+var resolvConf = "/etc/resolv.conf"
+
+// systemServers returns the nameservers listed in resolvConf. Like the C
+// library, it falls back to a resolver on the local host when the file is
+// missing or lists none.
+func systemServers() []string {
+	var servers []string
+	if data, err := os.ReadFile(resolvConf); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+			if net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]) != nil {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+// NewDNSClient returns a client querying servers, or the system's
+// nameservers when servers is empty.
 func NewDNSClient(servers []string, timeout time.Duration) *DNSClient {
 	if len(servers) == 0 {
-		servers = []string{"8.8.8.8:53"}
+		servers = systemServers()
 	}
 	return &DNSClient{
 		servers: servers,
 		timeout: timeout,
+		stats:   newQueryStats(),
 	}
 }
 
+// Query looks up domain on the configured servers in order, moving on to the
+// next one only when a server could not be reached.
 func (c *DNSClient) Query(domain, recordType string) (*DNSRecord, error) {
-	switch strings.ToUpper(recordType) {
-	case "A":
-		return c.queryA(domain)
-	case "AAAA":
-		return c.queryAAAA(domain)
-	case "CNAME":
-		return c.queryCNAME(domain)
-	case "MX":
-		return c.queryMX(domain)
-	case "TXT":
-		return c.queryTXT(domain)
-	default:
+	if _, ok := stringToType(recordType); !ok {
 		return nil, fmt.Errorf("unsupported record type: %s", recordType)
 	}
+
+	var lastErr error
+	for _, server := range c.servers {
+		record, err := c.QueryServer(server, domain, recordType)
+		var respErr *ResponseError
+		if err == nil || errors.As(err, &respErr) {
+			return record, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
 }
 
-func (c *DNSClient) queryA(domain string) (*DNSRecord, error) {
-	ips, err := net.LookupIP(domain)
+// QueryServer looks up domain on a single server and records the round-trip
+// time of the exchange in the client's statistics.
+func (c *DNSClient) QueryServer(server, domain, recordType string) (*DNSRecord, error) {
+	qtype, ok := stringToType(recordType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	recordType = typeToString(qtype)
+
+	start := time.Now()
+	resp, err := c.exchange(server, newQuery(domain, qtype))
+	rtt := time.Since(start)
+	c.stats.record(server, rtt, err == nil && resp.Rcode != RcodeServFail && resp.Rcode != RcodeRefused)
+
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup A record for %s: %v", domain, err)
+		return nil, fmt.Errorf("failed to lookup %s record for %s: %w", recordType, domain, err)
+	}
+	if resp.Rcode != RcodeSuccess {
+		return nil, fmt.Errorf("failed to lookup %s record for %s: %w", recordType, domain, &ResponseError{Rcode: resp.Rcode})
 	}
 
-	var ipv4s []string
-	for _, ip := range ips {
-		if ipv4 := ip.To4(); ipv4 != nil {
-			ipv4s = append(ipv4s, ip.String())
+	var values []string
+	for _, rr := range resp.Answers {
+		if rr.Type == qtype {
+			values = append(values, rr.Value())
 		}
 	}
-
-	if len(ipv4s) == 0 {
-		return nil, fmt.Errorf("no A records found for %s", domain)
+	if len(values) == 0 {
+		return nil, &NoRecordsError{Domain: domain, Type: recordType}
 	}
 
-	sort.Strings(ipv4s)
+	sort.Strings(values)
 	return &DNSRecord{
 		Domain: domain,
-		Type:   "A",
-		Values: ipv4s,
+		Type:   recordType,
+		Values: values,
+		Server: server,
+		RTT:    rtt,
 	}, nil
 }
 
-func (c *DNSClient) queryAAAA(domain string) (*DNSRecord, error) {
-	ips, err := net.LookupIP(domain)
+// Stats returns the latency and error statistics collected so far for every
+// server that has been queried.
+func (c *DNSClient) Stats() []ServerStats {
+	return c.stats.snapshot()
+}
+
+// exchange sends msg to server over UDP and returns the response, retrying
+// over TCP when the answer was truncated.
+func (c *DNSClient) exchange(server string, msg *dnsMessage) (*dnsMessage, error) {
+	query, err := msg.pack()
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup AAAA record for %s: %v", domain, err)
+		return nil, err
 	}
 
-	var ipv6s []string
-	for _, ip := range ips {
-		if ip.To4() == nil && ip.To16() != nil {
-			ipv6s = append(ipv6s, ip.String())
-		}
+	conn, err := net.DialTimeout("udp", server, c.timeout)
+	if err != nil {
+		return nil, err
 	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
 
-	if len(ipv6s) == 0 {
-		return nil, fmt.Errorf("no AAAA records found for %s", domain)
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
 	}
 
-	sort.Strings(ipv6s)
-	return &DNSRecord{
-		Domain: domain,
-		Type:   "AAAA",
-		Values: ipv6s,
-	}, nil
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := unpackMessage(buf[:n])
+		if err != nil || resp.ID != msg.ID || !resp.Response {
+			// Ignore stray or malformed datagrams and keep waiting.
+			continue
+		}
+		if resp.Truncated {
+			return c.exchangeTCP(server, msg)
+		}
+		return resp, nil
+	}
 }
 
-func (c *DNSClient) queryCNAME(domain string) (*DNSRecord, error) {
-	cname, err := net.LookupCNAME(domain)
+func (c *DNSClient) exchangeTCP(server string, msg *dnsMessage) (*dnsMessage, error) {
+	conn, err := net.DialTimeout("tcp", server, c.timeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup CNAME record for %s: %v", domain, err)
+		return nil, err
 	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
 
-	cname = strings.TrimSuffix(cname, ".")
-	return &DNSRecord{
-		Domain: domain,
-		Type:   "CNAME",
-		Values: []string{cname},
-	}, nil
+	if err := writeTCPMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	resp, err := readTCPMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != msg.ID {
+		return nil, fmt.Errorf("response ID mismatch from %s", server)
+	}
+	return resp, nil
 }
 
-func (c *DNSClient) queryMX(domain string) (*DNSRecord, error) {
-	mxRecords, err := net.LookupMX(domain)
-	if err != nil {
-		return nil, fmt.Errorf("failed to lookup MX record for %s: %v", domain, err)
+// exchangeAny tries each configured server in turn and returns the first
+// response received.
+func (c *DNSClient) exchangeAny(msg *dnsMessage) (*dnsMessage, string, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		resp, err := c.exchange(server, msg)
+		if err == nil {
+			return resp, server, nil
+		}
+		lastErr = err
 	}
+	return nil, "", lastErr
+}
 
-	var mxValues []string
-	for _, mx := range mxRecords {
-		mxValues = append(mxValues, fmt.Sprintf("%d %s", mx.Pref, strings.TrimSuffix(mx.Host, ".")))
+func writeTCPMessage(w io.Writer, msg *dnsMessage) error {
+	b, err := msg.pack()
+	if err != nil {
+		return err
 	}
+	return writeTCPFrame(w, b)
+}
 
-	sort.Strings(mxValues)
-	return &DNSRecord{
-		Domain: domain,
-		Type:   "MX",
-		Values: mxValues,
-	}, nil
+func writeTCPFrame(w io.Writer, b []byte) error {
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, len(b)+2), uint16(len(b)))
+	_, err := w.Write(append(frame, b...))
+	return err
 }
 
-func (c *DNSClient) queryTXT(domain string) (*DNSRecord, error) {
-	txtRecords, err := net.LookupTXT(domain)
+func readTCPMessage(r io.Reader) (*dnsMessage, error) {
+	buf, err := readTCPFrame(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup TXT record for %s: %v", domain, err)
+		return nil, err
 	}
+	return unpackMessage(buf)
+}
 
-	if len(txtRecords) == 0 {
-		return nil, fmt.Errorf("no TXT records found for %s", domain)
+// readTCPFrame reads one length-prefixed message without decoding it, for
+// when the raw bytes are needed to verify a signature.
+func readTCPFrame(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
 	}
-
-	sort.Strings(txtRecords)
-	return &DNSRecord{
-		Domain: domain,
-		Type:   "TXT",
-		Values: txtRecords,
-	}, nil
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
 
 func (r *DNSRecord) String() string {
@@ -164,4 +293,52 @@ func (r *DNSRecord) Equals(other *DNSRecord) bool {
 		}
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// Diff returns the values present in other but not in r, and those present in
+// r but no longer in other.
+func (r *DNSRecord) Diff(other *DNSRecord) (added, removed []string) {
+	before := make(map[string]bool, len(r.Values))
+	for _, v := range r.Values {
+		before[v] = true
+	}
+	after := make(map[string]bool, len(other.Values))
+	for _, v := range other.Values {
+		after[v] = true
+		if !before[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range r.Values {
+		if !after[v] {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+// Matches reports whether the record holds exactly the expected values,
+// ignoring order and trailing dots on names.
+func (r *DNSRecord) Matches(expected []string) bool {
+	if len(r.Values) != len(expected) {
+		return false
+	}
+	want := make(map[string]int, len(expected))
+	for _, v := range expected {
+		want[strings.TrimSuffix(v, ".")]++
+	}
+	for _, v := range r.Values {
+		v = strings.TrimSuffix(v, ".")
+		if want[v] == 0 {
+			return false
+		}
+		want[v]--
+	}
+	return true
+}
+
+// Contains reports whether every expected value is present in the record,
+// ignoring trailing dots on names.
+func (r *DNSRecord) Contains(expected []string) bool {
+	return len(presentValues(r.Values, expected)) == len(expected)
+}
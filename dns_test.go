@@ -1,11 +1,17 @@
 package main
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestNewDNSClient(t *testing.T) {
+	useResolvConf(t, "nameserver 8.8.8.8\n")
+
 	tests := []struct {
 		name     string
 		servers  []string
@@ -38,6 +44,31 @@ func TestNewDNSClient(t *testing.T) {
 	}
 }
 
+// useResolvConf points the client at a resolv.conf holding content for the
+// duration of the test.
+func useResolvConf(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write resolv.conf: %v", err)
+	}
+	saved := resolvConf
+	resolvConf = path
+	t.Cleanup(func() { resolvConf = saved })
+}
+
+func TestSystemServers(t *testing.T) {
+	useResolvConf(t, "# generated\nsearch example.com\nnameserver 10.0.0.2\nnameserver fe80::1%eth0\nnameserver bogus\noptions ndots:2\n")
+	if got := strings.Join(systemServers(), " "); got != "10.0.0.2:53 [fe80::1%eth0]:53" {
+		t.Errorf("unexpected servers: %s", got)
+	}
+
+	useResolvConf(t, "search example.com\n")
+	if got := strings.Join(systemServers(), " "); got != "127.0.0.1:53" {
+		t.Errorf("expected the local resolver without nameservers, got %s", got)
+	}
+}
+
 func TestDNSRecord_String(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -173,4 +204,111 @@ func TestDNSClient_Query_UnsupportedType(t *testing.T) {
 	if err.Error() != expectedError {
 		t.Errorf("expected error %s, got %s", expectedError, err.Error())
 	}
-}
\ No newline at end of file
+}
+
+func TestDNSRecord_Diff(t *testing.T) {
+	before := &DNSRecord{Domain: "example.com", Type: "A", Values: []string{"192.168.1.1", "192.168.1.2"}}
+	after := &DNSRecord{Domain: "example.com", Type: "A", Values: []string{"192.168.1.2", "192.168.1.3"}}
+
+	added, removed := before.Diff(after)
+	if len(added) != 1 || added[0] != "192.168.1.3" {
+		t.Errorf("expected added [192.168.1.3], got %v", added)
+	}
+	if len(removed) != 1 || removed[0] != "192.168.1.1" {
+		t.Errorf("expected removed [192.168.1.1], got %v", removed)
+	}
+}
+
+func TestDNSClient_QueryServer(t *testing.T) {
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		name := q.Questions[0].Name
+		switch name {
+		case "www.example.com.":
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeCNAME, nameData(t, nil, "example.com")),
+				mustRR(t, "example.com", TypeA, []byte{192, 0, 2, 2}),
+				mustRR(t, "example.com", TypeA, []byte{192, 0, 2, 1}),
+			}}
+		case "broken.example.com.":
+			return &dnsMessage{Rcode: RcodeServFail}
+		}
+		return &dnsMessage{Rcode: RcodeNXDomain}
+	})
+	client := NewDNSClient([]string{server}, 2*time.Second)
+
+	record, err := client.QueryServer(server, "www.example.com", "A")
+	if err != nil {
+		t.Fatalf("QueryServer failed: %v", err)
+	}
+	if record.String() != "[192.0.2.1, 192.0.2.2]" {
+		t.Errorf("expected sorted A values, got %s", record.String())
+	}
+	if record.Server != server || record.RTT <= 0 {
+		t.Errorf("expected server and RTT to be recorded, got %s and %s", record.Server, record.RTT)
+	}
+
+	cname, err := client.QueryServer(server, "www.example.com", "CNAME")
+	if err != nil {
+		t.Fatalf("QueryServer failed: %v", err)
+	}
+	if cname.String() != "[example.com]" {
+		t.Errorf("expected CNAME target, got %s", cname.String())
+	}
+
+	_, err = client.QueryServer(server, "missing.example.com", "A")
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) || respErr.Rcode != RcodeNXDomain {
+		t.Errorf("expected NXDOMAIN response error, got %v", err)
+	}
+
+	client.QueryServer(server, "broken.example.com", "A")
+
+	stats := client.Stats()
+	if len(stats) != 1 || stats[0].Queries != 4 || stats[0].Errors != 1 {
+		t.Errorf("expected 4 queries with 1 SERVFAIL error, got %+v", stats)
+	}
+}
+
+func TestDNSClient_Query_Failover(t *testing.T) {
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		return &dnsMessage{Rcode: RcodeNXDomain}
+	})
+	client := NewDNSClient([]string{"127.0.0.1:1", server, "127.0.0.1:2"}, 500*time.Millisecond)
+
+	_, err := client.Query("missing.example.com", "A")
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("expected response error from second server, got %v", err)
+	}
+
+	servers := make(map[string]bool)
+	for _, s := range client.Stats() {
+		servers[s.Server] = true
+	}
+	if servers["127.0.0.1:2"] {
+		t.Error("an authoritative NXDOMAIN should not fail over to the next server")
+	}
+}
+
+func TestDNSRecord_Matches(t *testing.T) {
+	record := &DNSRecord{Domain: "example.com", Type: "CNAME", Values: []string{"a.example.net", "b.example.net"}}
+
+	tests := []struct {
+		name     string
+		expected []string
+		matches  bool
+	}{
+		{"same order", []string{"a.example.net", "b.example.net"}, true},
+		{"different order with trailing dots", []string{"b.example.net.", "a.example.net."}, true},
+		{"missing value", []string{"a.example.net"}, false},
+		{"different value", []string{"a.example.net", "c.example.net"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := record.Matches(tt.expected); got != tt.matches {
+				t.Errorf("expected %v, got %v", tt.matches, got)
+			}
+		})
+	}
+}
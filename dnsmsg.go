@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Minimal DNS wire format (RFC 1035) support. Only what the monitor needs is
+// implemented; record types without a dedicated parser are carried as opaque
+// rdata so they can still be compared and displayed.
+
+const (
+	TypeA      uint16 = 1
+	TypeNS     uint16 = 2
+	TypeCNAME  uint16 = 5
+	TypeSOA    uint16 = 6
+	TypePTR    uint16 = 12
+	TypeMX     uint16 = 15
+	TypeTXT    uint16 = 16
+	TypeAAAA   uint16 = 28
+	TypeOPT    uint16 = 41
+	TypeDS     uint16 = 43
+	TypeRRSIG  uint16 = 46
+	TypeDNSKEY uint16 = 48
+	TypeTSIG   uint16 = 250
+	TypeIXFR   uint16 = 251
+	TypeAXFR   uint16 = 252
+	TypeANY    uint16 = 255
+
+	ClassINET uint16 = 1
+	ClassANY  uint16 = 255
+)
+
+const (
+	RcodeSuccess  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+	RcodeNotAuth  = 9
+)
+
+const (
+	OpcodeQuery  = 0
+	OpcodeNotify = 4
+)
+
+var typeNames = map[uint16]string{
+	TypeA:      "A",
+	TypeNS:     "NS",
+	TypeCNAME:  "CNAME",
+	TypeSOA:    "SOA",
+	TypePTR:    "PTR",
+	TypeMX:     "MX",
+	TypeTXT:    "TXT",
+	TypeAAAA:   "AAAA",
+	TypeOPT:    "OPT",
+	TypeDS:     "DS",
+	TypeRRSIG:  "RRSIG",
+	TypeDNSKEY: "DNSKEY",
+	TypeTSIG:   "TSIG",
+	TypeIXFR:   "IXFR",
+	TypeAXFR:   "AXFR",
+	TypeANY:    "ANY",
+}
+
+var rcodeNames = map[int]string{
+	RcodeSuccess:  "NOERROR",
+	RcodeFormErr:  "FORMERR",
+	RcodeServFail: "SERVFAIL",
+	RcodeNXDomain: "NXDOMAIN",
+	RcodeNotImp:   "NOTIMP",
+	RcodeRefused:  "REFUSED",
+	RcodeNotAuth:  "NOTAUTH",
+}
+
+func typeToString(t uint16) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func stringToType(s string) (uint16, bool) {
+	s = strings.ToUpper(s)
+	for t, name := range typeNames {
+		if name == s {
+			return t, true
+		}
+	}
+	if strings.HasPrefix(s, "TYPE") {
+		n, err := strconv.ParseUint(s[4:], 10, 16)
+		if err == nil {
+			return uint16(n), true
+		}
+	}
+	return 0, false
+}
+
+func rcodeToString(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// dnsRR is a resource record. Domain names embedded in Data are always stored
+// uncompressed so a record can be re-packed or inspected on its own.
+type dnsRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+type dnsMessage struct {
+	ID                 uint16
+	Response           bool
+	Opcode             int
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	AuthenticData      bool
+	CheckingDisabled   bool
+	Rcode              int
+	Questions          []dnsQuestion
+	Answers            []dnsRR
+	Authority          []dnsRR
+	Additional         []dnsRR
+}
+
+var errShortMessage = errors.New("dns: message too short")
+
+func newQuery(name string, qtype uint16) *dnsMessage {
+	return &dnsMessage{
+		ID:               uint16(rand.Intn(1 << 16)),
+		Opcode:           OpcodeQuery,
+		RecursionDesired: true,
+		Questions:        []dnsQuestion{{Name: fqdn(name), Type: qtype, Class: ClassINET}},
+	}
+}
+
+// setEDNS0 adds an OPT pseudo-record advertising udpSize and, when do is
+// set, asking the server to include DNSSEC records.
+func (m *dnsMessage) setEDNS0(udpSize uint16, do bool) {
+	var ttl uint32
+	if do {
+		ttl = 0x8000
+	}
+	m.Additional = append(m.Additional, dnsRR{Name: ".", Type: TypeOPT, Class: udpSize, TTL: ttl})
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(fqdn(name))
+}
+
+func (m *dnsMessage) pack() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+
+	var flags uint16
+	if m.Response {
+		flags |= 1 << 15
+	}
+	flags |= uint16(m.Opcode&0xf) << 11
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	if m.Truncated {
+		flags |= 1 << 9
+	}
+	if m.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if m.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	if m.AuthenticData {
+		flags |= 1 << 5
+	}
+	if m.CheckingDisabled {
+		flags |= 1 << 4
+	}
+	flags |= uint16(m.Rcode & 0xf)
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, section := range [][]dnsRR{m.Answers, m.Authority, m.Additional} {
+		for _, rr := range section {
+			if b, err = rr.appendTo(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func (rr *dnsRR) appendTo(b []byte) ([]byte, error) {
+	b, err := appendName(b, rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rr.Data) > 0xffff {
+		return nil, fmt.Errorf("dns: rdata too long for %s", rr.Name)
+	}
+	b = binary.BigEndian.AppendUint16(b, rr.Type)
+	b = binary.BigEndian.AppendUint16(b, rr.Class)
+	b = binary.BigEndian.AppendUint32(b, rr.TTL)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+// appendName appends name in uncompressed wire format.
+func appendName(b []byte, name string) ([]byte, error) {
+	name = fqdn(name)
+	if name == "." {
+		return append(b, 0), nil
+	}
+	total := 1
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("dns: invalid label in name %q", name)
+		}
+		total += len(label) + 1
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	if total > 255 {
+		return nil, fmt.Errorf("dns: name too long: %q", name)
+	}
+	return append(b, 0), nil
+}
+
+// readName decodes a possibly compressed name starting at off and returns it
+// together with the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for hops := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errShortMessage
+		}
+		n := int(msg[off])
+		switch n & 0xc0 {
+		case 0x00:
+			if n == 0 {
+				if end < 0 {
+					end = off + 1
+				}
+				if len(labels) == 0 {
+					return ".", end, nil
+				}
+				return strings.Join(labels, ".") + ".", end, nil
+			}
+			if off+1+n > len(msg) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		case 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, errShortMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			hops++
+			if hops > 32 {
+				return "", 0, errors.New("dns: too many compression pointers")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			return "", 0, errors.New("dns: invalid label type")
+		}
+	}
+}
+
+func unpackMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errShortMessage
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m := &dnsMessage{
+		ID:                 binary.BigEndian.Uint16(msg[0:]),
+		Response:           flags&(1<<15) != 0,
+		Opcode:             int(flags>>11) & 0xf,
+		Authoritative:      flags&(1<<10) != 0,
+		Truncated:          flags&(1<<9) != 0,
+		RecursionDesired:   flags&(1<<8) != 0,
+		RecursionAvailable: flags&(1<<7) != 0,
+		AuthenticData:      flags&(1<<5) != 0,
+		CheckingDisabled:   flags&(1<<4) != 0,
+		Rcode:              int(flags & 0xf),
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(msg[6:])),
+		int(binary.BigEndian.Uint16(msg[8:])),
+		int(binary.BigEndian.Uint16(msg[10:])),
+	}
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errShortMessage
+		}
+		m.Questions = append(m.Questions, dnsQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		off = next + 4
+	}
+
+	sections := []*[]dnsRR{&m.Answers, &m.Authority, &m.Additional}
+	for i, section := range sections {
+		for j := 0; j < counts[i]; j++ {
+			rr, next, err := readRR(msg, off)
+			if err != nil {
+				return nil, err
+			}
+			*section = append(*section, rr)
+			off = next
+		}
+	}
+
+	// The OPT record carries the upper bits of the response code.
+	for _, rr := range m.Additional {
+		if rr.Type == TypeOPT {
+			m.Rcode |= int(rr.TTL>>24) << 4
+		}
+	}
+	return m, nil
+}
+
+func readRR(msg []byte, off int) (dnsRR, int, error) {
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return dnsRR{}, 0, err
+	}
+	if off+10 > len(msg) {
+		return dnsRR{}, 0, errShortMessage
+	}
+	rr := dnsRR{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[off:]),
+		Class: binary.BigEndian.Uint16(msg[off+2:]),
+		TTL:   binary.BigEndian.Uint32(msg[off+4:]),
+	}
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+rdlen > len(msg) {
+		return dnsRR{}, 0, errShortMessage
+	}
+	rr.Data, err = expandRdata(msg, off, rdlen, rr.Type)
+	if err != nil {
+		return dnsRR{}, 0, err
+	}
+	return rr, off + rdlen, nil
+}
+
+// expandRdata copies rdata out of msg, decompressing the names of the record
+// types that are allowed to use compression.
+func expandRdata(msg []byte, off, rdlen int, rrtype uint16) ([]byte, error) {
+	end := off + rdlen
+	var prefix, names int
+	switch rrtype {
+	case TypeNS, TypeCNAME, TypePTR:
+		names = 1
+	case TypeMX:
+		prefix, names = 2, 1
+	case TypeSOA:
+		names = 2
+	default:
+		return append([]byte(nil), msg[off:end]...), nil
+	}
+
+	out := append([]byte(nil), msg[off:off+prefix]...)
+	pos := off + prefix
+	for i := 0; i < names; i++ {
+		name, next, err := readName(msg[:end], pos)
+		if err != nil {
+			return nil, err
+		}
+		if out, err = appendName(out, name); err != nil {
+			return nil, err
+		}
+		pos = next
+	}
+	return append(out, msg[pos:end]...), nil
+}
+
+// rdataName reads an uncompressed name from rdata.
+func rdataName(data []byte, off int) (string, int, error) {
+	return readName(data, off)
+}
+
+// Value returns the record data in the presentation format used throughout
+// the monitor's output.
+func (rr *dnsRR) Value() string {
+	switch rr.Type {
+	case TypeA, TypeAAAA:
+		return net.IP(rr.Data).String()
+	case TypeNS, TypeCNAME, TypePTR:
+		name, _, err := rdataName(rr.Data, 0)
+		if err != nil {
+			break
+		}
+		return strings.TrimSuffix(name, ".")
+	case TypeMX:
+		if len(rr.Data) < 3 {
+			break
+		}
+		host, _, err := rdataName(rr.Data, 2)
+		if err != nil {
+			break
+		}
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rr.Data), strings.TrimSuffix(host, "."))
+	case TypeTXT:
+		var sb strings.Builder
+		for off := 0; off < len(rr.Data); {
+			n := int(rr.Data[off])
+			if off+1+n > len(rr.Data) {
+				break
+			}
+			sb.Write(rr.Data[off+1 : off+1+n])
+			off += 1 + n
+		}
+		return sb.String()
+	case TypeSOA:
+		soa, err := parseSOA(rr.Data)
+		if err != nil {
+			break
+		}
+		return soa.String()
+	case TypeDS:
+		if len(rr.Data) < 4 {
+			break
+		}
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(rr.Data), rr.Data[2], rr.Data[3],
+			strings.ToUpper(hex.EncodeToString(rr.Data[4:])))
+	case TypeDNSKEY:
+		if len(rr.Data) < 4 {
+			break
+		}
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(rr.Data), rr.Data[2], rr.Data[3],
+			base64.StdEncoding.EncodeToString(rr.Data[4:]))
+	}
+	return fmt.Sprintf("\\# %d %s", len(rr.Data), hex.EncodeToString(rr.Data))
+}
+
+type soaData struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+func parseSOA(data []byte) (*soaData, error) {
+	mname, off, err := rdataName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	rname, off, err := rdataName(data, off)
+	if err != nil {
+		return nil, err
+	}
+	if off+20 > len(data) {
+		return nil, errShortMessage
+	}
+	return &soaData{
+		MName:   mname,
+		RName:   rname,
+		Serial:  binary.BigEndian.Uint32(data[off:]),
+		Refresh: binary.BigEndian.Uint32(data[off+4:]),
+		Retry:   binary.BigEndian.Uint32(data[off+8:]),
+		Expire:  binary.BigEndian.Uint32(data[off+12:]),
+		Minimum: binary.BigEndian.Uint32(data[off+16:]),
+	}, nil
+}
+
+func (s *soaData) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", s.MName, s.RName, s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startStubServer runs a DNS server on a loopback UDP and TCP port pair and
+// answers every query with handler. Responses too large for a plain UDP
+// query are truncated so that clients have to retry over TCP.
+func startStubServer(t *testing.T, handler func(q *dnsMessage) *dnsMessage) string {
+	t.Helper()
+
+	var pc net.PacketConn
+	var ln net.Listener
+	for attempt := 0; ; attempt++ {
+		var err error
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen on UDP: %v", err)
+		}
+		ln, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		pc.Close()
+		if attempt == 10 {
+			t.Fatalf("failed to listen on TCP: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+	})
+
+	respond := func(q *dnsMessage) *dnsMessage {
+		resp := handler(q)
+		if resp == nil {
+			return nil
+		}
+		resp.ID = q.ID
+		resp.Response = true
+		if resp.Questions == nil {
+			resp.Questions = q.Questions
+		}
+		return resp
+	}
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, err := unpackMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			resp := respond(q)
+			if resp == nil {
+				continue
+			}
+			b, err := resp.pack()
+			if err != nil {
+				continue
+			}
+			if len(b) > 512 && len(q.Additional) == 0 {
+				b, _ = (&dnsMessage{ID: resp.ID, Response: true, Truncated: true, Questions: q.Questions}).pack()
+			}
+			pc.WriteTo(b, addr)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					q, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					resp := respond(q)
+					if resp == nil {
+						return
+					}
+					if err := writeTCPMessage(conn, resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+// stubAnswer holds the IPv4 addresses a server started with
+// startAnswerServer answers A queries with. Tests change them with set while
+// the server goroutines read them.
+type stubAnswer struct {
+	mu    sync.Mutex
+	addrs [][]byte
+}
+
+func (a *stubAnswer) set(addrs ...[]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.addrs = addrs
+}
+
+func (a *stubAnswer) respond(t *testing.T, q *dnsMessage) *dnsMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	resp := &dnsMessage{}
+	for _, addr := range a.addrs {
+		resp.Answers = append(resp.Answers, mustRR(t, q.Questions[0].Name, TypeA, addr))
+	}
+	return resp
+}
+
+// startAnswerServer runs a stub server answering every query with the A
+// records held by the returned stubAnswer, initially addrs.
+func startAnswerServer(t *testing.T, addrs ...[]byte) (string, *stubAnswer) {
+	t.Helper()
+	answer := &stubAnswer{addrs: addrs}
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		return answer.respond(t, q)
+	})
+	return server, answer
+}
+
+func mustRR(t *testing.T, name string, rrtype uint16, data []byte) dnsRR {
+	t.Helper()
+	return dnsRR{Name: fqdn(name), Type: rrtype, Class: ClassINET, TTL: 300, Data: data}
+}
+
+func nameData(t *testing.T, prefix []byte, names ...string) []byte {
+	t.Helper()
+	b := append([]byte(nil), prefix...)
+	for _, name := range names {
+		var err error
+		if b, err = appendName(b, name); err != nil {
+			t.Fatalf("appendName(%q): %v", name, err)
+		}
+	}
+	return b
+}
+
+func TestDNSMessage_PackUnpack(t *testing.T) {
+	msg := newQuery("example.com", TypeMX)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Rcode = RcodeNXDomain
+	msg.Answers = []dnsRR{
+		mustRR(t, "example.com", TypeA, []byte{192, 0, 2, 1}),
+		mustRR(t, "example.com", TypeMX, nameData(t, []byte{0, 10}, "mail.example.com")),
+	}
+	msg.setEDNS0(4096, true)
+
+	b, err := msg.pack()
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	got, err := unpackMessage(b)
+	if err != nil {
+		t.Fatalf("unpack failed: %v", err)
+	}
+
+	if got.ID != msg.ID || !got.Response || !got.Authoritative || !got.RecursionDesired {
+		t.Errorf("header mismatch: %+v", got)
+	}
+	if got.Rcode != RcodeNXDomain {
+		t.Errorf("expected rcode %d, got %d", RcodeNXDomain, got.Rcode)
+	}
+	if len(got.Questions) != 1 || got.Questions[0].Name != "example.com." || got.Questions[0].Type != TypeMX {
+		t.Errorf("question mismatch: %+v", got.Questions)
+	}
+	if len(got.Answers) != 2 {
+		t.Fatalf("expected 2 answers, got %d", len(got.Answers))
+	}
+	if v := got.Answers[0].Value(); v != "192.0.2.1" {
+		t.Errorf("expected A value 192.0.2.1, got %s", v)
+	}
+	if v := got.Answers[1].Value(); v != "10 mail.example.com" {
+		t.Errorf("expected MX value '10 mail.example.com', got %s", v)
+	}
+	if len(got.Additional) != 1 || got.Additional[0].Type != TypeOPT || got.Additional[0].TTL&0x8000 == 0 {
+		t.Errorf("expected OPT record with DO bit, got %+v", got.Additional)
+	}
+}
+
+func TestUnpackMessage_Compression(t *testing.T) {
+	// Response for www.example.com CNAME with the target name compressed
+	// against the question.
+	b := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 5, 0, 1,
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 6,
+		3, 'c', 'd', 'n', 0xc0, 16,
+	}
+
+	msg, err := unpackMessage(b)
+	if err != nil {
+		t.Fatalf("unpack failed: %v", err)
+	}
+	if len(msg.Answers) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(msg.Answers))
+	}
+	if msg.Answers[0].Name != "www.example.com." {
+		t.Errorf("expected owner www.example.com., got %s", msg.Answers[0].Name)
+	}
+	if v := msg.Answers[0].Value(); v != "cdn.example.com" {
+		t.Errorf("expected cdn.example.com, got %s", v)
+	}
+}
+
+func TestUnpackMessage_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte{0, 1, 2}},
+		{"truncated question", []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'c', 'o'}},
+		{"pointer loop", []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12, 0, 1, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := unpackMessage(tt.data); err == nil {
+				t.Error("expected error but got none")
+			}
+		})
+	}
+}
+
+func TestStringToType(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint16
+		ok       bool
+	}{
+		{"A", TypeA, true},
+		{"dnskey", TypeDNSKEY, true},
+		{"TYPE65", 65, true},
+		{"BOGUS", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := stringToType(tt.input)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tt.expected, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestDNSClient_exchange(t *testing.T) {
+	var answers []dnsRR
+	for i := 0; i < 40; i++ {
+		answers = append(answers, mustRR(t, "big.example.com", TypeA, []byte{192, 0, 2, byte(i)}))
+	}
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		return &dnsMessage{Answers: answers}
+	})
+
+	client := NewDNSClient([]string{server}, 2*time.Second)
+	resp, err := client.exchange(server, newQuery("big.example.com", TypeA))
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if resp.Truncated {
+		t.Error("expected TCP retry to return a complete response")
+	}
+	if len(resp.Answers) != len(answers) {
+		t.Errorf("expected %d answers, got %d", len(answers), len(resp.Answers))
+	}
+}
+
+func TestDNSClient_exchangeAny_Failover(t *testing.T) {
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, 1})}}
+	})
+
+	client := NewDNSClient([]string{"127.0.0.1:1", server}, 500*time.Millisecond)
+	resp, used, err := client.exchangeAny(newQuery("example.com", TypeA))
+	if err != nil {
+		t.Fatalf("exchangeAny failed: %v", err)
+	}
+	if used != server {
+		t.Errorf("expected answer from %s, got %s", server, used)
+	}
+	if len(resp.Answers) != 1 {
+		t.Errorf("expected 1 answer, got %d", len(resp.Answers))
+	}
+}
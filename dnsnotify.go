@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// propagationPoll is how often servers are asked for the SOA serial while
+// waiting for a notified change to reach them.
+const propagationPoll = time.Second
+
+// notifyMessage is a NOTIFY (RFC 1996) accepted from a primary server.
+type notifyMessage struct {
+	Time      time.Time
+	Zone      string
+	Source    string
+	Serial    uint32
+	HasSerial bool
+}
+
+// answerNotify builds the response to a NOTIFY query from source. The
+// returned notification is nil when the query was refused or malformed.
+func answerNotify(q *dnsMessage, source net.IP, allowed []string) (*dnsMessage, *notifyMessage) {
+	resp := &dnsMessage{
+		ID:            q.ID,
+		Response:      true,
+		Opcode:        OpcodeNotify,
+		Authoritative: true,
+		Questions:     q.Questions,
+	}
+	if len(q.Questions) != 1 || q.Questions[0].Type != TypeSOA {
+		resp.Rcode = RcodeFormErr
+		return resp, nil
+	}
+	if !notifyAllowed(source, allowed) {
+		resp.Rcode = RcodeRefused
+		return resp, nil
+	}
+
+	n := &notifyMessage{
+		Time:   time.Now(),
+		Zone:   strings.TrimSuffix(strings.ToLower(q.Questions[0].Name), "."),
+		Source: source.String(),
+	}
+	for _, rr := range q.Answers {
+		if rr.Type == TypeSOA && canonicalName(rr.Name) == canonicalName(q.Questions[0].Name) {
+			if serial, err := soaSerial(rr); err == nil {
+				n.Serial, n.HasSerial = serial, true
+			}
+		}
+	}
+	return resp, n
+}
+
+// notifyAllowed reports whether NOTIFY messages are accepted from source,
+// which must be one of the listed addresses or networks.
+func notifyAllowed(source net.IP, allowed []string) bool {
+	for _, a := range allowed {
+		if _, network, err := net.ParseCIDR(a); err == nil {
+			if network.Contains(source) {
+				return true
+			}
+		} else if ip := net.ParseIP(a); ip != nil && ip.Equal(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenNotify starts answering NOTIFY messages on the UDP address addr in
+// the background and passes accepted ones to the monitor loop.
+func (m *Monitor) ListenNotify(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for NOTIFY on %s: %v", addr, err)
+	}
+	go m.serveNotify(pc)
+	return nil
+}
+
+func (m *Monitor) serveNotify(pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		size, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Printf("Warning: NOTIFY listener stopped: %v", err)
+			return
+		}
+		q, err := unpackMessage(buf[:size])
+		if err != nil || q.Response || q.Opcode != OpcodeNotify {
+			continue
+		}
+		var source net.IP
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			source = udp.IP
+		}
+
+		resp, n := answerNotify(q, source, m.config.NotifyFrom)
+		if n != nil {
+			// Targets change on reloads and through the API, so whether the
+			// zone is monitored is asked from the monitor loop.
+			m.do(context.Background(), func() {
+				targets, zones := m.notifyTargets(n.Zone)
+				if len(targets) == 0 && len(zones) == 0 {
+					resp.Rcode = RcodeRefused
+					n = nil
+				}
+			})
+		}
+		if b, err := resp.pack(); err == nil {
+			pc.WriteTo(b, addr)
+		}
+		if n == nil {
+			continue
+		}
+		select {
+		case m.notifies <- *n:
+		default:
+			log.Printf("Warning: Dropped NOTIFY for %s from %s, checks are falling behind", n.Zone, n.Source)
+		}
+	}
+}
+
+// inZone reports whether domain is zone or a name below it.
+func inZone(domain, zone string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	return domain == zone || strings.HasSuffix(domain, "."+zone)
+}
+
+// notifyTargets returns the targets in zone and, if it is transferred, the
+// zone itself.
+func (m *Monitor) notifyTargets(zone string) ([]Target, []string) {
+	var targets []Target
+	for _, t := range m.config.targets() {
+		if inZone(t.Domain, zone) {
+			targets = append(targets, t)
+		}
+	}
+	var zones []string
+	for _, z := range m.config.TransferZones {
+		if z == zone {
+			zones = append(zones, z)
+		}
+	}
+	return targets, zones
+}
+
+// handleNotify checks the targets and transferred zones affected by a
+// NOTIFY right away and starts measuring how long the new serial takes to
+// reach each server. It reports whether a check found a change. A zone
+// that is no longer monitored, after a reload since the NOTIFY was
+// accepted, is not tracked.
+func (m *Monitor) handleNotify(n notifyMessage) bool {
+	timestamp := n.Time.Format("2006-01-02 15:04:05")
+	serial := "serial unknown"
+	if n.HasSerial {
+		serial = fmt.Sprintf("serial %d", n.Serial)
+	}
+	message := fmt.Sprintf("[%s] %s - NOTIFY from %s (%s)", timestamp, n.Zone, n.Source, serial)
+	m.printColored(message, ColorBlue)
+	m.logger.Println(message)
+
+	targets, zones := m.notifyTargets(n.Zone)
+	if len(targets) == 0 && len(zones) == 0 {
+		return false
+	}
+	changed := m.checkTargets(targets, zones, false)
+
+	// One tracker follows a zone at a time; NOTIFYs arriving meanwhile, such
+	// as retransmissions, only trigger the checks.
+	if m.propagating == nil {
+		m.propagating = make(map[string]bool)
+	}
+	if !m.propagating[n.Zone] {
+		m.propagating[n.Zone] = true
+		go m.trackPropagation(n, m.propagationServers())
+	}
+	return changed
+}
+
+// propagationServers returns the servers whose SOA serial is followed
+// after a NOTIFY: the configured servers, or the client's default.
+func (m *Monitor) propagationServers() []string {
+	if len(m.config.Servers) > 0 {
+		return m.config.Servers
+	}
+	return m.dnsClient.servers
+}
+
+// trackPropagation polls servers until each of them serves at least the
+// notified serial, or the propagation timeout passes. Without a serial in
+// the NOTIFY, the serial is asked from the notifying server. Results are
+// reported from the monitor loop.
+func (m *Monitor) trackPropagation(n notifyMessage, servers []string) {
+	report := func(fn func()) {
+		m.do(context.Background(), fn)
+	}
+	defer report(func() { delete(m.propagating, n.Zone) })
+
+	serial := n.Serial
+	if !n.HasSerial {
+		var err error
+		serial, err = m.dnsClient.QuerySerial(net.JoinHostPort(n.Source, "53"), n.Zone)
+		if err != nil {
+			report(func() {
+				message := fmt.Sprintf("[%s] %s (SOA) - WARNING: cannot measure propagation: %v", time.Now().Format("2006-01-02 15:04:05"), n.Zone, err)
+				m.printColored(message, ColorYellow)
+				m.logger.Println(message)
+			})
+			return
+		}
+	}
+
+	pending := append([]string(nil), servers...)
+	last := make(map[string]uint32)
+	deadline := n.Time.Add(m.config.PropagationWait)
+	for {
+		var waiting []string
+		for _, server := range pending {
+			current, err := m.dnsClient.QuerySerial(server, n.Zone)
+			if err != nil || int32(current-serial) < 0 {
+				if err == nil {
+					last[server] = current
+				}
+				waiting = append(waiting, server)
+				continue
+			}
+			delay := time.Since(n.Time)
+			report(func() { m.reportPropagation(n.Zone, server, current, delay) })
+		}
+		pending = waiting
+		if len(pending) == 0 {
+			return
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(propagationPoll)
+	}
+
+	for _, server := range pending {
+		current, seen := last[server]
+		report(func() { m.reportStale(n.Zone, server, serial, current, seen) })
+	}
+}
+
+func (m *Monitor) reportPropagation(zone, server string, serial uint32, delay time.Duration) {
+	now := time.Now()
+	text := fmt.Sprintf("serial %d after %s", serial, delay.Round(time.Millisecond))
+	m.emit(Observation{Time: now, Domain: zone, Type: "SOA", Server: server, Status: StatusPropagated,
+		Values: []string{strconv.FormatUint(uint64(serial), 10)}, Message: text})
+	if m.metrics != nil {
+		m.metrics.ObservePropagation(zone, server, delay)
+	}
+	message := fmt.Sprintf("[%s] %s (SOA) @%s - %s", now.Format("2006-01-02 15:04:05"), zone, server, text)
+	m.printColored(message, ColorGreen)
+	m.logger.Println(message)
+}
+
+func (m *Monitor) reportStale(zone, server string, want, current uint32, seen bool) {
+	now := time.Now()
+	text := fmt.Sprintf("serial %d not served after %s", want, m.config.PropagationWait)
+	var values []string
+	if seen {
+		text += fmt.Sprintf(", still %d", current)
+		values = []string{strconv.FormatUint(uint64(current), 10)}
+	}
+	m.emit(Observation{Time: now, Domain: zone, Type: "SOA", Server: server, Status: StatusWarning, Values: values, Message: text})
+	message := fmt.Sprintf("[%s] %s (SOA) @%s - WARNING: %s", now.Format("2006-01-02 15:04:05"), zone, server, text)
+	m.printColored(message, ColorYellow)
+	m.logger.Println(message)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAnswerNotify(t *testing.T) {
+	zone := &testZoneServer{t: t}
+	notify := func(qtype uint16, answers ...dnsRR) *dnsMessage {
+		q := newQuery("Example.COM", qtype)
+		q.Opcode = OpcodeNotify
+		q.Answers = answers
+		return q
+	}
+	source := net.ParseIP("192.0.2.53")
+	allowed := []string{"192.0.2.53"}
+
+	resp, n := answerNotify(notify(TypeSOA, zone.soa(7)), source, allowed)
+	if resp.Rcode != RcodeSuccess || !resp.Response || resp.Opcode != OpcodeNotify || n == nil {
+		t.Fatalf("expected NOTIFY to be accepted, got rcode %d, %+v", resp.Rcode, n)
+	}
+	if n.Zone != "example.com" || n.Source != "192.0.2.53" || !n.HasSerial || n.Serial != 7 {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+
+	if _, n := answerNotify(notify(TypeSOA), source, []string{"198.51.100.1", "192.0.2.0/24"}); n == nil || n.HasSerial {
+		t.Errorf("expected NOTIFY without serial from an allowed network, got %+v", n)
+	}
+	if resp, n := answerNotify(notify(TypeSOA), source, []string{"198.51.100.1"}); resp.Rcode != RcodeRefused || n != nil {
+		t.Errorf("expected NOTIFY from another source to be refused, got rcode %d", resp.Rcode)
+	}
+	if resp, n := answerNotify(notify(TypeSOA), source, nil); resp.Rcode != RcodeRefused || n != nil {
+		t.Errorf("expected NOTIFY to be refused without allowed sources, got rcode %d", resp.Rcode)
+	}
+	if resp, n := answerNotify(notify(TypeA), source, allowed); resp.Rcode != RcodeFormErr || n != nil {
+		t.Errorf("expected FORMERR for a NOTIFY that is not about SOA, got rcode %d", resp.Rcode)
+	}
+}
+
+func TestMonitor_NOTIFY(t *testing.T) {
+	zone := &testZoneServer{t: t}
+	var serial atomic.Uint32
+	serial.Store(1)
+	handler := func(serial func() uint32) func(q *dnsMessage) *dnsMessage {
+		return func(q *dnsMessage) *dnsMessage {
+			switch q.Questions[0].Type {
+			case TypeSOA:
+				return &dnsMessage{Answers: []dnsRR{zone.soa(serial())}}
+			case TypeA:
+				return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, 1})}}
+			}
+			return &dnsMessage{Rcode: RcodeNotImp}
+		}
+	}
+	secondary := startStubServer(t, handler(serial.Load))
+	stale := startStubServer(t, handler(func() uint32 { return 1 }))
+
+	config := &Config{
+		Domains:         []string{"www.example.com", "other.example.org"},
+		RecordType:      "A",
+		Interval:        time.Minute,
+		Servers:         []string{secondary, stale},
+		NoColor:         true,
+		NotifyFrom:      []string{"127.0.0.1"},
+		PropagationWait: time.Second,
+	}
+	monitor := NewMonitor(config)
+	var buf bytes.Buffer
+	monitor.logger = log.New(&buf, "", 0)
+	monitor.metrics = NewMetrics()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case fn := <-monitor.control:
+				fn()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	defer pc.Close()
+	go monitor.serveNotify(pc)
+
+	notify := func(zoneName string) (*dnsMessage, error) {
+		q := newQuery(zoneName, TypeSOA)
+		q.Opcode = OpcodeNotify
+		q.RecursionDesired = false
+		q.Answers = []dnsRR{zone.soa(2)}
+		return NewDNSClient(nil, 2*time.Second).exchange(pc.LocalAddr().String(), q)
+	}
+	resp, err := notify("example.net")
+	if err != nil || resp.Rcode != RcodeRefused || len(monitor.notifies) != 0 {
+		t.Fatalf("expected NOTIFY for a zone without targets to be refused, got %+v, %v", resp, err)
+	}
+	resp, err = notify("example.com")
+	if err != nil || resp.Rcode != RcodeSuccess || resp.Opcode != OpcodeNotify {
+		t.Fatalf("expected NOTIFY to be acknowledged, got %+v, %v", resp, err)
+	}
+
+	var n notifyMessage
+	select {
+	case n = <-monitor.notifies:
+	case <-time.After(2 * time.Second):
+		t.Fatal("NOTIFY was not passed to the monitor loop")
+	}
+	serial.Store(2)
+	monitor.do(context.Background(), func() { monitor.handleNotify(n) })
+
+	var output string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		monitor.do(context.Background(), func() { output = buf.String() })
+		if strings.Contains(output, "WARNING") {
+			break
+		}
+	}
+
+	for _, want := range []string{
+		"example.com - NOTIFY from 127.0.0.1 (serial 2)",
+		"www.example.com",
+		"example.com (SOA) @" + secondary + " - serial 2 after",
+		"example.com (SOA) @" + stale + " - WARNING: serial 2 not served after 1s, still 1",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in log, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "other.example.org") {
+		t.Errorf("expected only targets in the notified zone to be checked, got %q", output)
+	}
+
+	var metrics bytes.Buffer
+	monitor.metrics.Write(&metrics)
+	if !strings.Contains(metrics.String(), `dns_monitor_notify_propagation_seconds{zone="example.com",server="`+secondary+`"}`) {
+		t.Errorf("expected propagation delay metric, got %s", metrics.String())
+	}
+}
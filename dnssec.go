@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type RRSIG struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  time.Time
+	Inception   time.Time
+	KeyTag      uint16
+	SignerName  string
+}
+
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	KeyTag    uint16
+}
+
+// parseRRSIG decodes RRSIG rdata (RFC 4034 section 3.1). The 32-bit
+// timestamps use serial number arithmetic, so they are resolved relative to
+// now.
+func parseRRSIG(data []byte, now time.Time) (*RRSIG, error) {
+	if len(data) < 18 {
+		return nil, fmt.Errorf("RRSIG rdata too short")
+	}
+	signer, _, err := rdataName(data, 18)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RRSIG signer name: %v", err)
+	}
+	return &RRSIG{
+		TypeCovered: binary.BigEndian.Uint16(data[0:]),
+		Algorithm:   data[2],
+		Labels:      data[3],
+		OriginalTTL: binary.BigEndian.Uint32(data[4:]),
+		Expiration:  serialTime(binary.BigEndian.Uint32(data[8:]), now),
+		Inception:   serialTime(binary.BigEndian.Uint32(data[12:]), now),
+		KeyTag:      binary.BigEndian.Uint16(data[16:]),
+		SignerName:  signer,
+	}, nil
+}
+
+func serialTime(t uint32, now time.Time) time.Time {
+	delta := int32(t - uint32(now.Unix()))
+	return time.Unix(now.Unix()+int64(delta), 0)
+}
+
+func parseDNSKEY(data []byte) (*DNSKEY, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("DNSKEY rdata too short")
+	}
+	return &DNSKEY{
+		Flags:     binary.BigEndian.Uint16(data[0:]),
+		Protocol:  data[2],
+		Algorithm: data[3],
+		KeyTag:    keyTag(data),
+	}, nil
+}
+
+// keyTag computes the key tag of DNSKEY rdata as described in RFC 4034
+// Appendix B.
+func keyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+func (k *DNSKEY) Role() string {
+	role := "ZSK"
+	if k.Flags&0x0001 != 0 {
+		role = "KSK"
+	}
+	if k.Flags&0x0080 != 0 {
+		role += ", REVOKED"
+	}
+	return role
+}
+
+func (k *DNSKEY) String() string {
+	return fmt.Sprintf("%d %d %d keytag=%d (%s)", k.Flags, k.Protocol, k.Algorithm, k.KeyTag, k.Role())
+}
+
+func (s *RRSIG) String() string {
+	return fmt.Sprintf("%s keytag=%d signer=%s", typeToString(s.TypeCovered), s.KeyTag, strings.TrimSuffix(s.SignerName, "."))
+}
+
+func (c *DNSClient) queryDNSSEC(name string, qtype uint16) (*dnsMessage, error) {
+	msg := newQuery(name, qtype)
+	msg.setEDNS0(4096, true)
+	resp, _, err := c.exchangeAny(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %s record for %s: %v", typeToString(qtype), name, err)
+	}
+	if resp.Rcode != RcodeSuccess {
+		return nil, fmt.Errorf("failed to lookup %s record for %s: %s", typeToString(qtype), name, rcodeToString(resp.Rcode))
+	}
+	return resp, nil
+}
+
+func answerSignatures(resp *dnsMessage, now time.Time) []*RRSIG {
+	var sigs []*RRSIG
+	for _, rr := range resp.Answers {
+		if rr.Type != TypeRRSIG {
+			continue
+		}
+		if sig, err := parseRRSIG(rr.Data, now); err == nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// QuerySignatures returns the RRSIGs served alongside the answer for domain,
+// including those covering any CNAMEs followed on the way.
+func (c *DNSClient) QuerySignatures(domain, recordType string) ([]*RRSIG, error) {
+	qtype, ok := stringToType(recordType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	resp, err := c.queryDNSSEC(domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return answerSignatures(resp, time.Now()), nil
+}
+
+// QueryDNSKEY returns the DNSKEY set of zone together with the signatures
+// covering it.
+func (c *DNSClient) QueryDNSKEY(zone string) (*DNSRecord, []*RRSIG, error) {
+	resp, err := c.queryDNSSEC(zone, TypeDNSKEY)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	for _, rr := range resp.Answers {
+		if rr.Type != TypeDNSKEY {
+			continue
+		}
+		if key, err := parseDNSKEY(rr.Data); err == nil {
+			keys = append(keys, key.String())
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no DNSKEY records found for %s", zone)
+	}
+
+	sort.Strings(keys)
+	return &DNSRecord{
+		Domain: zone,
+		Type:   "DNSKEY",
+		Values: keys,
+	}, answerSignatures(resp, time.Now()), nil
+}
+
+func (c *DNSClient) QueryDS(zone string) (*DNSRecord, error) {
+	resp, err := c.queryDNSSEC(zone, TypeDS)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, rr := range resp.Answers {
+		if rr.Type == TypeDS {
+			values = append(values, rr.Value())
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no DS records found for %s", zone)
+	}
+
+	sort.Strings(values)
+	return &DNSRecord{
+		Domain: zone,
+		Type:   "DS",
+		Values: values,
+	}, nil
+}
+
+func formatRemaining(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// checkDNSSEC inspects the signatures of every monitored record, then the
+// DNSKEY and DS sets of each signing zone. Key set changes are reported like
+// record changes so that rollovers can be followed step by step.
+func (m *Monitor) checkDNSSEC(timestamp string) bool {
+	now := time.Now()
+	var zones []string
+	seen := make(map[string]bool)
+
+	for _, target := range m.config.targets() {
+		domain := target.Domain
+		label := fmt.Sprintf("%s (RRSIG %s)", domain, target.Type)
+		sigs, err := m.dnsClient.QuerySignatures(domain, target.Type)
+		if err != nil {
+			m.emit(Observation{Time: now, Domain: domain, Type: "RRSIG " + target.Type, Status: StatusError, Error: err.Error()})
+			message := fmt.Sprintf("[%s] %s - ERROR: %v", timestamp, label, err)
+			m.printColored(message, ColorYellow)
+			m.logger.Println(message)
+			continue
+		}
+		if len(sigs) == 0 {
+			m.emit(Observation{Time: now, Domain: domain, Type: "RRSIG " + target.Type, Status: StatusWarning, Message: "no signatures found"})
+			message := fmt.Sprintf("[%s] %s - WARNING: no signatures found", timestamp, label)
+			m.printColored(message, ColorYellow)
+			m.logger.Println(message)
+			continue
+		}
+
+		m.checkSignatureExpiry(timestamp, domain, target.Type, sigs, now)
+		for _, sig := range sigs {
+			zone := strings.TrimSuffix(canonicalName(sig.SignerName), ".")
+			if !seen[zone] {
+				seen[zone] = true
+				zones = append(zones, zone)
+			}
+		}
+	}
+
+	hasChanges := false
+	for _, zone := range zones {
+		record, sigs, err := m.dnsClient.QueryDNSKEY(zone)
+		if err == nil {
+			m.checkSignatureExpiry(timestamp, zone, "DNSKEY", sigs, now)
+		}
+		if m.checkKeySet(timestamp, zone, "DNSKEY", record, err) {
+			hasChanges = true
+		}
+
+		record, err = m.dnsClient.QueryDS(zone)
+		if m.checkKeySet(timestamp, zone, "DS", record, err) {
+			hasChanges = true
+		}
+	}
+	return hasChanges
+}
+
+func (m *Monitor) checkSignatureExpiry(timestamp, domain, covered string, sigs []*RRSIG, now time.Time) {
+	label := fmt.Sprintf("%s (RRSIG %s)", domain, covered)
+	var earliest *RRSIG
+	warned := false
+
+	for _, sig := range sigs {
+		// Signatures outside their validity period break validation, so
+		// they are errors; only an upcoming expiry is a warning.
+		var problem, warning string
+		switch {
+		case now.Before(sig.Inception):
+			problem = fmt.Sprintf("signature %s not valid until %s",
+				sig, sig.Inception.UTC().Format(time.RFC3339))
+		case !now.Before(sig.Expiration):
+			problem = fmt.Sprintf("signature %s expired %s ago (%s)",
+				sig, formatRemaining(now.Sub(sig.Expiration)), sig.Expiration.UTC().Format(time.RFC3339))
+		case sig.Expiration.Sub(now) <= m.config.SigExpiryWarn:
+			warning = fmt.Sprintf("WARNING: signature %s expires in %s (%s)",
+				sig, formatRemaining(sig.Expiration.Sub(now)), sig.Expiration.UTC().Format(time.RFC3339))
+		}
+		if problem != "" {
+			m.emit(Observation{Time: now, Domain: domain, Type: "RRSIG " + covered, Status: StatusError, Error: problem})
+			warning = "ERROR: " + problem
+		} else if warning != "" {
+			m.emit(Observation{Time: now, Domain: domain, Type: "RRSIG " + covered, Status: StatusWarning, Message: warning})
+		}
+		if warning != "" {
+			message := fmt.Sprintf("[%s] %s - %s", timestamp, label, warning)
+			m.printColored(message, ColorYellow)
+			m.logger.Println(message)
+			warned = true
+		}
+		if earliest == nil || sig.Expiration.Before(earliest.Expiration) {
+			earliest = sig
+		}
+	}
+
+	if !warned && earliest != nil {
+		message := fmt.Sprintf("[%s] %s - %d signature(s) valid, earliest expiry in %s (%s)",
+			timestamp, label, len(sigs), formatRemaining(earliest.Expiration.Sub(now)), earliest.Expiration.UTC().Format(time.RFC3339))
+		m.printColored(message, ColorGreen)
+		m.logger.Println(message)
+	}
+}
+
+func (m *Monitor) checkKeySet(timestamp, zone, keyType string, record *DNSRecord, err error) bool {
+	obs := Observation{Time: time.Now(), Domain: zone, Type: keyType}
+	if err != nil {
+		obs.Status = StatusError
+		obs.Error = err.Error()
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - ERROR: %v", timestamp, zone, keyType, err)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+	}
+
+	key := fmt.Sprintf("%s:%s", zone, keyType)
+	lastRecord, exists := m.lastRecords[key]
+	obs.Values = record.Values
+
+	if !exists {
+		obs.Status = StatusInitial
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - Initial: %s", timestamp, zone, keyType, record.String())
+		m.printColored(message, ColorGreen)
+		m.logger.Println(message)
+		m.lastRecords[key] = record
+		return false
+	}
+
+	if !record.Equals(lastRecord) {
+		obs.Status = StatusChanged
+		obs.Previous = lastRecord.Values
+		m.emit(obs)
+
+		message := fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED:", timestamp, zone, keyType)
+		m.printColored(message, ColorRed)
+		m.logger.Println(message)
+
+		added, removed := lastRecord.Diff(record)
+		for _, value := range added {
+			addedMsg := fmt.Sprintf("  Added:   %s", value)
+			m.printColored(addedMsg, ColorBlue)
+			m.logger.Println(addedMsg)
+		}
+		for _, value := range removed {
+			removedMsg := fmt.Sprintf("  Removed: %s", value)
+			m.printColored(removedMsg, ColorRed)
+			m.logger.Println(removedMsg)
+		}
+
+		m.lastRecords[key] = record
+		return true
+	}
+
+	obs.Status = StatusUnchanged
+	m.emit(obs)
+
+	message := fmt.Sprintf("[%s] %s (%s) - No change: %d record(s)", timestamp, zone, keyType, len(record.Values))
+	m.printColored(message, ColorGreen)
+	m.logger.Println(message)
+	return false
+}
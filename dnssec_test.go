@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rootKSK2017 = "AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU="
+
+func dnskeyData(t *testing.T, flags uint16, algorithm uint8, key string) []byte {
+	t.Helper()
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatalf("invalid key: %v", err)
+	}
+	b := binary.BigEndian.AppendUint16(nil, flags)
+	b = append(b, 3, algorithm)
+	return append(b, pub...)
+}
+
+func rrsigData(t *testing.T, covered uint16, inception, expiration time.Time, tag uint16, signer string) []byte {
+	t.Helper()
+	b := binary.BigEndian.AppendUint16(nil, covered)
+	b = append(b, 13, 2)
+	b = binary.BigEndian.AppendUint32(b, 300)
+	b = binary.BigEndian.AppendUint32(b, uint32(expiration.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(inception.Unix()))
+	b = binary.BigEndian.AppendUint16(b, tag)
+	b = nameData(t, b, signer)
+	return append(b, 0xde, 0xad, 0xbe, 0xef)
+}
+
+func TestKeyTag(t *testing.T) {
+	key, err := parseDNSKEY(dnskeyData(t, 257, 8, rootKSK2017))
+	if err != nil {
+		t.Fatalf("parseDNSKEY failed: %v", err)
+	}
+	if key.KeyTag != 20326 {
+		t.Errorf("expected key tag 20326, got %d", key.KeyTag)
+	}
+	if key.Role() != "KSK" {
+		t.Errorf("expected KSK, got %s", key.Role())
+	}
+	if got := key.String(); got != "257 3 8 keytag=20326 (KSK)" {
+		t.Errorf("unexpected key description: %s", got)
+	}
+}
+
+func TestParseRRSIG(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	inception := now.Add(-24 * time.Hour)
+	expiration := now.Add(48 * time.Hour)
+
+	sig, err := parseRRSIG(rrsigData(t, TypeA, inception, expiration, 4242, "example.com"), now)
+	if err != nil {
+		t.Fatalf("parseRRSIG failed: %v", err)
+	}
+	if sig.TypeCovered != TypeA || sig.KeyTag != 4242 || sig.SignerName != "example.com." {
+		t.Errorf("unexpected signature fields: %+v", sig)
+	}
+	if !sig.Inception.Equal(inception) || !sig.Expiration.Equal(expiration) {
+		t.Errorf("expected validity %s - %s, got %s - %s", inception, expiration, sig.Inception, sig.Expiration)
+	}
+
+	if _, err := parseRRSIG([]byte{0, 1, 2}, now); err == nil {
+		t.Error("expected error for short rdata")
+	}
+}
+
+func TestSerialTime_Wraparound(t *testing.T) {
+	// Shortly before the 32-bit timestamp wraps, a signature expiring after
+	// the wrap must still be seen as being in the future.
+	now := time.Unix(1<<32-3600, 0)
+	got := serialTime(uint32(3600), now)
+	if want := time.Unix(1<<32+3600, 0); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestFormatRemaining(t *testing.T) {
+	tests := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{50 * time.Hour, "2d2h"},
+		{90 * time.Minute, "1h30m"},
+		{5 * time.Minute, "5m"},
+		{-3 * time.Hour, "3h0m"},
+	}
+
+	for _, tt := range tests {
+		if got := formatRemaining(tt.input); got != tt.expected {
+			t.Errorf("formatRemaining(%s): expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDNSClient_QuerySignaturesAndKeys(t *testing.T) {
+	now := time.Now()
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		name := q.Questions[0].Name
+		switch q.Questions[0].Type {
+		case TypeA:
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeA, []byte{192, 0, 2, 1}),
+				mustRR(t, name, TypeRRSIG, rrsigData(t, TypeA, now.Add(-time.Hour), now.Add(time.Hour), 1111, "example.com")),
+			}}
+		case TypeDNSKEY:
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeDNSKEY, dnskeyData(t, 257, 8, rootKSK2017)),
+				mustRR(t, name, TypeRRSIG, rrsigData(t, TypeDNSKEY, now.Add(-time.Hour), now.Add(240*time.Hour), 20326, "example.com")),
+			}}
+		case TypeDS:
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeDS, []byte{0x4f, 0x66, 8, 2, 0xab, 0xcd}),
+			}}
+		}
+		return &dnsMessage{Rcode: RcodeNXDomain}
+	})
+	client := NewDNSClient([]string{server}, 2*time.Second)
+
+	sigs, err := client.QuerySignatures("www.example.com", "A")
+	if err != nil {
+		t.Fatalf("QuerySignatures failed: %v", err)
+	}
+	if len(sigs) != 1 || sigs[0].KeyTag != 1111 {
+		t.Errorf("unexpected signatures: %+v", sigs)
+	}
+
+	keys, keySigs, err := client.QueryDNSKEY("example.com")
+	if err != nil {
+		t.Fatalf("QueryDNSKEY failed: %v", err)
+	}
+	if len(keys.Values) != 1 || keys.Values[0] != "257 3 8 keytag=20326 (KSK)" {
+		t.Errorf("unexpected DNSKEY values: %v", keys.Values)
+	}
+	if len(keySigs) != 1 || keySigs[0].TypeCovered != TypeDNSKEY {
+		t.Errorf("unexpected DNSKEY signatures: %+v", keySigs)
+	}
+
+	ds, err := client.QueryDS("example.com")
+	if err != nil {
+		t.Fatalf("QueryDS failed: %v", err)
+	}
+	if len(ds.Values) != 1 || ds.Values[0] != "20326 8 2 ABCD" {
+		t.Errorf("unexpected DS values: %v", ds.Values)
+	}
+
+	if _, err := client.QuerySignatures("missing.example.com", "TXT"); err == nil {
+		t.Error("expected error for NXDOMAIN response")
+	}
+}
+
+func TestMonitor_checkSignatureExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		inception  time.Time
+		expiration time.Time
+		expected   string
+		status     string
+	}{
+		{"valid", now.Add(-time.Hour), now.Add(30 * 24 * time.Hour), "signature(s) valid", ""},
+		{"expiring soon", now.Add(-time.Hour), now.Add(10 * time.Hour), "WARNING: signature A keytag=1 signer=example.com expires in", StatusWarning},
+		{"expired", now.Add(-48 * time.Hour), now.Add(-time.Hour), "ERROR: signature A keytag=1 signer=example.com expired", StatusError},
+		{"not yet valid", now.Add(time.Hour), now.Add(48 * time.Hour), "ERROR: signature A keytag=1 signer=example.com not valid until", StatusError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor, buf := newTestMonitor(t, &Config{NoColor: true, SigExpiryWarn: 72 * time.Hour}, "")
+			monitor.metrics = NewMetrics()
+			sig := &RRSIG{TypeCovered: TypeA, KeyTag: 1, SignerName: "example.com.", Inception: tt.inception, Expiration: tt.expiration}
+
+			monitor.checkSignatureExpiry("ts", "example.com", "A", []*RRSIG{sig}, now)
+			if !strings.Contains(buf.String(), tt.expected) {
+				t.Errorf("expected log to contain %q, got %q", tt.expected, buf.String())
+			}
+			emitted := 0
+			for key, n := range monitor.metrics.checks {
+				emitted += int(n)
+				if key[3] != tt.status {
+					t.Errorf("expected status %q, got %q", tt.status, key[3])
+				}
+			}
+			want := 0
+			if tt.status != "" {
+				want = 1
+			}
+			if emitted != want {
+				t.Errorf("expected %d observation(s), got %d", want, emitted)
+			}
+		})
+	}
+}
+
+func TestMonitor_checkKeySet(t *testing.T) {
+	monitor, buf := newTestMonitor(t, &Config{NoColor: true}, "")
+
+	before := &DNSRecord{Domain: "example.com", Type: "DNSKEY", Values: []string{"256 3 13 keytag=1 (ZSK)", "257 3 13 keytag=2 (KSK)"}}
+	after := &DNSRecord{Domain: "example.com", Type: "DNSKEY", Values: []string{"256 3 13 keytag=1 (ZSK)", "257 3 13 keytag=2 (KSK)", "257 3 13 keytag=3 (KSK)"}}
+
+	if monitor.checkKeySet("ts", "example.com", "DNSKEY", before, nil) {
+		t.Error("initial key set should not be reported as a change")
+	}
+	if monitor.checkKeySet("ts", "example.com", "DNSKEY", before, nil) {
+		t.Error("unchanged key set should not be reported as a change")
+	}
+	if !monitor.checkKeySet("ts", "example.com", "DNSKEY", after, nil) {
+		t.Error("expected key addition to be reported as a change")
+	}
+	if !strings.Contains(buf.String(), "Added:   257 3 13 keytag=3 (KSK)") {
+		t.Errorf("expected added key in log, got %q", buf.String())
+	}
+	if monitor.lastRecords["example.com:DNSKEY"] != after {
+		t.Error("last key set should have been updated")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdinTargets caches the entries read from "--domains-file -", since
+// standard input can only be read once but a reload parses the arguments
+// again.
+var stdinTargets struct {
+	once    sync.Once
+	content string
+	err     error
+}
+
+// readDomainsFile reads targets from path, or from standard input when path
+// is "-". Each line holds "domain [type] [expected...]"; the type defaults
+// to recordType and the expected values to expected. Values containing
+// spaces, such as TXT strings, are double-quoted; an MX preference may also
+// be followed by the host unquoted. Blank lines and everything after a "#"
+// outside quotes are ignored.
+func readDomainsFile(path, recordType string, expected []string) ([]Target, error) {
+	if path == "-" {
+		stdinTargets.once.Do(func() {
+			b, err := io.ReadAll(os.Stdin)
+			stdinTargets.content, stdinTargets.err = string(b), err
+		})
+		if stdinTargets.err != nil {
+			return nil, fmt.Errorf("failed to read domains from stdin: %v", stdinTargets.err)
+		}
+		return parseDomainsList(strings.NewReader(stdinTargets.content), "stdin", recordType, expected)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domains file: %v", err)
+	}
+	defer f.Close()
+	return parseDomainsList(f, path, recordType, expected)
+}
+
+func parseDomainsList(r io.Reader, name, recordType string, expected []string) ([]Target, error) {
+	var targets []Target
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		target := Target{Domain: fields[0], Type: recordType, Expected: expected}
+		if strings.HasPrefix(target.Domain, "-") {
+			return nil, fmt.Errorf("%s:%d: invalid domain: %s", name, n, target.Domain)
+		}
+		fields = fields[1:]
+		if len(fields) > 0 {
+			if t := strings.ToUpper(fields[0]); isValidRecordType(t) {
+				target.Type = t
+				fields = fields[1:]
+			} else if _, known := stringToType(t); known {
+				return nil, fmt.Errorf("%s:%d: unsupported record type: %s", name, n, fields[0])
+			}
+		}
+		if target.Type == "MX" {
+			fields = joinMXFields(fields)
+		}
+		if len(fields) > 0 {
+			target.Expected = fields
+		}
+		targets = append(targets, target)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return targets, nil
+}
+
+// splitFields splits line at whitespace, keeping double-quoted strings
+// together and stopping at a "#" outside quotes.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range line {
+		switch {
+		case quoted && r == '"':
+			quoted = false
+		case quoted:
+			field.WriteRune(r)
+		case r == '"':
+			quoted, inField = true, true
+		case r == '#':
+			if inField {
+				fields = append(fields, field.String())
+			}
+			return fields, nil
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// joinMXFields joins each numeric preference with the host following it,
+// matching how MX values are presented.
+func joinMXFields(fields []string) []string {
+	var values []string
+	for i := 0; i < len(fields); i++ {
+		if _, err := strconv.ParseUint(fields[i], 10, 16); err == nil && i+1 < len(fields) {
+			values = append(values, fields[i]+" "+strings.TrimSuffix(fields[i+1], "."))
+			i++
+			continue
+		}
+		values = append(values, fields[i])
+	}
+	return values
+}
+
+// addTargets appends targets to the configuration, skipping any that are
+// already monitored.
+func (c *Config) addTargets(targets []Target) {
+	seen := make(map[string]bool)
+	for _, t := range c.targets() {
+		seen[t.Key()] = true
+	}
+	for _, t := range targets {
+		if seen[t.Key()] {
+			continue
+		}
+		seen[t.Key()] = true
+		c.Targets = append(c.Targets, t)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDomainsList(t *testing.T) {
+	input := `# inventory export
+example.com
+www.example.com   cname  example.com.   # CDN alias
+mail.example.com  MX     10 mx1.example.com. "20 mx2.example.com"
+example.com TXT "v=spf1 -all" # no mail
+
+api.example.com 192.0.2.1 192.0.2.2
+`
+	targets, err := parseDomainsList(strings.NewReader(input), "inventory", "A", []string{"192.0.2.9"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		key      string
+		expected string
+	}{
+		{"example.com:A", "192.0.2.9"},
+		{"www.example.com:CNAME", "example.com."},
+		{"mail.example.com:MX", "10 mx1.example.com|20 mx2.example.com"},
+		{"example.com:TXT", "v=spf1 -all"},
+		{"api.example.com:A", "192.0.2.1|192.0.2.2"},
+	}
+	if len(targets) != len(expected) {
+		t.Fatalf("expected %d targets, got %+v", len(expected), targets)
+	}
+	for i, want := range expected {
+		if targets[i].Key() != want.key || strings.Join(targets[i].Expected, "|") != want.expected {
+			t.Errorf("entry %d: expected %s %q, got %s %q", i, want.key, want.expected, targets[i].Key(), strings.Join(targets[i].Expected, "|"))
+		}
+	}
+
+	for _, bad := range []string{"example.com SOA", "--all-servers", `example.com TXT "open`} {
+		if _, err := parseDomainsList(strings.NewReader("ok.example.com\n"+bad), "inventory", "A", nil); err == nil || !strings.HasPrefix(err.Error(), "inventory:2:") {
+			t.Errorf("%q: expected error for line 2, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseArgs_DomainsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(path, []byte("example.com\nexample.org MX\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseArgs([]string{"dns-monitor", "--domains-file", path, "-t", "AAAA", "example.com", "example.net"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var keys []string
+	for _, target := range config.targets() {
+		keys = append(keys, target.Key())
+	}
+	if got := strings.Join(keys, " "); got != "example.com:AAAA example.net:AAAA example.org:MX" {
+		t.Errorf("expected positional domains merged with the file without duplicates, got %s", got)
+	}
+
+	config, err = ParseArgs([]string{"dns-monitor", "--domains-file", path})
+	if err != nil || len(config.targets()) != 2 {
+		t.Errorf("expected a domains file to be enough, got %v, %v", config, err)
+	}
+
+	if _, err := ParseArgs([]string{"dns-monitor", "--domains-file", filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected error for missing domains file")
+	}
+}
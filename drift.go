@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Drift states of one record set on one server. The exit code of the drift
+// command is 1 when any set is missing, extra or mismatched, and 2 when
+// there is no drift but some lookups failed.
+const (
+	DriftInSync   = "in-sync"
+	DriftMissing  = "missing"
+	DriftExtra    = "extra"
+	DriftMismatch = "mismatch"
+	DriftError    = "error"
+)
+
+type DriftConfig struct {
+	Sources  []string
+	Servers  []string
+	Zone     string
+	Origin   string
+	Resolver string
+	Timeout  time.Duration
+	JSON     bool
+	ShowHelp bool
+}
+
+// DriftResult compares the desired values of a name and type with what one
+// server answers.
+type DriftResult struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Server   string   `json:"server"`
+	Status   string   `json:"status"`
+	Expected []string `json:"expected"`
+	Actual   []string `json:"actual,omitempty"`
+	Missing  []string `json:"missing,omitempty"`
+	Extra    []string `json:"extra,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// ParseDriftArgs parses the arguments following the "drift" subcommand.
+func ParseDriftArgs(args []string) (*DriftConfig, error) {
+	config := &DriftConfig{
+		Resolver: "8.8.8.8:53",
+		Timeout:  3 * time.Second,
+	}
+
+	i := 1
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "-h" || arg == "--help":
+			config.ShowHelp = true
+			return config, nil
+		case arg == "-s" || arg == "--server" || arg == "--resolver":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			server := args[i+1]
+			if !strings.Contains(server, ":") {
+				server += ":53"
+			}
+			if arg == "--resolver" {
+				config.Resolver = server
+			} else {
+				config.Servers = append(config.Servers, server)
+			}
+			i += 2
+		case arg == "--zone":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.Zone = strings.ToLower(strings.TrimSuffix(args[i+1], "."))
+			i += 2
+		case arg == "--origin":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.Origin = strings.ToLower(strings.TrimSuffix(args[i+1], "."))
+			i += 2
+		case arg == "--timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %v", err)
+			}
+			config.Timeout = duration
+			i += 2
+		case arg == "--json":
+			config.JSON = true
+			i++
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown option: %s", arg)
+		default:
+			config.Sources = append(config.Sources, arg)
+			i++
+		}
+	}
+
+	if len(config.Sources) == 0 {
+		return nil, fmt.Errorf("at least one zone file or JSON export must be specified")
+	}
+	return config, nil
+}
+
+// readDesiredState reads records from a zone file, with origin for files
+// without $ORIGIN, or from a JSON export when the file name ends in .json or
+// the content starts with "{" or "[".
+func readDesiredState(path, origin string) ([]zoneRecord, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read desired state: %v", err)
+	}
+	trimmed := strings.TrimSpace(string(b))
+	if strings.EqualFold(filepath.Ext(path), ".json") || strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		records, err := parseRecordExport(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return records, nil
+	}
+	return readZoneFile(path, origin)
+}
+
+// exportRecord covers the record formats of common DNS provider exports:
+// plain {name, type, ttl, value(s)} objects, Cloudflare's content and
+// priority, and Route 53 resource record sets.
+type exportRecord struct {
+	Name            string
+	Type            string
+	TTL             uint32
+	Value           string
+	Values          []string
+	Content         string
+	Data            string
+	Priority        *uint16
+	ResourceRecords []struct {
+		Value string
+	}
+}
+
+// parseRecordExport parses a JSON array of records, or an object holding one
+// under "records", "result" (Cloudflare) or "ResourceRecordSets" (Route 53).
+func parseRecordExport(b []byte) ([]zoneRecord, error) {
+	var list []exportRecord
+	if err := json.Unmarshal(b, &list); err != nil {
+		var export struct {
+			Records            []exportRecord
+			Result             []exportRecord
+			ResourceRecordSets []exportRecord
+		}
+		if err := json.Unmarshal(b, &export); err != nil {
+			return nil, fmt.Errorf("invalid JSON export: %v", err)
+		}
+		list = append(append(export.Records, export.Result...), export.ResourceRecordSets...)
+	}
+
+	var records []zoneRecord
+	for i, e := range list {
+		name := strings.ToLower(strings.TrimSuffix(unescapeZoneString(e.Name), "."))
+		recordType := strings.ToUpper(e.Type)
+		if name == "" || !isTypeMnemonic(recordType) {
+			return nil, fmt.Errorf("record %d: name and type are required", i+1)
+		}
+
+		values := e.Values
+		for _, v := range []string{e.Value, e.Content, e.Data} {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+		for _, rr := range e.ResourceRecords {
+			values = append(values, rr.Value)
+		}
+		for _, v := range values {
+			if recordType == "MX" && e.Priority != nil && len(strings.Fields(v)) == 1 {
+				v = fmt.Sprintf("%d %s", *e.Priority, v)
+			}
+			value, err := exportValue(recordType, v)
+			if err != nil {
+				return nil, fmt.Errorf("record %d (%s %s): %v", i+1, name, recordType, err)
+			}
+			records = append(records, zoneRecord{Name: name, TTL: e.TTL, Type: recordType, Value: value})
+		}
+	}
+	return records, nil
+}
+
+// exportValue converts a value from an export to presentation format. Names
+// are taken as absolute. Unquoted TXT values are used as they are, since
+// providers differ in whether they quote them.
+func exportValue(recordType, value string) (string, error) {
+	if (recordType == "TXT" || recordType == "SPF") && !strings.HasPrefix(value, `"`) {
+		return value, nil
+	}
+	entries, err := scanZone(strings.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+	if len(entries) != 1 {
+		return "", fmt.Errorf("invalid value: %q", value)
+	}
+	return zoneValue(recordType, entries[0].tokens, "")
+}
+
+// driftZone returns the zone whose name servers are checked: the owner of
+// the SOA record if there is one, else the shortest name.
+func driftZone(records []zoneRecord) string {
+	zone := ""
+	for _, r := range records {
+		if r.Type == "SOA" {
+			return strings.ToLower(r.Name)
+		}
+		if zone == "" || strings.Count(r.Name, ".") < strings.Count(zone, ".") {
+			zone = strings.ToLower(r.Name)
+		}
+	}
+	return zone
+}
+
+// authoritativeServers looks up the name servers of zone through resolver.
+func authoritativeServers(resolver, zone string, timeout time.Duration) ([]string, error) {
+	client := NewDNSClient([]string{resolver}, timeout)
+	record, err := client.QueryServer(resolver, zone, "NS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find name servers of %s: %v", zone, err)
+	}
+	servers := make([]string, len(record.Values))
+	for i, ns := range record.Values {
+		servers[i] = ns + ":53"
+	}
+	return servers, nil
+}
+
+// RunDrift queries every target on every server and compares the answers
+// with the expected values.
+func RunDrift(targets []Target, servers []string, timeout time.Duration) []DriftResult {
+	client := NewDNSClient(servers, timeout)
+	var results []DriftResult
+	for _, target := range targets {
+		for _, server := range servers {
+			result := DriftResult{Name: target.Domain, Type: target.Type, Server: server, Expected: target.Expected}
+			record, err := client.QueryServer(server, target.Domain, target.Type)
+			if err != nil {
+				if code := errorCode(err); code != "NXDOMAIN" && code != "NODATA" {
+					result.Status = DriftError
+					result.Error = err.Error()
+					results = append(results, result)
+					continue
+				}
+				record = &DNSRecord{}
+			}
+			result.Actual = record.Values
+			result.Missing, result.Extra = diffDriftValues(target.Type, target.Expected, record.Values)
+			switch {
+			case len(result.Missing) > 0 && len(result.Extra) > 0:
+				result.Status = DriftMismatch
+			case len(result.Missing) > 0:
+				result.Status = DriftMissing
+			case len(result.Extra) > 0:
+				result.Status = DriftExtra
+			default:
+				result.Status = DriftInSync
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// driftTypes are the record types whose answers are presented the way zone
+// files write them. Records of other types cannot be compared and are not
+// checked.
+var driftTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "NS": true, "PTR": true, "MX": true,
+	"TXT": true, "SOA": true, "DS": true, "DNSKEY": true,
+}
+
+// diffDriftValues returns the expected values not served and the served
+// values not expected. Names are compared case-insensitively and without
+// trailing dots; TXT data and DNSKEY keys must match exactly. The digest of
+// a DS record and the key of a DNSKEY record may be split into several
+// fields.
+func diffDriftValues(recordType string, expected, actual []string) (missing, extra []string) {
+	normalize := func(v string) string {
+		fields := strings.Fields(v)
+		switch recordType {
+		case "TXT":
+			return v
+		case "DS", "DNSKEY":
+			if len(fields) < 4 {
+				return v
+			}
+			data := strings.Join(fields[3:], "")
+			if recordType == "DS" {
+				data = strings.ToUpper(data)
+			}
+			return strings.Join(append(fields[:3], data), " ")
+		}
+		for i, f := range fields {
+			fields[i] = strings.ToLower(strings.TrimSuffix(f, "."))
+		}
+		return strings.Join(fields, " ")
+	}
+	have := make(map[string]bool)
+	for _, v := range actual {
+		have[normalize(v)] = true
+	}
+	want := make(map[string]bool)
+	for _, v := range expected {
+		want[normalize(v)] = true
+		if !have[normalize(v)] {
+			missing = append(missing, v)
+		}
+	}
+	for _, v := range actual {
+		if !want[normalize(v)] {
+			extra = append(extra, v)
+		}
+	}
+	return missing, extra
+}
+
+// driftExitCode returns 1 if results contain drift, else 2 if a lookup
+// failed, else 0.
+func driftExitCode(results []DriftResult) int {
+	code := 0
+	for _, r := range results {
+		switch r.Status {
+		case DriftMissing, DriftExtra, DriftMismatch:
+			return 1
+		case DriftError:
+			code = 2
+		}
+	}
+	return code
+}
+
+func printDriftReport(zone string, servers []string, results []DriftResult, skipped []string) {
+	counts := make(map[string]int)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		counts[r.Status]++
+		label := fmt.Sprintf("%s\t%s\t@%s", r.Name, r.Type, r.Server)
+		switch r.Status {
+		case DriftMissing:
+			fmt.Fprintf(tw, "MISSING\t%s\tnot served: %s\n", label, strings.Join(r.Missing, ", "))
+		case DriftExtra:
+			fmt.Fprintf(tw, "EXTRA\t%s\tnot in desired state: %s\n", label, strings.Join(r.Extra, ", "))
+		case DriftMismatch:
+			fmt.Fprintf(tw, "MISMATCH\t%s\texpected %s, got %s\n", label, formatValues(r.Expected), formatValues(r.Actual))
+		case DriftError:
+			fmt.Fprintf(tw, "ERROR\t%s\t%s\n", label, r.Error)
+		}
+	}
+	tw.Flush()
+
+	if len(results) > counts[DriftInSync] {
+		fmt.Println()
+	}
+	fmt.Printf("Zone %s on %s\n", zone, strings.Join(servers, ", "))
+	if len(skipped) > 0 {
+		fmt.Printf("Not checked: %s records\n", strings.Join(skipped, ", "))
+	}
+	fmt.Printf("%d in sync, %d missing, %d extra, %d mismatched, %d errors\n",
+		counts[DriftInSync], counts[DriftMissing], counts[DriftExtra], counts[DriftMismatch], counts[DriftError])
+}
+
+func runDriftCommand(args []string) int {
+	config, err := ParseDriftArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if config.ShowHelp {
+		printDriftUsage()
+		return 0
+	}
+
+	origin := config.Origin
+	if origin == "" {
+		origin = config.Zone
+	}
+	var records []zoneRecord
+	for _, source := range config.Sources {
+		r, err := readDesiredState(source, origin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		records = append(records, r...)
+	}
+	targets, skipped := zoneTargets(records, func(t string) bool { return driftTypes[t] })
+	for _, t := range targets {
+		sort.Strings(t.Expected)
+	}
+
+	zone := config.Zone
+	if zone == "" {
+		zone = driftZone(records)
+	}
+	servers := config.Servers
+	if len(servers) == 0 {
+		if servers, err = authoritativeServers(config.Resolver, zone, config.Timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+
+	results := RunDrift(targets, servers, config.Timeout)
+
+	if config.JSON {
+		b, err := json.Marshal(struct {
+			Zone    string        `json:"zone"`
+			Servers []string      `json:"servers"`
+			Skipped []string      `json:"skipped_types,omitempty"`
+			Drift   bool          `json:"drift"`
+			Results []DriftResult `json:"results"`
+		}{zone, servers, skipped, driftExitCode(results) == 1, results})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		fmt.Println(string(b))
+	} else {
+		printDriftReport(zone, servers, results, skipped)
+	}
+	return driftExitCode(results)
+}
+
+func printDriftUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor drift [OPTIONS] SOURCE...
+
+Compares the desired state of a zone with what its authoritative servers
+answer, and reports missing records, extra values and mismatched values.
+SOURCE is a BIND zone file or a JSON export (an array of records, or a
+Cloudflare or Route 53 export).
+
+OPTIONS:
+    -s, --server SERVER     Authoritative server to check (multiple allowed)
+                            [default: the zone's NS records]
+    --zone NAME             Zone whose NS records are used [default: SOA owner]
+    --origin NAME           Origin of zone files without $ORIGIN [default: --zone]
+    --resolver SERVER       Resolver used to look up the NS records [default: 8.8.8.8]
+    --timeout DURATION      Timeout per query [default: 3s]
+    --json                  Print the report as JSON
+    -h, --help              Display help
+
+EXIT STATUS:
+    0  Every record set is in sync
+    1  Drift found
+    2  No drift, but lookups failed, or the command could not run
+
+EXAMPLES:
+    dns-monitor drift zones/db.example.com
+    dns-monitor drift --json -s ns1.example.com cloudflare-export.json
+`)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecordExport(t *testing.T) {
+	tests := []struct {
+		name   string
+		export string
+	}{
+		{"plain", `[
+			{"name": "example.com.", "type": "A", "ttl": 300, "values": ["192.0.2.1", "192.0.2.2"]},
+			{"name": "example.com", "type": "MX", "value": "10 mail.example.com."},
+			{"name": "example.com", "type": "TXT", "value": "v=spf1 mx -all"}
+		]`},
+		{"cloudflare", `{"result": [
+			{"name": "example.com", "type": "A", "ttl": 300, "content": "192.0.2.1"},
+			{"name": "example.com", "type": "A", "ttl": 300, "content": "192.0.2.2"},
+			{"name": "example.com", "type": "MX", "content": "mail.example.com", "priority": 10},
+			{"name": "example.com", "type": "TXT", "content": "\"v=spf1 mx -all\""}
+		]}`},
+		{"route53", `{"ResourceRecordSets": [
+			{"Name": "example.com.", "Type": "A", "TTL": 300, "ResourceRecords": [{"Value": "192.0.2.1"}, {"Value": "192.0.2.2"}]},
+			{"Name": "example.com.", "Type": "MX", "ResourceRecords": [{"Value": "10 mail.example.com."}]},
+			{"Name": "example.com.", "Type": "TXT", "ResourceRecords": [{"Value": "\"v=spf1 mx -all\""}]}
+		]}`},
+	}
+
+	for _, tt := range tests {
+		records, err := parseRecordExport([]byte(tt.export))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.Name+" "+r.Type+" "+r.Value)
+		}
+		want := "example.com A 192.0.2.1|example.com A 192.0.2.2|example.com MX 10 mail.example.com|example.com TXT v=spf1 mx -all"
+		if strings.Join(got, "|") != want {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, strings.Join(got, "|"))
+		}
+	}
+
+	for _, bad := range []string{`{"result": 1}`, `[{"name": "example.com"}]`, `[{"name": "example.com", "type": "A", "value": "nope"}]`} {
+		if _, err := parseRecordExport([]byte(bad)); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestParseDriftArgs(t *testing.T) {
+	config, err := ParseDriftArgs([]string{"drift", "-s", "192.0.2.53", "--zone", "Example.COM.", "--origin", "Example.ORG.", "--timeout", "1s", "--json", "db.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Servers[0] != "192.0.2.53:53" || config.Zone != "example.com" || config.Origin != "example.org" || config.Timeout != time.Second || !config.JSON || config.Sources[0] != "db.example.com" {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	for _, args := range [][]string{{"drift"}, {"drift", "--zone"}, {"drift", "--origin"}, {"drift", "--bogus", "db.example.com"}} {
+		if _, err := ParseDriftArgs(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestRunDrift(t *testing.T) {
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		name := strings.TrimSuffix(q.Questions[0].Name, ".")
+		switch {
+		case name == "example.com" && q.Questions[0].Type == TypeA:
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeA, []byte{192, 0, 2, 1}),
+				mustRR(t, name, TypeA, []byte{192, 0, 2, 2}),
+			}}
+		case name == "www.example.com":
+			return &dnsMessage{Answers: []dnsRR{mustRR(t, name, TypeCNAME, nameData(t, nil, "Old.Example.NET."))}}
+		case name == "mail.example.com":
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeA, []byte{192, 0, 2, 25}),
+				mustRR(t, name, TypeA, []byte{192, 0, 2, 26}),
+			}}
+		case name == "example.com" && q.Questions[0].Type == TypeNS:
+			return &dnsMessage{Answers: []dnsRR{
+				mustRR(t, name, TypeNS, nameData(t, nil, "ns1.example.com.")),
+				mustRR(t, name, TypeNS, nameData(t, nil, "ns3.example.net.")),
+			}}
+		case name == "example.com" && q.Questions[0].Type == TypeSOA:
+			soa := nameData(t, nil, "ns1.example.com.", "hostmaster.example.com.")
+			soa = binary.BigEndian.AppendUint32(soa, 2024050101)
+			for _, v := range []uint32{10800, 900, 604800, 86400} {
+				soa = binary.BigEndian.AppendUint32(soa, v)
+			}
+			return &dnsMessage{Answers: []dnsRR{mustRR(t, name, TypeSOA, soa)}}
+		case name == "broken.example.com":
+			return &dnsMessage{Rcode: RcodeServFail}
+		default:
+			return &dnsMessage{Rcode: RcodeNXDomain}
+		}
+	})
+
+	zone := `$ORIGIN example.com.
+@      SOA   ns1 hostmaster 2024050101 3h 15m 1w 1d
+@      NS    ns1
+@      NS    ns2.example.net.
+_sip._tcp SRV 10 5 5060 sip
+@      A     192.0.2.1
+@      A     192.0.2.2
+www    CNAME new.example.net.
+mail   A     192.0.2.25
+api    A     192.0.2.7
+broken A     192.0.2.9
+`
+	records, err := parseZone(strings.NewReader(zone), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets, skipped := zoneTargets(records, func(t string) bool { return driftTypes[t] })
+	if strings.Join(skipped, ",") != "SRV" {
+		t.Errorf("expected only SRV to be skipped, got %v", skipped)
+	}
+	results := RunDrift(targets, []string{server}, 2*time.Second)
+
+	status := make(map[string]DriftResult)
+	for _, r := range results {
+		status[r.Name+" "+r.Type] = r
+	}
+	expected := map[string]string{
+		"example.com A":         DriftInSync,
+		"example.com SOA":       DriftInSync,
+		"example.com NS":        DriftMismatch,
+		"www.example.com CNAME": DriftMismatch,
+		"mail.example.com A":    DriftExtra,
+		"api.example.com A":     DriftMissing,
+		"broken.example.com A":  DriftError,
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %+v", len(expected), results)
+	}
+	for name, want := range expected {
+		if got := status[name].Status; got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+	if extra := status["mail.example.com A"].Extra; len(extra) != 1 || extra[0] != "192.0.2.26" {
+		t.Errorf("expected 192.0.2.26 to be extra, got %v", extra)
+	}
+	if missing := status["api.example.com A"].Missing; len(missing) != 1 || missing[0] != "192.0.2.7" {
+		t.Errorf("expected 192.0.2.7 to be missing, got %v", missing)
+	}
+	if r := status["example.com NS"]; len(r.Missing) != 1 || r.Missing[0] != "ns2.example.net" || len(r.Extra) != 1 || r.Extra[0] != "ns3.example.net" {
+		t.Errorf("expected ns2.example.net missing and ns3.example.net extra, got %v, %v", r.Missing, r.Extra)
+	}
+
+	if code := driftExitCode(results); code != 1 {
+		t.Errorf("expected exit code 1 for drift, got %d", code)
+	}
+	if code := driftExitCode([]DriftResult{{Status: DriftInSync}, {Status: DriftError}}); code != 2 {
+		t.Errorf("expected exit code 2 for errors only, got %d", code)
+	}
+	if code := driftExitCode([]DriftResult{{Status: DriftInSync}}); code != 0 {
+		t.Errorf("expected exit code 0 when in sync, got %d", code)
+	}
+}
+
+func TestDiffDriftValues(t *testing.T) {
+	missing, extra := diffDriftValues("MX", []string{"10 Mail.example.com."}, []string{"10 mail.example.com"})
+	if len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("expected names to compare case-insensitively, got %v, %v", missing, extra)
+	}
+	missing, extra = diffDriftValues("DS", []string{"12345 8 2 49fd46e6c4b45c55 d4ac69cbd3cd3440"}, []string{"12345 8 2 49FD46E6C4B45C55D4AC69CBD3CD3440"})
+	if len(missing) != 0 || len(extra) != 0 {
+		t.Errorf("expected split DS digests to compare equal, got %v, %v", missing, extra)
+	}
+	missing, extra = diffDriftValues("TXT", []string{"v=spf1 -all"}, []string{"V=SPF1 -all"})
+	if len(missing) != 1 || len(extra) != 1 {
+		t.Errorf("expected TXT data to compare exactly, got %v, %v", missing, extra)
+	}
+}
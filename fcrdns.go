@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reverseName returns the name holding the PTR record of ip, under
+// in-addr.arpa for IPv4 and ip6.arpa for IPv6 addresses.
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	ip = ip.To16()
+	labels := make([]string, 0, 2*len(ip)+1)
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// FCrDNSResult is the outcome of a forward-confirmed reverse DNS check of
+// an address: the names its PTR records point to, and those of them whose
+// A or AAAA records contain the address again.
+type FCrDNSResult struct {
+	Address   string
+	Names     []string
+	Confirmed []string
+}
+
+// Pass reports whether at least one PTR name resolves back to the address.
+func (r *FCrDNSResult) Pass() bool {
+	return len(r.Confirmed) > 0
+}
+
+// Verdict describes the result for the given owner names of the address,
+// noting when the confirmed name is none of them.
+func (r *FCrDNSResult) Verdict(owners []string) string {
+	switch {
+	case len(r.Names) == 0:
+		return "FCrDNS FAIL: no PTR record"
+	case !r.Pass():
+		return fmt.Sprintf("FCrDNS FAIL: %s does not resolve to %s", strings.Join(r.Names, ", "), r.Address)
+	}
+	verdict := "FCrDNS pass: " + strings.Join(r.Confirmed, ", ")
+	for _, name := range r.Confirmed {
+		for _, owner := range owners {
+			if strings.EqualFold(name, owner) {
+				return verdict
+			}
+		}
+	}
+	return verdict + fmt.Sprintf(" (PTR differs from %s)", strings.Join(owners, ", "))
+}
+
+// CheckFCrDNS looks up the PTR records of address and the A or AAAA records
+// of every name they point to. A missing PTR record or forward name is a
+// failed check rather than an error; other lookup failures are returned.
+func (c *DNSClient) CheckFCrDNS(address string) (*FCrDNSResult, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", address)
+	}
+	result := &FCrDNSResult{Address: address}
+
+	record, err := c.Query(reverseName(ip), "PTR")
+	if err != nil {
+		if code := errorCode(err); code == "NXDOMAIN" || code == "NODATA" {
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Names = append([]string(nil), record.Values...)
+	sort.Strings(result.Names)
+
+	forwardType := "AAAA"
+	if ip.To4() != nil {
+		forwardType = "A"
+	}
+	for _, name := range result.Names {
+		forward, err := c.Query(name, forwardType)
+		if err != nil {
+			if code := errorCode(err); code == "NXDOMAIN" || code == "NODATA" {
+				continue
+			}
+			return nil, fmt.Errorf("forward lookup of %s: %v", name, err)
+		}
+		for _, value := range forward.Values {
+			if ip.Equal(net.ParseIP(value)) {
+				result.Confirmed = append(result.Confirmed, name)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// observedAddresses returns the addresses last seen for the A and AAAA
+// targets, on any server, with the domains each of them belongs to.
+func (m *Monitor) observedAddresses(targets []Target) ([]string, map[string][]string) {
+	owners := make(map[string][]string)
+	var addresses []string
+	for _, target := range targets {
+		if target.Type != "A" && target.Type != "AAAA" {
+			continue
+		}
+		key := target.Key()
+		for k, record := range m.lastRecords {
+			if k != key && !strings.HasPrefix(k, key+"@") {
+				continue
+			}
+			for _, address := range record.Values {
+				if _, seen := owners[address]; !seen {
+					addresses = append(addresses, address)
+				}
+				if !containsString(owners[address], target.Domain) {
+					owners[address] = append(owners[address], target.Domain)
+				}
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, owners
+}
+
+// checkFCrDNS runs the reverse DNS check for every address observed for
+// targets and reports whether the PTR records of any of them changed, or
+// whether a check started or stopped passing. Results of addresses that are
+// no longer observed are forgotten.
+func (m *Monitor) checkFCrDNS(timestamp string, targets []Target) bool {
+	if m.fcrdns == nil {
+		m.fcrdns = make(map[string]*FCrDNSResult)
+	}
+	addresses, owners := m.observedAddresses(targets)
+	for address := range m.fcrdns {
+		if _, observed := owners[address]; !observed {
+			delete(m.fcrdns, address)
+		}
+	}
+
+	hasChanges := false
+	for _, address := range addresses {
+		if m.checkAddress(timestamp, address, owners[address]) {
+			hasChanges = true
+		}
+	}
+	return hasChanges
+}
+
+func (m *Monitor) checkAddress(timestamp, address string, owners []string) bool {
+	label := fmt.Sprintf("%s (PTR) for %s", address, strings.Join(owners, ", "))
+	obs := Observation{Time: time.Now(), Domain: address, Type: "PTR"}
+	result, err := m.dnsClient.CheckFCrDNS(address)
+	if err != nil {
+		obs.Status = StatusError
+		obs.Error = err.Error()
+		obs.Rcode = errorCode(err)
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s - ERROR: %v", timestamp, label, err)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+	}
+
+	last, exists := m.fcrdns[address]
+	m.fcrdns[address] = result
+	record := &DNSRecord{Domain: address, Type: "PTR", Values: result.Names}
+	var lastRecord *DNSRecord
+	if exists {
+		lastRecord = &DNSRecord{Domain: address, Type: "PTR", Values: last.Names}
+	}
+	obs.Values = record.Values
+	obs.Message = result.Verdict(owners)
+	verdictColor := ColorGreen
+	if !result.Pass() {
+		verdictColor = ColorYellow
+	}
+
+	namesChanged := exists && !record.Equals(lastRecord)
+	if namesChanged || exists && last.Pass() != result.Pass() {
+		obs.Status = StatusChanged
+		obs.Previous = lastRecord.Values
+		m.emit(obs)
+
+		message := fmt.Sprintf("[%s] %s - CHANGE DETECTED:", timestamp, label)
+		m.printColored(message, ColorRed)
+		m.logger.Println(message)
+
+		if namesChanged {
+			beforeMsg := fmt.Sprintf("  Before: %s", lastRecord.String())
+			afterMsg := fmt.Sprintf("  After:  %s", record.String())
+			m.printColored(beforeMsg, ColorRed)
+			m.printColored(afterMsg, ColorBlue)
+			m.logger.Println(beforeMsg)
+			m.logger.Println(afterMsg)
+		}
+		verdictMsg := "  " + obs.Message
+		m.printColored(verdictMsg, verdictColor)
+		m.logger.Println(verdictMsg)
+		return true
+	}
+
+	// A check that keeps failing is a warning rather than an error: the
+	// lookups worked, and the transition into failing was a change.
+	switch {
+	case !result.Pass():
+		obs.Status = StatusWarning
+	case !exists:
+		obs.Status = StatusInitial
+	default:
+		obs.Status = StatusUnchanged
+	}
+	m.emit(obs)
+	message := fmt.Sprintf("[%s] %s - %s", timestamp, label, obs.Message)
+	m.printColored(message, verdictColor)
+	m.logger.Println(message)
+	return false
+}
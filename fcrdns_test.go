@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.25":  "25.2.0.192.in-addr.arpa",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+	for address, want := range tests {
+		if got := reverseName(net.ParseIP(address)); got != want {
+			t.Errorf("reverseName(%s) = %s, want %s", address, got, want)
+		}
+	}
+}
+
+// reverseZone answers PTR and A queries from maps that tests can change.
+type reverseZone struct {
+	mu      sync.Mutex
+	ptr     map[string][]string
+	forward map[string][]byte
+}
+
+func (z *reverseZone) set(ptr map[string][]string, forward map[string][]byte) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.ptr, z.forward = ptr, forward
+}
+
+func (z *reverseZone) handler(t *testing.T) func(q *dnsMessage) *dnsMessage {
+	return func(q *dnsMessage) *dnsMessage {
+		z.mu.Lock()
+		defer z.mu.Unlock()
+		question := q.Questions[0]
+		name := strings.TrimSuffix(canonicalName(question.Name), ".")
+		switch question.Type {
+		case TypePTR:
+			names, ok := z.ptr[name]
+			if !ok {
+				return &dnsMessage{Rcode: RcodeNXDomain}
+			}
+			resp := &dnsMessage{}
+			for _, target := range names {
+				resp.Answers = append(resp.Answers, mustRR(t, question.Name, TypePTR, nameData(t, nil, target)))
+			}
+			return resp
+		case TypeA:
+			if ip, ok := z.forward[name]; ok {
+				return &dnsMessage{Answers: []dnsRR{mustRR(t, question.Name, TypeA, ip)}}
+			}
+		}
+		return &dnsMessage{}
+	}
+}
+
+func TestDNSClient_CheckFCrDNS(t *testing.T) {
+	zone := &reverseZone{}
+	zone.set(map[string][]string{
+		"25.2.0.192.in-addr.arpa": {"mail.example.com"},
+		"26.2.0.192.in-addr.arpa": {"host26.example.net"},
+	}, map[string][]byte{
+		"mail.example.com": {192, 0, 2, 25},
+	})
+	client := NewDNSClient([]string{startStubServer(t, zone.handler(t))}, 2*time.Second)
+
+	result, err := client.CheckFCrDNS("192.0.2.25")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Pass() || result.Verdict([]string{"mail.example.com"}) != "FCrDNS pass: mail.example.com" {
+		t.Errorf("expected pass, got %+v", result)
+	}
+	if got := result.Verdict([]string{"mx.example.com"}); got != "FCrDNS pass: mail.example.com (PTR differs from mx.example.com)" {
+		t.Errorf("unexpected verdict for another name: %s", got)
+	}
+
+	result, err = client.CheckFCrDNS("192.0.2.26")
+	if err != nil || result.Pass() {
+		t.Fatalf("expected PTR without forward record to fail, got %+v, %v", result, err)
+	}
+	if got := result.Verdict(nil); got != "FCrDNS FAIL: host26.example.net does not resolve to 192.0.2.26" {
+		t.Errorf("unexpected verdict: %s", got)
+	}
+
+	result, err = client.CheckFCrDNS("192.0.2.27")
+	if err != nil || result.Pass() || result.Verdict(nil) != "FCrDNS FAIL: no PTR record" {
+		t.Errorf("expected missing PTR record to fail, got %+v, %v", result, err)
+	}
+
+	if _, err := client.CheckFCrDNS("mail.example.com"); err == nil {
+		t.Error("expected error for a value that is not an address")
+	}
+}
+
+func TestMonitor_checkFCrDNS(t *testing.T) {
+	zone := &reverseZone{}
+	zone.set(map[string][]string{
+		"25.2.0.192.in-addr.arpa": {"mail.example.com"},
+	}, map[string][]byte{
+		"mail.example.com": {192, 0, 2, 25},
+	})
+	monitor, buf := newTestMonitor(t, &Config{NoColor: true, FCrDNS: true}, startStubServer(t, zone.handler(t)))
+	monitor.lastRecords = map[string]*DNSRecord{
+		"mail.example.com:A@10.0.0.1:53": {Domain: "mail.example.com", Type: "A", Values: []string{"192.0.2.25"}},
+		"mail.example.com:MX":            {Domain: "mail.example.com", Type: "MX", Values: []string{"10 mx.example.com"}},
+	}
+	targets := []Target{{Domain: "mail.example.com", Type: "A"}, {Domain: "mail.example.com", Type: "MX"}}
+
+	if monitor.checkFCrDNS("ts", targets) {
+		t.Error("initial check should not be reported as a change")
+	}
+	zone.set(map[string][]string{
+		"25.2.0.192.in-addr.arpa": {"host25.example.net"},
+	}, nil)
+	if !monitor.checkFCrDNS("ts", targets) {
+		t.Error("expected changed PTR record to be reported as a change")
+	}
+	if monitor.checkFCrDNS("ts", targets) {
+		t.Error("unchanged PTR record should not be reported as a change")
+	}
+	if len(monitor.failures) != 0 {
+		t.Errorf("expected a failing check to be a warning, not a failure, got %v", monitor.failures)
+	}
+	zone.set(map[string][]string{
+		"25.2.0.192.in-addr.arpa": {"host25.example.net"},
+	}, map[string][]byte{
+		"host25.example.net": {192, 0, 2, 25},
+	})
+	if !monitor.checkFCrDNS("ts", targets) {
+		t.Error("expected a check that passes again to be reported as a change")
+	}
+	if _, saved := monitor.lastRecords["192.0.2.25:PTR"]; saved {
+		t.Error("expected FCrDNS results to be kept out of the last records")
+	}
+
+	delete(monitor.lastRecords, "mail.example.com:A@10.0.0.1:53")
+	monitor.checkFCrDNS("ts", targets)
+	if len(monitor.fcrdns) != 0 {
+		t.Errorf("expected addresses no longer observed to be forgotten, got %v", monitor.fcrdns)
+	}
+
+	for _, want := range []string{
+		"192.0.2.25 (PTR) for mail.example.com - FCrDNS pass: mail.example.com",
+		"192.0.2.25 (PTR) for mail.example.com - CHANGE DETECTED:",
+		"  After:  [host25.example.net]",
+		"  FCrDNS FAIL: host25.example.net does not resolve to 192.0.2.25",
+		"192.0.2.25 (PTR) for mail.example.com - FCrDNS FAIL: host25.example.net does not resolve to 192.0.2.25",
+		"  FCrDNS pass: host25.example.net (PTR differs from mail.example.com)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in log, got %q", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "10 mx.example.com") {
+		t.Errorf("expected only A and AAAA values to be checked, got %q", buf.String())
+	}
+}
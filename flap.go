@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// changeTracker holds, per record, the unconfirmed value waiting for
+// --confirm and the recent accepted changes used for flap detection. While
+// flapping, reported is the value the flapping event carried.
+type changeTracker struct {
+	candidate *DNSRecord
+	seen      int
+	changes   []time.Time
+	flapping  bool
+	reported  *DNSRecord
+}
+
+func (m *Monitor) tracker(key string) *changeTracker {
+	if m.trackers == nil {
+		m.trackers = make(map[string]*changeTracker)
+	}
+	t, ok := m.trackers[key]
+	if !ok {
+		t = &changeTracker{}
+		m.trackers[key] = t
+	}
+	return t
+}
+
+// classifyChange decides how a record differing from the last accepted one
+// is reported: pending until it has been seen --confirm times in a row, then
+// changed, unless the record changes so often that it is flapping. It
+// returns the status and a message explaining it.
+func (m *Monitor) classifyChange(key string, record *DNSRecord, now time.Time) (string, string) {
+	t := m.tracker(key)
+
+	if m.config.Confirm > 1 {
+		if t.candidate == nil || !record.Equals(t.candidate) {
+			t.candidate = record
+			t.seen = 0
+		}
+		t.seen++
+		if t.seen < m.config.Confirm {
+			return StatusPending, fmt.Sprintf("seen %d/%d times", t.seen, m.config.Confirm)
+		}
+	}
+	t.candidate = nil
+	t.seen = 0
+
+	if m.config.FlapThreshold == 0 {
+		return StatusChanged, ""
+	}
+
+	t.changes = append(t.changes, now)
+	for len(t.changes) > 0 && now.Sub(t.changes[0]) > m.config.FlapWindow {
+		t.changes = t.changes[1:]
+	}
+	if t.flapping {
+		return StatusSuppressed, "flapping"
+	}
+	if len(t.changes) > m.config.FlapThreshold {
+		t.flapping = true
+		t.reported = record
+		return StatusFlapping, fmt.Sprintf("changed %d times within %s, suppressing changes", len(t.changes), m.config.FlapWindow)
+	}
+	return StatusChanged, ""
+}
+
+// settle is called when a record matches the last accepted one. It drops any
+// unconfirmed value and ends flapping once the record has not changed for a
+// whole flap window. When it does, it returns a message and the value last
+// reported, so a different settled value can be reported as a change.
+func (m *Monitor) settle(key string, now time.Time) (string, *DNSRecord) {
+	t, ok := m.trackers[key]
+	if !ok {
+		return "", nil
+	}
+	t.candidate = nil
+	t.seen = 0
+
+	if t.flapping && len(t.changes) > 0 && now.Sub(t.changes[len(t.changes)-1]) > m.config.FlapWindow {
+		reported := t.reported
+		t.flapping = false
+		t.changes = nil
+		t.reported = nil
+		return fmt.Sprintf("stable for %s, no longer flapping", m.config.FlapWindow), reported
+	}
+	return "", nil
+}
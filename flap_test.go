@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor_confirm(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+
+	monitor, buf := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true, Confirm: 3}, server)
+
+	steps := []struct {
+		answer byte
+		status string
+	}{
+		{1, StatusInitial},
+		{2, StatusPending},
+		{1, StatusUnchanged}, // the blip is forgotten
+		{2, StatusPending},
+		{2, StatusPending},
+		{2, StatusChanged},
+		{2, StatusUnchanged},
+	}
+	for i, step := range steps {
+		answer.set([]byte{192, 0, 2, step.answer})
+		obs := monitor.observe(monitor.config.targets()[0], "")
+		if obs.Status != step.status {
+			t.Errorf("step %d: expected %s, got %s", i, step.status, obs.Status)
+		}
+		if obs.Status == StatusChanged && (obs.Previous[0] != "192.0.2.1" || obs.Values[0] != "192.0.2.2") {
+			t.Errorf("unexpected confirmed change: %v -> %v", obs.Previous, obs.Values)
+		}
+	}
+
+	answer.set([]byte{192, 0, 2, 3})
+	monitor.checkDomains()
+	if !strings.Contains(buf.String(), "Unconfirmed: [192.0.2.3], seen 1/3 times (current: [192.0.2.2]") {
+		t.Errorf("expected unconfirmed value in log, got %q", buf.String())
+	}
+}
+
+func TestMonitor_flapDetection(t *testing.T) {
+	monitor, _ := newTestMonitor(t, &Config{RecordType: "A", Confirm: 1, FlapThreshold: 2, FlapWindow: 10 * time.Minute}, "")
+	record := func(v string) *DNSRecord {
+		return &DNSRecord{Domain: "example.com", Type: "A", Values: []string{v}}
+	}
+	start := time.Now()
+
+	statuses := []string{}
+	for i := 0; i < 5; i++ {
+		status, _ := monitor.classifyChange("example.com:A", record([]string{"a", "b"}[i%2]), start.Add(time.Duration(i)*time.Minute))
+		statuses = append(statuses, status)
+	}
+	expected := []string{StatusChanged, StatusChanged, StatusFlapping, StatusSuppressed, StatusSuppressed}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("change %d: expected %s, got %s", i, expected[i], statuses[i])
+		}
+	}
+
+	if msg, _ := monitor.settle("example.com:A", start.Add(10*time.Minute)); msg != "" {
+		t.Errorf("flapping should not end within the window, got %q", msg)
+	}
+	if msg, _ := monitor.settle("example.com:A", start.Add(20*time.Minute)); !strings.Contains(msg, "no longer flapping") {
+		t.Errorf("expected flapping to end after a quiet window, got %q", msg)
+	}
+	if status, _ := monitor.classifyChange("example.com:A", record("c"), start.Add(21*time.Minute)); status != StatusChanged {
+		t.Errorf("expected changes to be reported again, got %s", status)
+	}
+}
+
+func TestMonitor_flapSettles(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true, FlapThreshold: 1, FlapWindow: 50 * time.Millisecond}, server)
+	target := monitor.config.targets()[0]
+
+	var statuses []string
+	for _, last := range []byte{1, 2, 1, 3} {
+		answer.set([]byte{192, 0, 2, last})
+		statuses = append(statuses, monitor.observe(target, "").Status)
+	}
+	if want := "initial changed flapping suppressed"; strings.Join(statuses, " ") != want {
+		t.Fatalf("expected %s, got %v", want, statuses)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	obs := monitor.observe(target, "")
+	if obs.Status != StatusChanged || obs.Previous[0] != "192.0.2.1" || obs.Values[0] != "192.0.2.3" || !strings.Contains(obs.Message, "no longer flapping") {
+		t.Errorf("expected the settled value to be reported as a change from the last notified one, got %+v", obs)
+	}
+	if event, ok := eventFromObservation(obs); !ok || event.Kind != EventChange || event.After[0] != "192.0.2.3" {
+		t.Errorf("expected a change event with the settled value, got %+v", event)
+	}
+
+	// Settling on the value notifiers last saw is not a change.
+	for _, last := range []byte{4, 3, 4, 3} {
+		answer.set([]byte{192, 0, 2, last})
+		monitor.observe(target, "")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if obs := monitor.observe(target, ""); obs.Status != StatusUnchanged || !strings.Contains(obs.Message, "no longer flapping") {
+		t.Errorf("expected flapping to end without a change, got %+v", obs)
+	}
+}
+
+func TestEventFromObservation_Flapping(t *testing.T) {
+	event, ok := eventFromObservation(Observation{Domain: "example.com", Type: "A", Status: StatusFlapping, Values: []string{"192.0.2.2"}, Message: "changed 3 times within 10m0s, suppressing changes"})
+	if !ok || event.Kind != EventFlapping || event.Message == "" {
+		t.Errorf("expected flapping event, got %+v", event)
+	}
+	if _, ok := eventFromObservation(Observation{Status: StatusSuppressed}); ok {
+		t.Error("suppressed changes should not produce events")
+	}
+	if _, ok := eventFromObservation(Observation{Status: StatusPending}); ok {
+		t.Error("unconfirmed changes should not produce events")
+	}
+}
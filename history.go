@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryEntry records the first answer seen for a domain, type and server,
+// or a change of that answer.
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Domain string    `json:"domain"`
+	Type   string    `json:"type"`
+	Server string    `json:"server,omitempty"`
+	Status string    `json:"status"`
+	Before []string  `json:"before,omitempty"`
+	After  []string  `json:"after"`
+}
+
+func (e HistoryEntry) key() string {
+	return e.Domain + ":" + e.Type + "@" + e.Server
+}
+
+// HistoryStore appends entries to a JSON lines file. It remembers the latest
+// values recorded for every domain, type and server, including those written
+// before a restart, so an initial answer is only recorded when it differs.
+type HistoryStore struct {
+	mu     sync.Mutex
+	file   *os.File
+	latest map[string][]string
+}
+
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	entries, err := readHistory(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %v", err)
+	}
+
+	h := &HistoryStore{file: file, latest: make(map[string][]string)}
+	for _, period := range historyPeriods(entries, time.Now()) {
+		h.latest[period.key()] = period.After
+	}
+	return h, nil
+}
+
+// Record appends entry. An initial answer equal to the latest recorded
+// values is skipped, and one that differs from them is recorded as a change.
+func (h *HistoryStore) Record(entry HistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if latest, ok := h.latest[entry.key()]; ok && entry.Status == StatusInitial {
+		if sameValues(latest, entry.After) {
+			return nil
+		}
+		entry.Status = StatusChanged
+		entry.Before = latest
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := h.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	h.latest[entry.key()] = entry.After
+	return nil
+}
+
+func (h *HistoryStore) Close() error {
+	return h.file.Close()
+}
+
+// readHistory returns the entries stored at path in file order. Lines that
+// cannot be parsed, such as one cut short by a crash, are skipped.
+func readHistory(path string) ([]HistoryEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// historyPeriod is an entry together with how long its values stayed live:
+// until the next entry for the same domain, type and server, or until now
+// when they are still current.
+type historyPeriod struct {
+	HistoryEntry
+	Until   time.Time
+	Current bool
+}
+
+func (p historyPeriod) Live() time.Duration {
+	return p.Until.Sub(p.Time)
+}
+
+func (p historyPeriod) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		HistoryEntry
+		Until       time.Time `json:"until"`
+		LiveSeconds float64   `json:"live_seconds"`
+		Current     bool      `json:"current"`
+	}{p.HistoryEntry, p.Until, p.Live().Seconds(), p.Current})
+}
+
+// historyPeriods orders entries by time and works out how long each set of
+// values was live.
+func historyPeriods(entries []HistoryEntry, now time.Time) []historyPeriod {
+	sorted := append([]HistoryEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	periods := make([]historyPeriod, len(sorted))
+	last := make(map[string]int)
+	for i, entry := range sorted {
+		periods[i] = historyPeriod{HistoryEntry: entry, Until: now, Current: true}
+		if prev, ok := last[entry.key()]; ok {
+			periods[prev].Until = entry.Time
+			periods[prev].Current = false
+		}
+		last[entry.key()] = i
+	}
+	return periods
+}
+
+type HistoryConfig struct {
+	File       string
+	Domain     string
+	RecordType string
+	Since      time.Duration
+	JSON       bool
+	ShowHelp   bool
+}
+
+func ParseHistoryArgs(args []string) (*HistoryConfig, error) {
+	config := &HistoryConfig{
+		File: os.Getenv("DNS_MONITOR_HISTORY"),
+	}
+
+	i := 1
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "-h" || arg == "--help":
+			config.ShowHelp = true
+			return config, nil
+		case arg == "--history":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.File = args[i+1]
+			i += 2
+		case arg == "-d" || arg == "--domain":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.Domain = strings.ToLower(strings.TrimSuffix(args[i+1], "."))
+			i += 2
+		case arg == "-t" || arg == "--type":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			config.RecordType = strings.ToUpper(args[i+1])
+			i += 2
+		case arg == "--since":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", arg)
+			}
+			duration, err := parseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid since: %v", err)
+			}
+			config.Since = duration
+			i += 2
+		case arg == "--json":
+			config.JSON = true
+			i++
+		default:
+			return nil, fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+
+	if config.File == "" {
+		return nil, fmt.Errorf("no history file given (use --history or DNS_MONITOR_HISTORY)")
+	}
+	return config, nil
+}
+
+// filterHistory returns the periods matching the domain, type and time
+// window of config.
+func filterHistory(config *HistoryConfig, periods []historyPeriod, now time.Time) []historyPeriod {
+	var filtered []historyPeriod
+	for _, p := range periods {
+		if config.Domain != "" && strings.ToLower(strings.TrimSuffix(p.Domain, ".")) != config.Domain {
+			continue
+		}
+		if config.RecordType != "" && p.Type != config.RecordType {
+			continue
+		}
+		if config.Since > 0 && p.Time.Before(now.Add(-config.Since)) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
+}
+
+func printHistory(w io.Writer, periods []historyPeriod) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tDOMAIN\tTYPE\tSERVER\tCHANGE\tLIVE")
+	for _, p := range periods {
+		server := p.Server
+		if server == "" {
+			server = "-"
+		}
+		after := "[" + strings.Join(p.After, ", ") + "]"
+		change := "initial " + after
+		if p.Status == StatusChanged {
+			change = "[" + strings.Join(p.Before, ", ") + "] -> " + after
+		}
+		live := formatRemaining(p.Live())
+		if p.Current {
+			live += " (current)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Time.Format("2006-01-02 15:04:05"), p.Domain, p.Type, server, change, live)
+	}
+	tw.Flush()
+}
+
+func runHistoryCommand(args []string) int {
+	config, err := ParseHistoryArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if config.ShowHelp {
+		printHistoryUsage()
+		return 0
+	}
+
+	entries, err := readHistory(config.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	now := time.Now()
+	periods := filterHistory(config, historyPeriods(entries, now), now)
+
+	if config.JSON {
+		b, err := json.Marshal(struct {
+			History []historyPeriod `json:"history"`
+		}{periods})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(b))
+		return 0
+	}
+
+	if len(periods) == 0 {
+		fmt.Println("No history found")
+		return 0
+	}
+	printHistory(os.Stdout, periods)
+	return 0
+}
+
+func printHistoryUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor history [OPTIONS]
+
+OPTIONS:
+    --history FILE          History file written by the monitor [env: DNS_MONITOR_HISTORY]
+    -d, --domain DOMAIN      Only show DOMAIN
+    -t, --type TYPE          Only show records of TYPE
+    --since DURATION        Only show entries from the last DURATION (e.g. 24h, 7d)
+    --json                  Print entries as JSON
+    -h, --help              Display help
+
+EXAMPLES:
+    dns-monitor history --history history.jsonl --domain api.example.com
+    dns-monitor history --history history.jsonl --since 24h
+`)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	base := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatalf("OpenHistoryStore failed: %v", err)
+	}
+	store.Record(HistoryEntry{Time: base, Domain: "example.com", Type: "A", Status: StatusInitial, After: []string{"192.0.2.1"}})
+	store.Close()
+
+	// Reopening appends rather than truncating.
+	store, _ = OpenHistoryStore(path)
+	store.Record(HistoryEntry{Time: base.Add(time.Hour), Domain: "example.com", Type: "A", Status: StatusChanged, Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2"}})
+	store.Close()
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"time":"2024-01-15T14:00`)
+	f.Close()
+
+	entries, err := readHistory(path)
+	if err != nil {
+		t.Fatalf("readHistory failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Before[0] != "192.0.2.1" || entries[1].After[0] != "192.0.2.2" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestHistoryPeriods(t *testing.T) {
+	base := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	entries := []HistoryEntry{
+		{Time: base, Domain: "api.example.com", Type: "A", Status: StatusInitial, After: []string{"192.0.2.1"}},
+		{Time: base.Add(10 * time.Minute), Domain: "www.example.com", Type: "A", Status: StatusInitial, After: []string{"192.0.2.9"}},
+		{Time: base.Add(3 * time.Hour), Domain: "api.example.com", Type: "A", Status: StatusChanged, Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2"}},
+		{Time: base.Add(time.Hour), Domain: "api.example.com", Type: "A", Server: "1.1.1.1:53", Status: StatusInitial, After: []string{"192.0.2.1"}},
+	}
+	now := base.Add(5 * time.Hour)
+
+	periods := historyPeriods(entries, now)
+	if len(periods) != 4 {
+		t.Fatalf("expected 4 periods, got %d", len(periods))
+	}
+	if periods[0].Domain != "api.example.com" || periods[0].Current || periods[0].Live() != 3*time.Hour {
+		t.Errorf("expected first api value to be live for 3h, got %+v", periods[0])
+	}
+	if periods[2].Server != "1.1.1.1:53" || !periods[2].Current || periods[2].Live() != 4*time.Hour {
+		t.Errorf("servers should be tracked separately, got %+v", periods[2])
+	}
+	if last := periods[3]; !last.Current || last.Live() != 2*time.Hour {
+		t.Errorf("expected current value live for 2h, got %+v", last)
+	}
+
+	config := &HistoryConfig{Domain: "api.example.com", Since: 4 * time.Hour}
+	filtered := filterHistory(config, periods, now)
+	if len(filtered) != 2 || filtered[1].Status != StatusChanged {
+		t.Errorf("unexpected filtered history: %+v", filtered)
+	}
+
+	var buf bytes.Buffer
+	printHistory(&buf, filtered)
+	if !strings.Contains(buf.String(), "[192.0.2.1] -> [192.0.2.2]") || !strings.Contains(buf.String(), "2h0m (current)") {
+		t.Errorf("unexpected history output:\n%s", buf.String())
+	}
+}
+
+func TestParseHistoryArgs(t *testing.T) {
+	config, err := ParseHistoryArgs([]string{"history", "--history", "h.jsonl", "-d", "Example.com.", "-t", "aaaa", "--since", "24h", "--json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.File != "h.jsonl" || config.Domain != "example.com" || config.RecordType != "AAAA" || config.Since != 24*time.Hour || !config.JSON {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	t.Setenv("DNS_MONITOR_HISTORY", "")
+	if _, err := ParseHistoryArgs([]string{"history"}); err == nil {
+		t.Error("expected error without a history file")
+	}
+	if _, err := ParseHistoryArgs([]string{"history", "--history", "h.jsonl", "example.com"}); err == nil {
+		t.Error("expected error for positional argument")
+	}
+}
+
+func TestMonitor_recordHistory(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatalf("OpenHistoryStore failed: %v", err)
+	}
+
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true}, server)
+	monitor.history = store
+
+	monitor.checkDomains()
+	monitor.checkDomains()
+	answer.set([]byte{192, 0, 2, 2})
+	monitor.checkDomains()
+	store.Close()
+
+	entries, err := readHistory(path)
+	if err != nil {
+		t.Fatalf("readHistory failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Status != StatusInitial || entries[1].Status != StatusChanged || entries[1].After[0] != "192.0.2.2" {
+		t.Errorf("expected initial and changed entries, got %+v", entries)
+	}
+
+	// After a restart the initial answer is only recorded when it differs
+	// from the latest one in the file, and then as a change.
+	restart := func() {
+		store, err := OpenHistoryStore(path)
+		if err != nil {
+			t.Fatalf("OpenHistoryStore failed: %v", err)
+		}
+		monitor.lastRecords = make(map[string]*DNSRecord)
+		monitor.history = store
+		monitor.checkDomains()
+		store.Close()
+	}
+	restart()
+	if entries, _ := readHistory(path); len(entries) != 2 {
+		t.Errorf("expected an unchanged answer after restart not to be recorded, got %+v", entries)
+	}
+	answer.set([]byte{192, 0, 2, 3})
+	restart()
+	entries, _ = readHistory(path)
+	if len(entries) != 3 || entries[2].Status != StatusChanged || entries[2].Before[0] != "192.0.2.2" || entries[2].After[0] != "192.0.2.3" {
+		t.Errorf("expected a changed answer after restart to be recorded as a change, got %+v", entries)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHookOutput bounds the command output included in the log.
+const maxHookOutput = 1024
+
+// CommandNotifier runs a shell command for every change, one at a time in
+// the background. Event details are passed in DNSMON_* environment variables
+// and as JSON on standard input.
+type CommandNotifier struct {
+	command string
+	timeout time.Duration
+	logger  *log.Logger
+	queue   chan Event
+	done    sync.WaitGroup
+}
+
+func NewCommandNotifier(command string, timeout time.Duration, logger *log.Logger) *CommandNotifier {
+	n := &CommandNotifier{
+		command: command,
+		timeout: timeout,
+		logger:  logger,
+		queue:   make(chan Event, webhookQueueSize),
+	}
+
+	n.done.Add(1)
+	go func() {
+		defer n.done.Done()
+		for event := range n.queue {
+			n.run(event)
+		}
+	}()
+	return n
+}
+
+func (n *CommandNotifier) Notify(events []Event) {
+	for _, event := range events {
+		if event.Kind != EventChange {
+			continue
+		}
+		select {
+		case n.queue <- event:
+		default:
+			n.logger.Printf("HOOK: queue full, skipping command for %s (%s)", event.Domain, event.Type)
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued commands to finish.
+func (n *CommandNotifier) Close() {
+	close(n.queue)
+	n.done.Wait()
+}
+
+func (n *CommandNotifier) run(event Event) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		n.logger.Printf("HOOK: failed to encode event: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", n.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", n.command)
+	}
+	cmd.Env = append(os.Environ(), hookEnv(event)...)
+	cmd.Stdin = bytes.NewReader(input)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		n.logger.Printf("HOOK: command for %s (%s) exited with status 0 in %s", event.Domain, event.Type, elapsed)
+		return
+	case ctx.Err() == context.DeadlineExceeded:
+		n.logger.Printf("HOOK: command for %s (%s) killed after timeout of %s", event.Domain, event.Type, n.timeout)
+	case errors.As(err, &exitErr):
+		n.logger.Printf("HOOK: command for %s (%s) exited with status %d in %s", event.Domain, event.Type, exitErr.ExitCode(), elapsed)
+	default:
+		n.logger.Printf("HOOK: failed to run command for %s (%s): %v", event.Domain, event.Type, err)
+	}
+
+	out := strings.TrimSpace(output.String())
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput] + "..."
+	}
+	if out != "" {
+		n.logger.Printf("HOOK: output: %s", out)
+	}
+}
+
+// hookEnv returns the DNSMON_* variables describing event. Value lists are
+// comma-separated.
+func hookEnv(event Event) []string {
+	return []string{
+		"DNSMON_DOMAIN=" + event.Domain,
+		"DNSMON_TYPE=" + event.Type,
+		"DNSMON_SERVER=" + event.Server,
+		"DNSMON_BEFORE=" + strings.Join(event.Before, ","),
+		"DNSMON_AFTER=" + strings.Join(event.After, ","),
+		"DNSMON_ADDED=" + strings.Join(event.Added, ","),
+		"DNSMON_REMOVED=" + strings.Join(event.Removed, ","),
+		"DNSMON_TIMESTAMP=" + event.Timestamp.Format(time.RFC3339),
+	}
+}
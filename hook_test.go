@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandNotifier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook test uses a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	var logs bytes.Buffer
+	n := NewCommandNotifier(`printf '%s|%s|%s|%s|%s\n' "$DNSMON_DOMAIN" "$DNSMON_TYPE" "$DNSMON_SERVER" "$DNSMON_BEFORE" "$DNSMON_AFTER" >> `+out+`; cat >> `+out+`; echo; exit 3`,
+		5*time.Second, log.New(&logs, "", 0))
+
+	n.Notify([]Event{
+		{Kind: EventChange, Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2", "192.0.2.3"}},
+		{Kind: EventError, Domain: "example.org", Type: "A", Error: "TIMEOUT"},
+	})
+	n.Close()
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook did not run: %v (log: %s)", err, logs.String())
+	}
+	lines := strings.SplitN(string(b), "\n", 2)
+	if lines[0] != "example.com|A|8.8.8.8:53|192.0.2.1|192.0.2.2,192.0.2.3" {
+		t.Errorf("unexpected environment: %q", lines[0])
+	}
+
+	var event Event
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("invalid JSON on stdin: %v", err)
+	}
+	if event.Domain != "example.com" || event.Kind != EventChange {
+		t.Errorf("unexpected event on stdin: %+v", event)
+	}
+
+	if strings.Contains(logs.String(), "example.org") {
+		t.Error("errors should not run the hook")
+	}
+	if !strings.Contains(logs.String(), "exited with status 3") {
+		t.Errorf("expected exit status to be logged, got %q", logs.String())
+	}
+}
+
+func TestCommandNotifier_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook test uses a POSIX shell")
+	}
+
+	var logs bytes.Buffer
+	n := NewCommandNotifier("sleep 10", 100*time.Millisecond, log.New(&logs, "", 0))
+
+	start := time.Now()
+	n.Notify([]Event{{Kind: EventChange, Domain: "example.com", Type: "A"}})
+	n.Close()
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook was not killed after its timeout, took %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "killed after timeout of 100ms") {
+		t.Errorf("expected timeout to be logged, got %q", logs.String())
+	}
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// spfLookupLimit is the number of DNS lookups an SPF evaluation may cause
+// before receivers return a permanent error (RFC 7208, section 4.6.4).
+const spfLookupLimit = 10
+
+// mailAuthFact is one property of an email authentication record. Facts
+// with a label, such as "policy p=reject", have one value per record;
+// unlabelled facts, such as SPF mechanisms, are members of a set.
+type mailAuthFact struct {
+	Label string
+	Value string
+}
+
+func (f mailAuthFact) String() string {
+	if f.Label == "" {
+		return f.Value
+	}
+	return f.Label + " " + f.Value
+}
+
+// mailAuthReport is the analysis of the SPF record, DMARC policy or DKIM
+// key published at a name.
+type mailAuthReport struct {
+	Kind     string
+	Facts    []mailAuthFact
+	Problems []string
+}
+
+// Values returns the facts in presentation format.
+func (r *mailAuthReport) Values() []string {
+	values := make([]string, 0, len(r.Facts))
+	for _, fact := range r.Facts {
+		values = append(values, fact.String())
+	}
+	return values
+}
+
+// Summary returns the labelled facts, leaving out set members such as the
+// individual SPF mechanisms.
+func (r *mailAuthReport) Summary() string {
+	var parts []string
+	for _, fact := range r.Facts {
+		if fact.Label != "" {
+			parts = append(parts, fact.String())
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("no %s record", r.Kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// mailAuthKind returns which email authentication record is published at
+// domain: a DMARC policy under _dmarc, a DKIM key under _domainkey, or
+// else an SPF record.
+func mailAuthKind(domain string) string {
+	name := strings.ToLower(domain)
+	switch {
+	case strings.HasPrefix(name, "_dmarc."):
+		return "DMARC"
+	case strings.Contains(name, "._domainkey."):
+		return "DKIM"
+	}
+	return "SPF"
+}
+
+// hasVersion reports whether the TXT value txt starts with the version tag
+// version, such as "v=spf1", compared case-insensitively.
+func hasVersion(txt, version string) bool {
+	if len(txt) < len(version) || !strings.EqualFold(txt[:len(version)], version) {
+		return false
+	}
+	rest := txt[len(version):]
+	return rest == "" || rest[0] == ' ' || rest[0] == ';' || rest[0] == '\t'
+}
+
+// spfCache holds the SPF records of include and redirect targets, so that
+// a target shared by several records is looked up once per round.
+type spfCache map[string]spfAnswer
+
+type spfAnswer struct {
+	terms []spfTerm
+	err   error
+}
+
+// AnalyzeMailAuth parses the TXT values of domain as the record its name
+// calls for. SPF records are evaluated up to the lookup limit by resolving
+// include and redirect targets, which are looked up in cache first when it
+// is not nil.
+func (c *DNSClient) AnalyzeMailAuth(domain string, values []string, cache spfCache) *mailAuthReport {
+	report := &mailAuthReport{Kind: mailAuthKind(domain)}
+	switch report.Kind {
+	case "DMARC":
+		var records []string
+		for _, v := range values {
+			if hasVersion(v, "v=DMARC1") {
+				records = append(records, v)
+			}
+		}
+		switch len(records) {
+		case 0:
+			report.Problems = append(report.Problems, "no DMARC record")
+		case 1:
+			analyzeDMARC(report, records[0])
+		default:
+			report.Problems = append(report.Problems, fmt.Sprintf("%d DMARC records, receivers ignore all of them", len(records)))
+		}
+	case "DKIM":
+		switch len(values) {
+		case 0:
+			report.Problems = append(report.Problems, "no DKIM key")
+		case 1:
+			analyzeDKIM(report, values[0])
+		default:
+			report.Problems = append(report.Problems, fmt.Sprintf("%d TXT records at the selector", len(values)))
+		}
+	default:
+		var records []string
+		for _, v := range values {
+			if hasVersion(v, "v=spf1") {
+				records = append(records, v)
+			}
+		}
+		switch len(records) {
+		case 0:
+			report.Problems = append(report.Problems, "no SPF record")
+		case 1:
+			c.analyzeSPF(report, domain, records[0], cache)
+		default:
+			report.Problems = append(report.Problems, fmt.Sprintf("%d SPF records, receivers return permerror", len(records)))
+		}
+	}
+	return report
+}
+
+// spfTerm is a mechanism or modifier of an SPF record.
+type spfTerm struct {
+	Qualifier byte
+	Name      string
+	Value     string
+	Modifier  bool
+}
+
+// String returns the term as written in a record, without the default "+"
+// qualifier.
+func (t spfTerm) String() string {
+	if t.Modifier {
+		return t.Name + "=" + t.Value
+	}
+	s := t.Name
+	if t.Qualifier != '+' {
+		s = string(t.Qualifier) + s
+	}
+	if t.Value != "" {
+		if t.Value[0] == '/' {
+			return s + t.Value
+		}
+		return s + ":" + t.Value
+	}
+	return s
+}
+
+// countsLookup reports whether evaluating the term takes a DNS lookup.
+func (t spfTerm) countsLookup() bool {
+	switch t.Name {
+	case "include", "a", "mx", "ptr", "exists", "redirect":
+		return true
+	}
+	return false
+}
+
+// parseSPF splits an SPF record into its terms.
+func parseSPF(record string) ([]spfTerm, error) {
+	fields := strings.Fields(record)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "v=spf1") {
+		return nil, fmt.Errorf("not an SPF record")
+	}
+
+	var terms []spfTerm
+	for _, field := range fields[1:] {
+		if eq := strings.IndexByte(field, '='); eq > 0 && !strings.ContainsAny(field[:eq], ":/") {
+			name := strings.ToLower(field[:eq])
+			if (name == "redirect" || name == "exp") && eq == len(field)-1 {
+				return nil, fmt.Errorf("%s= without a domain", name)
+			}
+			terms = append(terms, spfTerm{Name: name, Value: field[eq+1:], Modifier: true})
+			continue
+		}
+
+		term := spfTerm{Qualifier: '+'}
+		if strings.IndexByte("+-~?", field[0]) >= 0 {
+			term.Qualifier = field[0]
+			field = field[1:]
+		}
+		end := strings.IndexAny(field, ":/")
+		if end < 0 {
+			end = len(field)
+		}
+		term.Name = strings.ToLower(field[:end])
+		term.Value = strings.TrimPrefix(field[end:], ":")
+
+		switch term.Name {
+		case "all":
+			if term.Value != "" {
+				return nil, fmt.Errorf("unexpected argument to all: %s", field)
+			}
+		case "include", "exists", "ip4", "ip6":
+			if term.Value == "" {
+				return nil, fmt.Errorf("%s without an argument", term.Name)
+			}
+		case "a", "mx", "ptr":
+		default:
+			return nil, fmt.Errorf("unknown mechanism %q", field)
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+func (c *DNSClient) analyzeSPF(report *mailAuthReport, domain, record string, cache spfCache) {
+	terms, err := parseSPF(record)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("invalid SPF record: %v", err))
+		return
+	}
+
+	defaultResult := "none"
+	var mechanisms []mailAuthFact
+	for _, term := range terms {
+		switch {
+		case term.Name == "all":
+			defaultResult = term.String()
+			if term.Qualifier == '+' {
+				report.Problems = append(report.Problems, "+all allows any sender")
+			}
+		case term.Name == "redirect":
+			if defaultResult == "none" {
+				defaultResult = term.String()
+			}
+		case !term.Modifier:
+			mechanisms = append(mechanisms, mailAuthFact{Value: term.String()})
+		}
+	}
+
+	lookups, problems := c.spfLookups(terms, map[string]bool{canonicalName(domain): true}, cache, spfLookupLimit)
+	report.Problems = append(report.Problems, problems...)
+	count := fmt.Sprintf("%d/%d", lookups, spfLookupLimit)
+	if lookups > spfLookupLimit {
+		count = fmt.Sprintf(">%d", spfLookupLimit)
+		report.Problems = append(report.Problems, fmt.Sprintf("exceeds lookup limit (%s)", count))
+	}
+
+	report.Facts = append(report.Facts,
+		mailAuthFact{Label: "default", Value: defaultResult},
+		mailAuthFact{Label: "lookups", Value: count},
+	)
+	report.Facts = append(report.Facts, mechanisms...)
+}
+
+// spfLookups counts the DNS lookups evaluating terms takes, including those
+// of the records pulled in by include and redirect. Counting stops once
+// more than limit lookups were found, as receivers stop evaluating there.
+// Targets that cannot be followed are returned as problems. path holds the
+// names being evaluated, so include loops end.
+func (c *DNSClient) spfLookups(terms []spfTerm, path map[string]bool, cache spfCache, limit int) (int, []string) {
+	lookups := 0
+	var problems []string
+	hasAll := false
+	for _, term := range terms {
+		if term.Name == "all" && !term.Modifier {
+			hasAll = true
+		}
+	}
+
+	for _, term := range terms {
+		if lookups > limit {
+			break
+		}
+		if !term.countsLookup() || (term.Name == "redirect" && hasAll) {
+			continue
+		}
+		lookups++
+		if lookups > limit || term.Name != "include" && term.Name != "redirect" {
+			continue
+		}
+		if strings.Contains(term.Value, "%") {
+			// Macros expand per message, so the target is unknown here.
+			continue
+		}
+
+		target := canonicalName(term.Value)
+		if path[target] {
+			problems = append(problems, fmt.Sprintf("%s loops", term))
+			continue
+		}
+
+		nested, err := c.lookupSPF(term.Value, cache)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", term, err))
+			continue
+		}
+		path[target] = true
+		n, nestedProblems := c.spfLookups(nested, path, cache, limit-lookups)
+		delete(path, target)
+		lookups += n
+		problems = append(problems, nestedProblems...)
+	}
+	return lookups, problems
+}
+
+// lookupSPF returns the SPF record of domain held in cache, or fetches it
+// and adds it to cache.
+func (c *DNSClient) lookupSPF(domain string, cache spfCache) ([]spfTerm, error) {
+	key := canonicalName(domain)
+	if answer, ok := cache[key]; ok {
+		return answer.terms, answer.err
+	}
+	terms, err := c.fetchSPF(domain)
+	if cache != nil {
+		cache[key] = spfAnswer{terms, err}
+	}
+	return terms, err
+}
+
+// fetchSPF fetches and parses the SPF record of domain.
+func (c *DNSClient) fetchSPF(domain string) ([]spfTerm, error) {
+	record, err := c.Query(domain, "TXT")
+	if err != nil {
+		if code := errorCode(err); code == "NXDOMAIN" || code == "NODATA" {
+			return nil, fmt.Errorf("no SPF record")
+		}
+		return nil, err
+	}
+	var found []string
+	for _, v := range record.Values {
+		if hasVersion(v, "v=spf1") {
+			found = append(found, v)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no SPF record")
+	case 1:
+		return parseSPF(found[0])
+	}
+	return nil, fmt.Errorf("%d SPF records", len(found))
+}
+
+// parseTags splits a DMARC or DKIM record into its tag=value pairs, in
+// order, with lower-cased tag names.
+func parseTags(record string) ([]mailAuthFact, error) {
+	var tags []mailAuthFact
+	for _, part := range strings.Split(record, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		tags = append(tags, mailAuthFact{
+			Label: strings.ToLower(strings.TrimSpace(part[:eq])),
+			Value: strings.TrimSpace(part[eq+1:]),
+		})
+	}
+	return tags, nil
+}
+
+// dmarcTags are the DMARC tags reported, with the label used for each.
+var dmarcTags = []struct{ tag, label string }{
+	{"p", "policy"},
+	{"sp", "subdomain policy"},
+	{"pct", "percentage"},
+	{"adkim", "DKIM alignment"},
+	{"aspf", "SPF alignment"},
+	{"rua", "aggregate reports"},
+	{"ruf", "failure reports"},
+}
+
+func analyzeDMARC(report *mailAuthReport, record string) {
+	tags, err := parseTags(record)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("invalid DMARC record: %v", err))
+		return
+	}
+	if tags[0].Label != "v" || tags[0].Value != "DMARC1" {
+		report.Problems = append(report.Problems, fmt.Sprintf("invalid DMARC record: bad version tag %q", tags[0].Label+"="+tags[0].Value))
+		return
+	}
+	values := make(map[string]string)
+	for _, tag := range tags {
+		values[tag.Label] = tag.Value
+	}
+
+	for _, t := range dmarcTags {
+		value, ok := values[t.tag]
+		if !ok {
+			continue
+		}
+		if t.tag == "p" || t.tag == "sp" {
+			value = strings.ToLower(value)
+			if value != "none" && value != "quarantine" && value != "reject" {
+				report.Problems = append(report.Problems, fmt.Sprintf("invalid %s %s=%s", t.label, t.tag, value))
+			}
+		}
+		report.Facts = append(report.Facts, mailAuthFact{Label: t.label, Value: t.tag + "=" + value})
+	}
+	if _, ok := values["p"]; !ok {
+		report.Problems = append(report.Problems, "missing policy (p=)")
+	}
+}
+
+func analyzeDKIM(report *mailAuthReport, record string) {
+	tags, err := parseTags(record)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("invalid DKIM record: %v", err))
+		return
+	}
+	values := make(map[string]string)
+	for _, tag := range tags {
+		values[tag.Label] = tag.Value
+	}
+	if v, ok := values["v"]; ok && v != "DKIM1" {
+		report.Problems = append(report.Problems, fmt.Sprintf("unknown version v=%s", v))
+	}
+
+	keyType := strings.ToLower(values["k"])
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	p, ok := values["p"]
+	p = strings.Join(strings.Fields(p), "")
+	switch {
+	case !ok:
+		report.Problems = append(report.Problems, "missing public key (p=)")
+	case p == "":
+		report.Facts = append(report.Facts, mailAuthFact{Label: "key", Value: "revoked"})
+		report.Problems = append(report.Problems, "key revoked (empty p=)")
+	default:
+		key, bits, err := describeDKIMKey(keyType, p)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("invalid public key: %v", err))
+			break
+		}
+		report.Facts = append(report.Facts, mailAuthFact{Label: "key", Value: key})
+		if keyType == "rsa" && bits < 1024 {
+			report.Problems = append(report.Problems, fmt.Sprintf("weak %d-bit RSA key", bits))
+		}
+	}
+
+	for _, tag := range []string{"h", "s", "t"} {
+		if value, ok := values[tag]; ok {
+			report.Facts = append(report.Facts, mailAuthFact{Label: "flags", Value: tag + "=" + value})
+		}
+	}
+}
+
+// describeDKIMKey returns the algorithm and size of a base64 public key,
+// and its size in bits.
+func describeDKIMKey(keyType, p string) (string, int, error) {
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad base64")
+	}
+	switch keyType {
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return "", 0, fmt.Errorf("ed25519 key of %d bytes", len(der))
+		}
+		return "ed25519", 8 * ed25519.PublicKeySize, nil
+	case "rsa":
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			// Some publishers put a PKCS#1 key in p= instead.
+			rsaKey, err := x509.ParsePKCS1PublicKey(der)
+			if err != nil {
+				return "", 0, fmt.Errorf("not an RSA public key")
+			}
+			key = rsaKey
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return "", 0, fmt.Errorf("not an RSA public key")
+		}
+		bits := rsaKey.N.BitLen()
+		return fmt.Sprintf("rsa %d-bit", bits), bits, nil
+	}
+	return "", 0, fmt.Errorf("unknown key type k=%s", keyType)
+}
+
+// diffMailAuth describes the changes from before to after in terms of the
+// records' meaning, such as "policy p=none -> p=reject".
+func diffMailAuth(before, after *mailAuthReport) []string {
+	var changes []string
+	labelled := func(facts []mailAuthFact) map[string][]string {
+		m := make(map[string][]string)
+		for _, f := range facts {
+			if f.Label != "" {
+				m[f.Label] = append(m[f.Label], f.Value)
+			}
+		}
+		return m
+	}
+	old, cur := labelled(before.Facts), labelled(after.Facts)
+
+	for _, fact := range after.Facts {
+		if fact.Label == "" {
+			if !containsFact(before.Facts, fact) {
+				changes = append(changes, fact.Value+" added")
+			}
+			continue
+		}
+		previous, ok := old[fact.Label]
+		switch {
+		case !ok:
+			changes = append(changes, fact.String()+" added")
+		case fact.Label == "lookups" && previous[0] != fact.Value:
+			changes = append(changes, describeLookups(previous[0], fact.Value))
+		case fact.Label != "lookups" && !containsString(previous, fact.Value):
+			if len(previous) == 1 && len(cur[fact.Label]) == 1 {
+				changes = append(changes, fmt.Sprintf("%s %s -> %s", fact.Label, previous[0], fact.Value))
+			} else {
+				changes = append(changes, fact.String()+" added")
+			}
+		}
+	}
+	for _, fact := range before.Facts {
+		if fact.Label == "" {
+			if !containsFact(after.Facts, fact) {
+				changes = append(changes, fact.Value+" removed")
+			}
+			continue
+		}
+		values, ok := cur[fact.Label]
+		if !ok || (!containsString(values, fact.Value) && (len(values) != 1 || len(old[fact.Label]) != 1)) {
+			changes = append(changes, fact.String()+" removed")
+		}
+	}
+	return changes
+}
+
+func containsFact(facts []mailAuthFact, fact mailAuthFact) bool {
+	for _, f := range facts {
+		if f == fact {
+			return true
+		}
+	}
+	return false
+}
+
+// describeLookups words a change of the SPF lookup count, calling out when
+// the record crosses the lookup limit.
+func describeLookups(before, after string) string {
+	b, a := lookupCount(before), lookupCount(after)
+	switch {
+	case a > spfLookupLimit && b <= spfLookupLimit:
+		return fmt.Sprintf("SPF now exceeds lookup limit (%s)", after)
+	case a <= spfLookupLimit && b > spfLookupLimit:
+		return fmt.Sprintf("SPF back within lookup limit (%s)", after)
+	}
+	return fmt.Sprintf("lookups %s -> %s", before, after)
+}
+
+// lookupCount parses a lookups fact, "7/10" or ">10".
+func lookupCount(value string) int {
+	if strings.HasPrefix(value, ">") {
+		return spfLookupLimit + 1
+	}
+	var n int
+	fmt.Sscanf(value, "%d/", &n)
+	return n
+}
+
+// checkMailAuth analyzes the SPF, DMARC or DKIM record of every TXT target
+// and reports whether the meaning of any of them changed.
+func (m *Monitor) checkMailAuth(timestamp string, targets []Target) bool {
+	if m.mailAuth == nil {
+		m.mailAuth = make(map[string]*mailAuthReport)
+	}
+	hasChanges := false
+	seen := make(map[string]bool)
+	cache := make(spfCache)
+	for _, target := range targets {
+		if target.Type != "TXT" || seen[target.Domain] {
+			continue
+		}
+		seen[target.Domain] = true
+		if m.checkMailAuthRecord(timestamp, target.Domain, cache) {
+			hasChanges = true
+		}
+	}
+	return hasChanges
+}
+
+func (m *Monitor) checkMailAuthRecord(timestamp, domain string, cache spfCache) bool {
+	kind := mailAuthKind(domain)
+	obs := Observation{Time: time.Now(), Domain: domain, Type: kind}
+	var values []string
+	record, err := m.dnsClient.Query(domain, "TXT")
+	if err == nil {
+		values = record.Values
+	} else if code := errorCode(err); code != "NXDOMAIN" && code != "NODATA" {
+		obs.Status = StatusError
+		obs.Error = err.Error()
+		obs.Rcode = code
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - ERROR: %v", timestamp, domain, kind, err)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+	}
+
+	key := domain + ":" + kind
+	last, exists := m.mailAuth[key]
+	if !exists && kind == "SPF" {
+		// A TXT target that never had an SPF record holds something else,
+		// such as a verification token.
+		spf := false
+		for _, v := range values {
+			spf = spf || hasVersion(v, "v=spf1")
+		}
+		if !spf {
+			return false
+		}
+	}
+	report := m.dnsClient.AnalyzeMailAuth(domain, values, cache)
+	m.mailAuth[key] = report
+	obs.Values = report.Values()
+
+	changed := false
+	switch {
+	case !exists:
+		obs.Status = StatusInitial
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - Initial: %s", timestamp, domain, kind, report.Summary())
+		m.printColored(message, ColorGreen)
+		m.logger.Println(message)
+	default:
+		changes := diffMailAuth(last, report)
+		if len(changes) == 0 {
+			obs.Status = StatusUnchanged
+			m.emit(obs)
+			message := fmt.Sprintf("[%s] %s (%s) - No change: %s", timestamp, domain, kind, report.Summary())
+			m.printColored(message, ColorGreen)
+			m.logger.Println(message)
+			break
+		}
+		changed = true
+		obs.Status = StatusChanged
+		obs.Previous = last.Values()
+		obs.Message = strings.Join(changes, "; ")
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED:", timestamp, domain, kind)
+		m.printColored(message, ColorRed)
+		m.logger.Println(message)
+		for _, change := range changes {
+			changeMsg := "  " + change
+			m.printColored(changeMsg, ColorBlue)
+			m.logger.Println(changeMsg)
+		}
+	}
+
+	for _, problem := range report.Problems {
+		m.emit(Observation{Time: time.Now(), Domain: domain, Type: kind, Status: StatusWarning, Values: obs.Values, Message: problem})
+		message := fmt.Sprintf("[%s] %s (%s) - WARNING: %s", timestamp, domain, kind, problem)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+	}
+	return changed
+}
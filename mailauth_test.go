@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// txtZone answers TXT queries from a map that tests can change.
+type txtZone struct {
+	mu      sync.Mutex
+	records map[string][]string
+	queries int
+}
+
+func (z *txtZone) set(name string, values ...string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.records == nil {
+		z.records = make(map[string][]string)
+	}
+	z.records[name] = values
+}
+
+// takeQueries returns the number of queries answered since the last call.
+func (z *txtZone) takeQueries() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := z.queries
+	z.queries = 0
+	return n
+}
+
+func (z *txtZone) handler(t *testing.T) func(q *dnsMessage) *dnsMessage {
+	return func(q *dnsMessage) *dnsMessage {
+		z.mu.Lock()
+		defer z.mu.Unlock()
+		z.queries++
+		question := q.Questions[0]
+		values, ok := z.records[strings.TrimSuffix(canonicalName(question.Name), ".")]
+		if !ok {
+			return &dnsMessage{Rcode: RcodeNXDomain}
+		}
+		resp := &dnsMessage{}
+		if question.Type != TypeTXT {
+			return resp
+		}
+		for _, v := range values {
+			var data []byte
+			for len(v) > 255 {
+				data = append(append(data, 255), v[:255]...)
+				v = v[255:]
+			}
+			data = append(append(data, byte(len(v))), v...)
+			resp.Answers = append(resp.Answers, mustRR(t, question.Name, TypeTXT, data))
+		}
+		return resp
+	}
+}
+
+func TestParseSPF(t *testing.T) {
+	terms, err := parseSPF("v=spf1 ip4:192.0.2.0/24 a/24 +mx ~include:_spf.example.net redirect=_spf.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, term := range terms {
+		got = append(got, term.String())
+	}
+	want := "ip4:192.0.2.0/24 a/24 mx ~include:_spf.example.net redirect=_spf.example.com"
+	if strings.Join(got, " ") != want {
+		t.Errorf("unexpected terms: %q", strings.Join(got, " "))
+	}
+
+	for _, record := range []string{
+		"v=spf2 -all",
+		"v=spf1 include",
+		"v=spf1 -all:example.com",
+		"v=spf1 mx foo:bar -all",
+		"v=spf1 redirect=",
+	} {
+		if _, err := parseSPF(record); err == nil {
+			t.Errorf("expected error for %q", record)
+		}
+	}
+}
+
+func TestDNSClient_AnalyzeMailAuth_SPF(t *testing.T) {
+	zone := &txtZone{}
+	zone.set("example.com", "v=spf1 include:_spf.example.net mx a -all", "google-site-verification=abc")
+	zone.set("_spf.example.net", "v=spf1 include:_a.example.net include:_a.example.net ~all")
+	zone.set("_a.example.net", "v=spf1 ip4:192.0.2.0/24 exists:%{i}.example.net ?all")
+	zone.set("loop.example.org", "v=spf1 include:loop.example.org -all")
+	zone.set("big.example.org", "v=spf1 include:_spf.example.net include:_spf.example.net include:_spf.example.net -all")
+	zone.set("double.example.org", "v=spf1 -all", "v=spf1 +all")
+	client := NewDNSClient([]string{startStubServer(t, zone.handler(t))}, 2*time.Second)
+
+	cache := make(spfCache)
+	report := client.AnalyzeMailAuth("example.com", []string{"v=spf1 include:_spf.example.net mx a -all"}, cache)
+	if len(report.Problems) > 0 {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+	if got := report.Summary(); got != "default -all, lookups 7/10" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+	if got := strings.Join(report.Values(), "|"); got != "default -all|lookups 7/10|include:_spf.example.net|mx|a" {
+		t.Errorf("unexpected values: %s", got)
+	}
+
+	if n := zone.takeQueries(); n != 2 {
+		t.Errorf("expected each include target to be queried once, got %d queries", n)
+	}
+
+	report = client.AnalyzeMailAuth("big.example.org", []string{"v=spf1 include:_spf.example.net include:_spf.example.net include:_spf.example.net -all"}, cache)
+	if got := report.Summary(); got != "default -all, lookups >10" || !containsString(report.Problems, "exceeds lookup limit (>10)") {
+		t.Errorf("expected lookup limit to be exceeded, got %s %v", got, report.Problems)
+	}
+	if n := zone.takeQueries(); n != 0 {
+		t.Errorf("expected cached include targets to be reused, got %d queries", n)
+	}
+
+	zone.set("deep.example.org", "v=spf1 include:deep1.example.org -all")
+	for i := 1; i <= 20; i++ {
+		zone.set(fmt.Sprintf("deep%d.example.org", i), fmt.Sprintf("v=spf1 include:deep%d.example.org -all", i+1))
+	}
+	report = client.AnalyzeMailAuth("deep.example.org", []string{"v=spf1 include:deep1.example.org -all"}, nil)
+	if n := zone.takeQueries(); report.Summary() != "default -all, lookups >10" || n != spfLookupLimit {
+		t.Errorf("expected evaluation to stop at the lookup limit, got %s after %d queries", report.Summary(), n)
+	}
+
+	report = client.AnalyzeMailAuth("loop.example.org", []string{"v=spf1 include:loop.example.org -all"}, nil)
+	if !containsString(report.Problems, "include:loop.example.org loops") {
+		t.Errorf("expected include loop, got %v", report.Problems)
+	}
+
+	report = client.AnalyzeMailAuth("missing.example.org", []string{"v=spf1 include:gone.example.org -all"}, nil)
+	if !containsString(report.Problems, "include:gone.example.org: no SPF record") {
+		t.Errorf("expected missing include target, got %v", report.Problems)
+	}
+
+	report = client.AnalyzeMailAuth("double.example.org", []string{"v=spf1 -all", "v=spf1 +all"}, nil)
+	if len(report.Facts) != 0 || !containsString(report.Problems, "2 SPF records, receivers return permerror") {
+		t.Errorf("expected multiple records to be reported, got %+v", report)
+	}
+
+	report = client.AnalyzeMailAuth("open.example.org", []string{"v=spf1 +all"}, nil)
+	if !containsString(report.Problems, "+all allows any sender") {
+		t.Errorf("expected +all to be reported, got %v", report.Problems)
+	}
+}
+
+func TestAnalyzeMailAuth_DMARC(t *testing.T) {
+	client := NewDNSClient(nil, time.Second)
+	report := client.AnalyzeMailAuth("_dmarc.example.com", []string{"v=DMARC1; p=Quarantine; pct=50; rua=mailto:dmarc@example.com; fo=1"}, nil)
+	if report.Kind != "DMARC" || len(report.Problems) > 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if got := report.Summary(); got != "policy p=quarantine, percentage pct=50, aggregate reports rua=mailto:dmarc@example.com" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+
+	for values, problem := range map[string]string{
+		"v=DMARC1; rua=mailto:dmarc@example.com": "missing policy (p=)",
+		"v=DMARC1; p=block":                      "invalid policy p=block",
+		"v=DMARC1 p=none":                        "invalid DMARC record: bad version tag \"v=DMARC1 p=none\"",
+		"":                                       "no DMARC record",
+	} {
+		report := client.AnalyzeMailAuth("_dmarc.example.com", []string{values}, nil)
+		if !containsString(report.Problems, problem) {
+			t.Errorf("expected %q for %q, got %v", problem, values, report.Problems)
+		}
+	}
+}
+
+func TestAnalyzeMailAuth_DKIM(t *testing.T) {
+	client := NewDNSClient(nil, time.Second)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		record  string
+		summary string
+		problem string
+	}{
+		{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), "key rsa 1024-bit", ""},
+		{"v=DKIM1; k=ed25519; t=y; p=" + base64.StdEncoding.EncodeToString(edKey), "key ed25519, flags t=y", ""},
+		{"v=DKIM1; p=", "key revoked", "key revoked (empty p=)"},
+		{"v=DKIM1; p=!!!", "no DKIM record", "invalid public key: bad base64"},
+		{"v=DKIM1; k=dsa; p=" + base64.StdEncoding.EncodeToString(der), "no DKIM record", "invalid public key: unknown key type k=dsa"},
+	}
+	for _, tt := range tests {
+		report := client.AnalyzeMailAuth("sel1._domainkey.example.com", []string{tt.record}, nil)
+		if report.Kind != "DKIM" || report.Summary() != tt.summary {
+			t.Errorf("unexpected summary for %q: %s", tt.record, report.Summary())
+		}
+		if tt.problem == "" && len(report.Problems) > 0 || tt.problem != "" && !containsString(report.Problems, tt.problem) {
+			t.Errorf("expected problem %q for %q, got %v", tt.problem, tt.record, report.Problems)
+		}
+	}
+}
+
+func TestDiffMailAuth(t *testing.T) {
+	report := func(facts ...mailAuthFact) *mailAuthReport {
+		return &mailAuthReport{Facts: facts}
+	}
+	tests := []struct {
+		before, after *mailAuthReport
+		want          []string
+	}{
+		{
+			report(mailAuthFact{"policy", "p=none"}, mailAuthFact{"percentage", "pct=50"}),
+			report(mailAuthFact{"policy", "p=reject"}, mailAuthFact{"aggregate reports", "rua=mailto:d@example.com"}),
+			[]string{"policy p=none -> p=reject", "aggregate reports rua=mailto:d@example.com added", "percentage pct=50 removed"},
+		},
+		{
+			report(mailAuthFact{"default", "~all"}, mailAuthFact{"lookups", "9/10"}, mailAuthFact{Value: "mx"}),
+			report(mailAuthFact{"default", "~all"}, mailAuthFact{"lookups", ">10"}, mailAuthFact{Value: "include:_spf.example.net"}),
+			[]string{"SPF now exceeds lookup limit (>10)", "include:_spf.example.net added", "mx removed"},
+		},
+		{
+			report(mailAuthFact{"lookups", ">10"}),
+			report(mailAuthFact{"lookups", "10/10"}),
+			[]string{"SPF back within lookup limit (10/10)"},
+		},
+		{
+			report(mailAuthFact{"key", "rsa 1024-bit"}, mailAuthFact{"flags", "t=y"}),
+			report(mailAuthFact{"key", "rsa 1024-bit"}, mailAuthFact{"flags", "t=y"}),
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		got := diffMailAuth(tt.before, tt.after)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("diffMailAuth(%v, %v) = %q, want %q", tt.before.Values(), tt.after.Values(), got, tt.want)
+		}
+	}
+}
+
+func TestMonitor_checkMailAuth(t *testing.T) {
+	zone := &txtZone{}
+	zone.set("_dmarc.example.com", "v=DMARC1; p=none")
+	zone.set("example.com", "v=spf1 mx -all")
+	zone.set("verify.example.com", "token=abc")
+	monitor, buf := newTestMonitor(t, &Config{NoColor: true, MailAuth: true}, startStubServer(t, zone.handler(t)))
+	targets := []Target{
+		{Domain: "_dmarc.example.com", Type: "TXT"},
+		{Domain: "example.com", Type: "TXT"},
+		{Domain: "example.com", Type: "A"},
+		{Domain: "verify.example.com", Type: "TXT"},
+	}
+
+	if monitor.checkMailAuth("ts", targets) {
+		t.Error("initial check should not be reported as a change")
+	}
+	if monitor.checkMailAuth("ts", targets) {
+		t.Error("unchanged records should not be reported as a change")
+	}
+	zone.set("_dmarc.example.com", "v=DMARC1; p=reject")
+	zone.set("example.com", "v=spf1 mx -all", "v=spf1 a -all")
+	if !monitor.checkMailAuth("ts", targets) {
+		t.Error("expected policy change to be reported as a change")
+	}
+
+	for _, want := range []string{
+		"_dmarc.example.com (DMARC) - Initial: policy p=none",
+		"example.com (SPF) - No change: default -all, lookups 1/10",
+		"_dmarc.example.com (DMARC) - CHANGE DETECTED:",
+		"  policy p=none -> p=reject",
+		"  default -all removed",
+		"example.com (SPF) - WARNING: 2 SPF records, receivers return permerror",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in log, got %q", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "verify.example.com") {
+		t.Errorf("expected TXT targets without SPF records to be skipped, got %q", buf.String())
+	}
+}
@@ -9,6 +9,23 @@ import (
 const Version = "1.0.0"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBenchCommand(os.Args[1:]))
+		case "check":
+			os.Exit(runCheckCommand(os.Args[1:]))
+		case "drift":
+			os.Exit(runDriftCommand(os.Args[1:]))
+		case "history":
+			os.Exit(runHistoryCommand(os.Args[1:]))
+		case "import-zone":
+			os.Exit(runImportZoneCommand(os.Args[1:]))
+		case "serve":
+			os.Exit(runServeCommand(os.Args[1:]))
+		}
+	}
+
 	config, err := ParseArgs(os.Args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -26,6 +43,7 @@ func main() {
 	}
 
 	monitor := NewMonitor(config)
+	monitor.reload = func() (*Config, error) { return ParseArgs(os.Args) }
 	if err := monitor.Start(); err != nil {
 		log.Fatalf("Failed to start monitoring: %v", err)
 	}
@@ -36,17 +54,71 @@ func printUsage() {
 
 USAGE:
     dns-monitor [OPTIONS] DOMAIN [DOMAIN...]
+    dns-monitor COMMAND [OPTIONS]
+
+COMMANDS:
+    bench                    Compare resolver latency and answers (see dns-monitor bench --help)
+    check                    Run one round of checks with monitoring plugin exit codes
+    drift                    Report differences between a zone file or provider export and live DNS (see dns-monitor drift --help)
+    history                  List recorded changes and how long values were live
+    import-zone              Monitor every record of a zone file against its values (see dns-monitor import-zone --help)
+    serve                    Monitor and serve a REST API and status page (see dns-monitor serve --help)
 
 OPTIONS:
     -t, --type TYPE          DNS record type (A, AAAA, CNAME, MX, TXT, etc.) [default: A]
     -i, --interval DURATION  Check interval [default: 5s]
-    -s, --server SERVER      Specify DNS server (multiple allowed)
+    -s, --server SERVER      Specify DNS server (multiple allowed) [default: nameservers in /etc/resolv.conf]
+    --domains-file FILE     Read "domain [type] [expected...]" lines from FILE, - for stdin (multiple allowed)
+    --zone-file FILE        Monitor every record of a BIND zone file, expecting its values (multiple allowed)
+    --origin ZONE           Origin of zone files without $ORIGIN [default: from db.NAME, NAME.zone or NAME.db]
     --all-servers           Query all major DNS servers (8.8.8.8, 1.1.1.1, 1.0.0.1)
     --until-change          Monitor until change mode
+    --confirm N             Accept a new value only after N identical answers in a row [default: 1]
+    --flap-threshold K      Report FLAPPING once and suppress changes after more than K changes in the flap window
+    --flap-window DURATION  Window for flap detection [default: 10m]
+    --compare [DOMAIN=]MODE Comparison for changes: exact, union[:N] or contains (multiple allowed) [default: exact]
     -o, --output FILE       Log file output destination
-    --no-color              Disable colored output
+    --state-file FILE       Save last records to FILE and report changes made while stopped
+    --history FILE          Append initial values and changes to FILE [env: DNS_MONITOR_HISTORY]
+    --color WHEN            Colored output: auto, always or never [default: auto]
+    --no-color              Disable colored output (same as --color never)
+    --json                  Print observations and statistics as JSON lines
+    --tui                   Show a live dashboard instead of line output (terminal only)
+    --metrics-listen ADDR   Serve Prometheus metrics on ADDR (e.g. :9153)
+    --listen ADDR           Serve the REST API and status page on ADDR [serve default: 127.0.0.1:8080]
+    --api-token TOKEN       Bearer token required to add and remove targets over the API [env: DNS_MONITOR_API_TOKEN]
+    --webhook URL           POST changes and errors as JSON to URL (multiple allowed)
+    --webhook-secret SECRET Sign webhook payloads with HMAC-SHA256 [env: DNS_MONITOR_WEBHOOK_SECRET]
+    --webhook-timeout DURATION  Webhook request timeout [default: 10s]
+    --webhook-retries N     Webhook delivery retries on failure [default: 3]
+    --slack [DOMAIN=]URL    Post changes to a Slack incoming webhook (multiple allowed)
+    --teams [DOMAIN=]URL    Post changes to a Microsoft Teams connector (multiple allowed)
+    --smtp HOST[:PORT]      Email changes and persistent errors via SMTP [default port: 587]
+    --smtp-starttls         Require STARTTLS before authenticating
+    --smtp-user USER        SMTP username (password from DNS_MONITOR_SMTP_PASSWORD)
+    --smtp-password PASS    SMTP password
+    --mail-from ADDRESS     Sender address
+    --mail-to ADDRESS       Recipient address (multiple or comma-separated allowed)
+    --mail-digest DURATION  Collect events for DURATION before mailing [default: 1m]
+    --mail-errors-after N   Mail errors after N consecutive failures [default: 3]
+    --on-change COMMAND     Run COMMAND through the shell for every change
+    --on-change-timeout DURATION  Kill the command after DURATION [default: 30s]
+    --dnssec                Check RRSIG expiry and follow DNSKEY/DS changes
+    --sig-expiry DURATION   Warn when signatures expire within DURATION [default: 72h]
+    --fcrdns                Check that the PTR of every A/AAAA value resolves back to it
+    --mail-auth             Analyze SPF, DMARC and DKIM records of TXT targets and report changes in meaning
+    --axfr ZONE             Watch ZONE by zone transfer and report changed RRsets (multiple allowed)
+    --xfr-server SERVER     Server allowing zone transfers [default: first --server]
+    --tsig [ALG:]NAME:KEY   Sign transfers with a base64 TSIG key [default: hmac-sha256, env: DNS_MONITOR_TSIG]
+    --notify-listen ADDR    Check a zone's targets on DNS NOTIFY received on ADDR (UDP), e.g. :5300
+    --notify-from ADDR      Accept NOTIFY only from ADDR or CIDR (required with --notify-listen, multiple allowed)
+    --propagation-timeout DURATION  Stop waiting for servers to serve a notified serial [default: 10m]
+    -e, --expect VALUE      Expected record value (multiple allowed)
+    --rtt-warn DURATION     Round-trip time warning threshold (check)
+    --rtt-crit DURATION     Round-trip time critical threshold (check)
     -h, --help              Display help
     -v, --version           Display version
+    --                      Treat the remaining arguments as domains, even if named like a command
 
 EXAMPLES:
     dns-monitor example.com
@@ -54,5 +126,7 @@ EXAMPLES:
     dns-monitor -t CNAME --until-change www.example.com
     dns-monitor -s 8.8.8.8 -s 1.1.1.1 example.com
     dns-monitor -o /var/log/dns-monitor.log example.com
+    dns-monitor --dnssec --sig-expiry 7d example.com
+    dns-monitor --metrics-listen :9153 -e 203.0.113.1 example.com
 `, Version)
-}
\ No newline at end of file
+}
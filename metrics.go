@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryDurationBuckets are the upper bounds, in seconds, of the query
+// duration histogram buckets.
+var queryDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics aggregates observations into Prometheus metrics, exposed in the
+// text exposition format.
+type Metrics struct {
+	// failover is set when each check goes through server failover, so the
+	// server that answers can change between checks. Check series are then
+	// labelled by target only, letting a recovery reset a failure's series.
+	failover bool
+
+	mu         sync.Mutex
+	durations  map[string]*histogram
+	checks     map[[4]string]uint64
+	errors     map[[2]string]uint64
+	changes    map[[2]string]uint64
+	lastChange map[[2]string]float64
+	expected   map[[3]string]float64
+	propagated map[[2]string]float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durations:  make(map[string]*histogram),
+		checks:     make(map[[4]string]uint64),
+		errors:     make(map[[2]string]uint64),
+		changes:    make(map[[2]string]uint64),
+		lastChange: make(map[[2]string]float64),
+		expected:   make(map[[3]string]float64),
+		propagated: make(map[[2]string]float64),
+	}
+}
+
+func (mt *Metrics) Observe(obs Observation) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	server := obs.Server
+	if mt.failover {
+		server = ""
+	}
+	mt.checks[[4]string{obs.Domain, obs.Type, server, obs.Status}]++
+
+	if obs.RTT > 0 {
+		h, ok := mt.durations[obs.Server]
+		if !ok {
+			h = &histogram{counts: make([]uint64, len(queryDurationBuckets))}
+			mt.durations[obs.Server] = h
+		}
+		seconds := obs.RTT.Seconds()
+		for i, bound := range queryDurationBuckets {
+			if seconds <= bound {
+				h.counts[i]++
+			}
+		}
+		h.sum += seconds
+		h.count++
+	}
+
+	switch obs.Status {
+	case StatusError:
+		mt.errors[[2]string{obs.Server, obs.Rcode}]++
+	case StatusChanged:
+		key := [2]string{obs.Domain, obs.Type}
+		mt.changes[key]++
+		mt.lastChange[key] = float64(obs.Time.UnixNano()) / 1e9
+	}
+
+	if obs.Matches != nil {
+		value := 0.0
+		if *obs.Matches {
+			value = 1
+		}
+		mt.expected[[3]string{obs.Domain, obs.Type, server}] = value
+		if obs.Status == StatusError && server == "" {
+			// Every server failed, so none of them serves the expected values.
+			for key := range mt.expected {
+				if key[0] == obs.Domain && key[1] == obs.Type {
+					mt.expected[key] = 0
+				}
+			}
+		}
+	}
+}
+
+// ObservePropagation records how long after a NOTIFY server first served
+// the new serial of zone.
+func (mt *Metrics) ObservePropagation(zone, server string, delay time.Duration) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.propagated[[2]string{zone, server}] = delay.Seconds()
+}
+
+func (mt *Metrics) Write(w io.Writer) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP dns_monitor_query_duration_seconds Round-trip time of successful DNS queries.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_query_duration_seconds histogram")
+	for _, server := range sortedKeys(mt.durations) {
+		h := mt.durations[server]
+		for i, bound := range queryDurationBuckets {
+			fmt.Fprintf(w, "dns_monitor_query_duration_seconds_bucket{server=%s,le=\"%g\"} %d\n", quoteLabel(server), bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "dns_monitor_query_duration_seconds_bucket{server=%s,le=\"+Inf\"} %d\n", quoteLabel(server), h.count)
+		fmt.Fprintf(w, "dns_monitor_query_duration_seconds_sum{server=%s} %g\n", quoteLabel(server), h.sum)
+		fmt.Fprintf(w, "dns_monitor_query_duration_seconds_count{server=%s} %d\n", quoteLabel(server), h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_checks_total Checks performed, by outcome.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_checks_total counter")
+	for _, key := range sortedKeys(mt.checks) {
+		fmt.Fprintf(w, "dns_monitor_checks_total{domain=%s,type=%s,server=%s,status=%s} %d\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), quoteLabel(key[2]), quoteLabel(key[3]), mt.checks[key])
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_query_errors_total Failed lookups, by response code or failure kind.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_query_errors_total counter")
+	for _, key := range sortedKeys(mt.errors) {
+		fmt.Fprintf(w, "dns_monitor_query_errors_total{server=%s,rcode=%s} %d\n", quoteLabel(key[0]), quoteLabel(key[1]), mt.errors[key])
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_changes_total Detected record changes.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_changes_total counter")
+	for _, key := range sortedKeys(mt.changes) {
+		fmt.Fprintf(w, "dns_monitor_changes_total{domain=%s,type=%s} %d\n", quoteLabel(key[0]), quoteLabel(key[1]), mt.changes[key])
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_last_change_timestamp_seconds Unix time of the last detected change.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_last_change_timestamp_seconds gauge")
+	for _, key := range sortedKeys(mt.lastChange) {
+		fmt.Fprintf(w, "dns_monitor_last_change_timestamp_seconds{domain=%s,type=%s} %.3f\n", quoteLabel(key[0]), quoteLabel(key[1]), mt.lastChange[key])
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_matches_expected Whether the last answer matched the expected values (1) or not (0).")
+	fmt.Fprintln(w, "# TYPE dns_monitor_matches_expected gauge")
+	for _, key := range sortedKeys(mt.expected) {
+		fmt.Fprintf(w, "dns_monitor_matches_expected{domain=%s,type=%s,server=%s} %g\n",
+			quoteLabel(key[0]), quoteLabel(key[1]), quoteLabel(key[2]), mt.expected[key])
+	}
+
+	fmt.Fprintln(w, "# HELP dns_monitor_notify_propagation_seconds Time from the last NOTIFY until the server served the new serial.")
+	fmt.Fprintln(w, "# TYPE dns_monitor_notify_propagation_seconds gauge")
+	for _, key := range sortedKeys(mt.propagated) {
+		fmt.Fprintf(w, "dns_monitor_notify_propagation_seconds{zone=%s,server=%s} %g\n", quoteLabel(key[0]), quoteLabel(key[1]), mt.propagated[key])
+	}
+}
+
+func (mt *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mt.Write(w)
+}
+
+// ListenAndServe starts serving /metrics on addr in the background. Errors
+// binding the address are returned immediately.
+func (mt *Metrics) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics on %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", mt)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("Warning: Metrics server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func sortedKeys[K [2]string | [3]string | [4]string | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Observe(t *testing.T) {
+	metrics := NewMetrics()
+	matches := true
+	mismatch := false
+	changedAt := time.Unix(1700000000, 0)
+
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusInitial, RTT: 3 * time.Millisecond, Matches: &matches})
+	metrics.Observe(Observation{Time: changedAt, Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusChanged, RTT: 30 * time.Millisecond, Matches: &mismatch})
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Server: "1.1.1.1:53", Status: StatusError, Rcode: "SERVFAIL"})
+
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	out := buf.String()
+
+	expected := []string{
+		`dns_monitor_query_duration_seconds_bucket{server="8.8.8.8:53",le="0.0025"} 0`,
+		`dns_monitor_query_duration_seconds_bucket{server="8.8.8.8:53",le="0.005"} 1`,
+		`dns_monitor_query_duration_seconds_bucket{server="8.8.8.8:53",le="0.05"} 2`,
+		`dns_monitor_query_duration_seconds_bucket{server="8.8.8.8:53",le="+Inf"} 2`,
+		`dns_monitor_query_duration_seconds_count{server="8.8.8.8:53"} 2`,
+		`dns_monitor_checks_total{domain="example.com",type="A",server="1.1.1.1:53",status="error"} 1`,
+		`dns_monitor_query_errors_total{server="1.1.1.1:53",rcode="SERVFAIL"} 1`,
+		`dns_monitor_changes_total{domain="example.com",type="A"} 1`,
+		`dns_monitor_last_change_timestamp_seconds{domain="example.com",type="A"} 1700000000.000`,
+		`dns_monitor_matches_expected{domain="example.com",type="A",server="8.8.8.8:53"} 0`,
+		"# TYPE dns_monitor_query_duration_seconds histogram",
+	}
+	for _, want := range expected {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("expected line %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `server="1.1.1.1:53",le=`) {
+		t.Error("failed queries should not be recorded in the duration histogram")
+	}
+}
+
+func TestMetrics_ObserveErrorClearsMatches(t *testing.T) {
+	metrics := NewMetrics()
+	matches := true
+	mismatch := false
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusUnchanged, Matches: &matches})
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Server: "1.1.1.1:53", Status: StatusUnchanged, Matches: &matches})
+	metrics.Observe(Observation{Domain: "example.org", Type: "A", Server: "8.8.8.8:53", Status: StatusUnchanged, Matches: &matches})
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Status: StatusError, Rcode: "NETWORK", Matches: &mismatch})
+
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`dns_monitor_matches_expected{domain="example.com",type="A",server="8.8.8.8:53"} 0`,
+		`dns_monitor_matches_expected{domain="example.com",type="A",server="1.1.1.1:53"} 0`,
+		`dns_monitor_matches_expected{domain="example.org",type="A",server="8.8.8.8:53"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("expected line %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetrics_ObserveFailoverRecovery(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.failover = true
+	matches := true
+	mismatch := false
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Status: StatusError, Rcode: "TIMEOUT", Matches: &mismatch})
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusUnchanged, RTT: 3 * time.Millisecond, Matches: &matches})
+
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`dns_monitor_matches_expected{domain="example.com",type="A",server=""} 1`,
+		`dns_monitor_checks_total{domain="example.com",type="A",server="",status="error"} 1`,
+		`dns_monitor_checks_total{domain="example.com",type="A",server="",status="unchanged"} 1`,
+		`dns_monitor_query_duration_seconds_count{server="8.8.8.8:53"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("expected line %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `dns_monitor_matches_expected{domain="example.com",type="A",server="8.8.8.8:53"}`) {
+		t.Errorf("failover checks should share one matches_expected series:\n%s", out)
+	}
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe(Observation{Domain: "example.com", Type: "A", Status: StatusUnchanged})
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `status="unchanged"} 1`) {
+		t.Errorf("unexpected body:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetrics_ListenAndServe(t *testing.T) {
+	metrics := NewMetrics()
+	if err := metrics.ListenAndServe("127.0.0.1:-1"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(metrics.ServeHTTP))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "# TYPE dns_monitor_changes_total counter") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if got := quoteLabel("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
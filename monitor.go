@@ -1,25 +1,121 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+const (
+	StatusInitial    = "initial"
+	StatusUnchanged  = "unchanged"
+	StatusChanged    = "changed"
+	StatusError      = "error"
+	StatusWarning    = "warning"
+	StatusPending    = "pending"
+	StatusFlapping   = "flapping"
+	StatusSuppressed = "suppressed"
+	StatusPropagated = "propagated"
+)
+
+// Observation is the outcome of checking one record on one server during a
+// tick. It is what gets printed in --json mode.
+type Observation struct {
+	Time     time.Time
+	Domain   string
+	Type     string
+	Server   string
+	Status   string
+	Values   []string
+	Previous []string
+	RTT      time.Duration
+	Error    string
+	Rcode    string
+	Message  string
+	// Matches is set when expected values are configured and tells whether
+	// the answer matched them.
+	Matches *bool
+}
+
+func (o Observation) MarshalJSON() ([]byte, error) {
+	var rtt *float64
+	if o.RTT > 0 {
+		ms := millis(o.RTT)
+		rtt = &ms
+	}
+	return json.Marshal(struct {
+		Time     time.Time `json:"time"`
+		Domain   string    `json:"domain"`
+		Type     string    `json:"type"`
+		Server   string    `json:"server,omitempty"`
+		Status   string    `json:"status"`
+		Values   []string  `json:"values,omitempty"`
+		Previous []string  `json:"previous,omitempty"`
+		RTT      *float64  `json:"rtt_ms,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		Rcode    string    `json:"rcode,omitempty"`
+		Message  string    `json:"message,omitempty"`
+		Matches  *bool     `json:"matches_expected,omitempty"`
+	}{o.Time, o.Domain, o.Type, o.Server, o.Status, o.Values, o.Previous, rtt, o.Error, o.Rcode, o.Message, o.Matches})
+}
+
 type Monitor struct {
-	config     *Config
-	dnsClient  *DNSClient
+	config      *Config
+	dnsClient   *DNSClient
 	lastRecords map[string]*DNSRecord
-	logger     *log.Logger
+	logger      *log.Logger
+	metrics     *Metrics
+	notifiers   []Notifier
+	pending     []Event
+	failures    map[string]int
+	history     *HistoryStore
+	trackers    map[string]*changeTracker
+	compare     map[string]*compareState
+	zones       map[string]*zoneSnapshot
+	notifies    chan notifyMessage
+	propagating map[string]bool
+	mailAuth    map[string]*mailAuthReport
+	fcrdns      map[string]*FCrDNSResult
+	tui         *Dashboard
+	api         *APIServer
+	control     chan func()
+	// reload re-reads the configuration on SIGHUP, or when a domains or
+	// zone file changed; only its targets are applied.
+	reload func() (*Config, error)
+	// added holds the targets added through the API, which reloads keep.
+	added []Target
+	// watched holds the modification times of the domains and zone files.
+	watched map[string]time.Time
 }
 
 func NewMonitor(config *Config) *Monitor {
+	if !colorEnabled(config.Color, os.Stdout) {
+		config.NoColor = true
+	}
+
 	dnsClient := NewDNSClient(config.Servers, 5*time.Second)
-	
+
+	var tui *Dashboard
+	if config.TUI && !config.JSON {
+		// The dashboard draws on stdout and reads keys from stdin, so
+		// both have to be a terminal.
+		if isTerminal(os.Stdout) && isTerminal(os.Stdin) {
+			tui = NewDashboard(os.Stdout, config)
+		} else {
+			log.Printf("Warning: --tui requires a terminal, using line output")
+		}
+	}
+
 	logger := log.New(os.Stdout, "", 0)
+	if config.JSON || tui != nil {
+		logger = log.New(io.Discard, "", 0)
+	}
 	if config.OutputFile != "" {
 		file, err := os.OpenFile(config.OutputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
@@ -29,157 +125,656 @@ func NewMonitor(config *Config) *Monitor {
 		}
 	}
 
-	return &Monitor{
+	var metrics *Metrics
+	if config.MetricsListen != "" {
+		metrics = NewMetrics()
+		metrics.failover = len(config.Servers) <= 1
+	}
+
+	var notifiers []Notifier
+	for _, url := range config.Webhooks {
+		notifiers = append(notifiers, NewWebhookNotifier(url, config.WebhookSecret, config.WebhookTimeout, config.WebhookRetries, logger))
+	}
+	if len(config.SlackRoutes) > 0 {
+		notifiers = append(notifiers, NewSlackNotifier(config.SlackRoutes, config.WebhookTimeout, config.WebhookRetries, logger))
+	}
+	if len(config.TeamsRoutes) > 0 {
+		notifiers = append(notifiers, NewTeamsNotifier(config.TeamsRoutes, config.WebhookTimeout, config.WebhookRetries, logger))
+	}
+	if config.OnChange != "" {
+		notifiers = append(notifiers, NewCommandNotifier(config.OnChange, config.OnChangeTimeout, logger))
+	}
+	if config.SMTP.Addr != "" {
+		notifiers = append(notifiers, NewSMTPNotifier(config.SMTP, logger))
+	}
+
+	m := &Monitor{
 		config:      config,
 		dnsClient:   dnsClient,
 		lastRecords: make(map[string]*DNSRecord),
 		logger:      logger,
+		metrics:     metrics,
+		notifiers:   notifiers,
+		tui:         tui,
+		control:     make(chan func()),
+		notifies:    make(chan notifyMessage, 16),
+	}
+	if config.Listen != "" {
+		m.api = NewAPIServer(m)
 	}
+	return m
 }
 
 func (m *Monitor) Start() error {
-	fmt.Printf("DNS Monitor Tool v%s\n", Version)
-	fmt.Printf("Monitoring %d domain(s) every %s\n", len(m.config.Domains), m.config.Interval)
-	fmt.Printf("Record type: %s\n", m.config.RecordType)
-	if len(m.config.Servers) > 0 {
-		fmt.Printf("DNS servers: %v\n", m.config.Servers)
+	restored, err := m.restoreState()
+	if err != nil {
+		return err
+	}
+
+	if m.config.HistoryFile != "" {
+		m.history, err = OpenHistoryStore(m.config.HistoryFile)
+		if err != nil {
+			return err
+		}
+		defer m.history.Close()
+	}
+
+	if m.metrics != nil {
+		if err := m.metrics.ListenAndServe(m.config.MetricsListen); err != nil {
+			return err
+		}
+	}
+
+	if m.api != nil {
+		if err := m.api.ListenAndServe(m.config.Listen); err != nil {
+			return err
+		}
+	}
+
+	if m.config.NotifyListen != "" {
+		if err := m.ListenNotify(m.config.NotifyListen); err != nil {
+			return err
+		}
+	}
+
+	if m.textOutput() {
+		fmt.Println(m.paint(fmt.Sprintf("DNS Monitor Tool v%s", Version), ColorBold))
+		fmt.Printf("Monitoring %d domain(s) every %s\n", len(m.config.targets()), m.config.Interval)
+		fmt.Printf("Record type: %s\n", m.config.RecordType)
+		if len(m.config.Servers) > 0 {
+			fmt.Printf("DNS servers: %v\n", m.config.Servers)
+		}
+		if m.config.DNSSEC {
+			fmt.Printf("DNSSEC: warning when signatures expire within %s\n", m.config.SigExpiryWarn)
+		}
+		if m.config.FCrDNS {
+			fmt.Println("FCrDNS: checking the reverse DNS of every A/AAAA value")
+		}
+		if m.config.MailAuth {
+			fmt.Println("Mail authentication: analyzing SPF, DMARC and DKIM records of TXT targets")
+		}
+		if len(m.config.TransferZones) > 0 {
+			via := ""
+			if m.config.TSIGKey != nil {
+				via = fmt.Sprintf(" with TSIG key %s", m.config.TSIGKey)
+			}
+			fmt.Printf("Zone transfers: %s from %s%s\n", strings.Join(m.config.TransferZones, ", "), m.config.transferServer(), via)
+		}
+		if m.metrics != nil {
+			fmt.Printf("Metrics: http://%s/metrics\n", m.config.MetricsListen)
+		}
+		if m.api != nil {
+			fmt.Printf("Status page: http://%s/\n", m.config.Listen)
+		}
+		if m.config.NotifyListen != "" {
+			fmt.Printf("NOTIFY: listening on %s/udp\n", m.config.NotifyListen)
+		}
+		if m.config.StateFile != "" {
+			fmt.Printf("State: %s (%d record(s) restored)\n", m.config.StateFile, restored)
+		}
+		fmt.Println("Press Ctrl+C to stop")
+		fmt.Println()
 	}
-	fmt.Println("Press Ctrl+C to stop")
-	fmt.Println()
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
 
 	ticker := time.NewTicker(m.config.Interval)
 	defer ticker.Stop()
+	defer m.closeNotifiers()
 
+	var keys <-chan byte
+	var redraw <-chan time.Time
+	if m.tui != nil {
+		if err := m.tui.Start(); err != nil {
+			return err
+		}
+		defer m.tui.Stop()
+		keys = m.tui.Keys()
+		redrawTicker := time.NewTicker(time.Second)
+		defer redrawTicker.Stop()
+		redraw = redrawTicker.C
+		m.tui.Render(time.Now())
+	}
+
+	m.filesChanged()
 	for {
 		select {
 		case <-ticker.C:
+			if m.tui != nil && m.tui.Paused() {
+				continue
+			}
+			if m.filesChanged() {
+				m.reloadTargets()
+			}
 			changed := m.checkDomains()
 			if changed && m.config.UntilChange {
-				fmt.Println("Change detected. Exiting due to --until-change mode.")
+				m.stop("Change detected. Exiting due to --until-change mode.")
+				return nil
+			}
+		case fn := <-m.control:
+			fn()
+		case n := <-m.notifies:
+			if m.tui != nil && m.tui.Paused() {
+				continue
+			}
+			if m.handleNotify(n) && m.config.UntilChange {
+				m.stop("Change detected. Exiting due to --until-change mode.")
+				return nil
+			}
+		case <-hangup:
+			m.reloadTargets()
+		case <-redraw:
+			m.tui.Render(time.Now())
+		case key := <-keys:
+			switch m.tui.HandleKey(key) {
+			case dashboardQuit:
+				m.stop("")
 				return nil
+			case dashboardRefresh:
+				if m.checkDomains() && m.config.UntilChange {
+					m.stop("Change detected. Exiting due to --until-change mode.")
+					return nil
+				}
 			}
+			m.tui.Render(time.Now())
 		case <-interrupt:
-			fmt.Println("\nReceived interrupt signal. Stopping monitor...")
+			m.stop("\nReceived interrupt signal. Stopping monitor...")
 			return nil
 		}
 	}
 }
 
+// stop restores the terminal after the dashboard, prints message unless in
+// --json mode, and prints the query statistics.
+func (m *Monitor) stop(message string) {
+	if m.tui != nil {
+		m.tui.Stop()
+	}
+	if !m.config.JSON && message != "" {
+		fmt.Println(message)
+	}
+	m.printStats()
+}
+
+// textOutput reports whether results are printed line by line, rather than
+// as JSON or on the dashboard.
+func (m *Monitor) textOutput() bool {
+	return !m.config.JSON && m.tui == nil
+}
+
 func (m *Monitor) checkDomains() bool {
+	return m.checkTargets(m.config.targets(), m.config.TransferZones, true)
+}
+
+// checkTargets runs one round of checks of targets and zones, including the
+// configured DNSSEC, reverse DNS and mail authentication checks if full is
+// set, and reports whether anything changed.
+func (m *Monitor) checkTargets(targets []Target, zones []string, full bool) bool {
+	if len(targets) == 0 && len(zones) == 0 {
+		return false
+	}
+
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	hasChanges := false
+	servers := m.config.queryServers()
 
-	if len(m.config.Domains) == 1 {
-		changed := m.checkSingleDomain(m.config.Domains[0], timestamp)
+	if len(targets) == 1 && len(servers) == 1 {
+		changed := m.checkSingleDomain(targets[0], timestamp)
 		if changed {
 			hasChanges = true
 		}
-	} else {
-		fmt.Printf("[%s]\n", timestamp)
-		for i, domain := range m.config.Domains {
-			changed := m.checkDomainInGroup(domain, i == len(m.config.Domains)-1)
-			if changed {
-				hasChanges = true
+	} else if len(targets) > 0 {
+		if m.textOutput() {
+			fmt.Println(m.paint("["+timestamp+"]", ColorBold))
+		}
+		total := len(targets) * len(servers)
+		n := 0
+		for _, target := range targets {
+			for _, server := range servers {
+				n++
+				changed := m.checkDomainInGroup(target, server, n == total)
+				if changed {
+					hasChanges = true
+				}
 			}
 		}
-		fmt.Println()
+		if m.textOutput() {
+			fmt.Println()
+		}
 	}
 
+	if full && m.config.DNSSEC && m.checkDNSSEC(timestamp) {
+		hasChanges = true
+	}
+	if full && m.config.FCrDNS && m.checkFCrDNS(timestamp, targets) {
+		hasChanges = true
+	}
+	if full && m.config.MailAuth && m.checkMailAuth(timestamp, targets) {
+		hasChanges = true
+	}
+	if len(zones) > 0 && m.checkTransfers(timestamp, zones) {
+		hasChanges = true
+	}
+
+	m.flushEvents()
+	m.saveState()
+	if m.tui != nil {
+		m.tui.CheckDone(time.Now())
+		m.tui.Render(time.Now())
+	}
 	return hasChanges
 }
 
-func (m *Monitor) checkSingleDomain(domain, timestamp string) bool {
-	record, err := m.dnsClient.Query(domain, m.config.RecordType)
+// restoreState loads the last records and comparison state from the state
+// file, if one is configured, so differences found by the first check are
+// reported as changes and union pools need not be learned again. It returns
+// the number of records restored.
+func (m *Monitor) restoreState() (int, error) {
+	if m.config.StateFile == "" {
+		return 0, nil
+	}
+	records, compare, err := loadState(m.config.StateFile)
 	if err != nil {
-		message := fmt.Sprintf("[%s] %s (%s) - ERROR: %v", timestamp, domain, m.config.RecordType, err)
-		m.printColored(message, ColorYellow)
-		m.logger.Println(message)
-		return false
+		return 0, fmt.Errorf("failed to load state: %v", err)
+	}
+	for key, record := range records {
+		m.lastRecords[key] = record
+	}
+	if len(compare) > 0 {
+		m.compare = compare
+	}
+	return len(records), nil
+}
+
+// saveState writes the last records and comparison state to the state file,
+// if one is configured.
+func (m *Monitor) saveState() {
+	if m.config.StateFile == "" {
+		return
+	}
+	if err := saveState(m.config.StateFile, m.lastRecords, m.compare); err != nil {
+		log.Printf("Warning: Failed to save state: %v", err)
+	}
+}
+
+// observe queries target on server (or through failover when server is
+// empty), compares the answer with the previous one and remembers it.
+func (m *Monitor) observe(target Target, server string) Observation {
+	domain := target.Domain
+	obs := Observation{
+		Time:   time.Now(),
+		Domain: domain,
+		Type:   target.Type,
+		Server: server,
+	}
+
+	var record *DNSRecord
+	var err error
+	if server == "" {
+		record, err = m.dnsClient.Query(domain, target.Type)
+	} else {
+		record, err = m.dnsClient.QueryServer(server, domain, target.Type)
+	}
+	if err != nil {
+		obs.Status = StatusError
+		obs.Error = err.Error()
+		obs.Rcode = errorCode(err)
+		if len(target.Expected) > 0 {
+			// An unanswered query cannot hold the expected values.
+			matches := false
+			obs.Matches = &matches
+		}
+		return obs
+	}
+
+	obs.Server = record.Server
+	obs.RTT = record.RTT
+	if len(target.Expected) > 0 {
+		var matches bool
+		if m.config.comparePolicy(domain).Mode == CompareContains {
+			matches = record.Contains(target.Expected)
+		} else {
+			matches = record.Matches(target.Expected)
+		}
+		obs.Matches = &matches
 	}
 
-	key := fmt.Sprintf("%s:%s", domain, m.config.RecordType)
+	key := target.Key()
+	if server != "" {
+		key += "@" + server
+	}
+	record, learning := m.compareRecord(key, record, target.Expected)
+	obs.Values = record.Values
 	lastRecord, exists := m.lastRecords[key]
 
-	if !exists {
-		message := fmt.Sprintf("[%s] %s (%s) - Initial: %s", timestamp, domain, m.config.RecordType, record.String())
+	switch {
+	case !exists:
+		obs.Status = StatusInitial
+	case learning != "":
+		obs.Status = StatusUnchanged
+		obs.Message = learning
+	case !record.Equals(lastRecord):
+		obs.Previous = lastRecord.Values
+		obs.Status, obs.Message = m.classifyChange(key, record, obs.Time)
+		if obs.Status == StatusPending {
+			return obs
+		}
+	default:
+		obs.Status = StatusUnchanged
+		var reported *DNSRecord
+		obs.Message, reported = m.settle(key, obs.Time)
+		if reported != nil && !record.Equals(reported) {
+			// Suppressed changes left notifiers with an older value.
+			obs.Status = StatusChanged
+			obs.Previous = reported.Values
+		}
+	}
+	m.lastRecords[key] = record
+	return obs
+}
+
+func (m *Monitor) checkSingleDomain(target Target, timestamp string) bool {
+	domain := target.Domain
+	obs := m.observe(target, "")
+	m.emit(obs)
+
+	switch obs.Status {
+	case StatusError:
+		message := fmt.Sprintf("[%s] %s (%s) - ERROR: %s", timestamp, domain, obs.Type, obs.Error)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+
+	case StatusInitial:
+		message := fmt.Sprintf("[%s] %s (%s) - Initial: %s (%s)", timestamp, domain, obs.Type, formatValues(obs.Values), formatRTT(obs.RTT))
 		m.printColored(message, ColorGreen)
 		m.logger.Println(message)
-		m.lastRecords[key] = record
 		return false
-	}
 
-	if !record.Equals(lastRecord) {
-		message := fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED:", timestamp, domain, m.config.RecordType)
+	case StatusChanged:
+		message := fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED:", timestamp, domain, obs.Type)
+		if obs.Message != "" {
+			message = fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED (%s):", timestamp, domain, obs.Type, obs.Message)
+		}
 		m.printColored(message, ColorRed)
 		m.logger.Println(message)
 
-		beforeMsg := fmt.Sprintf("  Before: %s", lastRecord.String())
-		afterMsg := fmt.Sprintf("  After:  %s", record.String())
-		
+		beforeMsg := fmt.Sprintf("  Before: %s", formatValues(obs.Previous))
+		afterMsg := fmt.Sprintf("  After:  %s (%s)", formatValues(obs.Values), formatRTT(obs.RTT))
+
 		m.printColored(beforeMsg, ColorRed)
 		m.printColored(afterMsg, ColorBlue)
 		m.logger.Println(beforeMsg)
 		m.logger.Println(afterMsg)
+		return true
 
-		m.lastRecords[key] = record
+	case StatusPending:
+		message := fmt.Sprintf("[%s] %s (%s) - Unconfirmed: %s, %s (current: %s, %s)", timestamp, domain, obs.Type, formatValues(obs.Values), obs.Message, formatValues(obs.Previous), formatRTT(obs.RTT))
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+
+	case StatusFlapping:
+		message := fmt.Sprintf("[%s] %s (%s) - FLAPPING: %s", timestamp, domain, obs.Type, obs.Message)
+		m.printColored(message, ColorRed)
+		m.logger.Println(message)
 		return true
+
+	case StatusSuppressed:
+		message := fmt.Sprintf("[%s] %s (%s) - Flapping: %s (%s)", timestamp, domain, obs.Type, formatValues(obs.Values), formatRTT(obs.RTT))
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
 	}
 
-	message := fmt.Sprintf("[%s] %s (%s) - No change: %s", timestamp, domain, m.config.RecordType, record.String())
+	detail := formatRTT(obs.RTT)
+	if obs.Message != "" {
+		detail = obs.Message + ", " + detail
+	}
+	message := fmt.Sprintf("[%s] %s (%s) - No change: %s (%s)", timestamp, domain, obs.Type, formatValues(obs.Values), detail)
 	m.printColored(message, ColorGreen)
 	m.logger.Println(message)
 	return false
 }
 
-func (m *Monitor) checkDomainInGroup(domain string, isLast bool) bool {
-	record, err := m.dnsClient.Query(domain, m.config.RecordType)
-	if err != nil {
-		prefix := "├─"
-		if isLast {
-			prefix = "└─"
-		}
-		message := fmt.Sprintf("%s %s (%s): ERROR - %v", prefix, domain, m.config.RecordType, err)
-		m.printColored(message, ColorYellow)
-		m.logger.Printf("ERROR: %s (%s) - %v", domain, m.config.RecordType, err)
-		return false
-	}
-
-	key := fmt.Sprintf("%s:%s", domain, m.config.RecordType)
-	lastRecord, exists := m.lastRecords[key]
+func (m *Monitor) checkDomainInGroup(target Target, server string, isLast bool) bool {
+	domain := target.Domain
+	obs := m.observe(target, server)
+	m.emit(obs)
 
 	prefix := "├─"
 	if isLast {
 		prefix = "└─"
 	}
+	label := fmt.Sprintf("%s (%s)", domain, obs.Type)
+	if server != "" {
+		label += " @" + server
+	}
 
-	if !exists {
-		message := fmt.Sprintf("%s %s (%s): %s (initial)", prefix, domain, m.config.RecordType, record.String())
+	switch obs.Status {
+	case StatusError:
+		message := fmt.Sprintf("%s %s: ERROR - %s", prefix, label, obs.Error)
+		m.printColored(message, ColorYellow)
+		m.logger.Printf("ERROR: %s - %s", label, obs.Error)
+		return false
+
+	case StatusInitial:
+		message := fmt.Sprintf("%s %s: %s (initial, %s)", prefix, label, formatValues(obs.Values), formatRTT(obs.RTT))
 		m.printColored(message, ColorGreen)
-		m.logger.Printf("INITIAL: %s (%s) - %s", domain, m.config.RecordType, record.String())
-		m.lastRecords[key] = record
+		m.logger.Printf("INITIAL: %s - %s", label, formatValues(obs.Values))
 		return false
-	}
 
-	if !record.Equals(lastRecord) {
-		message := fmt.Sprintf("%s %s (%s): %s → %s (CHANGED)", prefix, domain, m.config.RecordType, lastRecord.String(), record.String())
+	case StatusChanged:
+		detail := formatRTT(obs.RTT)
+		if obs.Message != "" {
+			detail = obs.Message + ", " + detail
+		}
+		message := fmt.Sprintf("%s %s: %s → %s (CHANGED, %s)", prefix, label, formatValues(obs.Previous), formatValues(obs.Values), detail)
 		m.printColored(message, ColorRed)
-		m.logger.Printf("CHANGE: %s (%s) - %s → %s", domain, m.config.RecordType, lastRecord.String(), record.String())
-		m.lastRecords[key] = record
+		m.logger.Printf("CHANGE: %s - %s → %s", label, formatValues(obs.Previous), formatValues(obs.Values))
 		return true
+
+	case StatusPending:
+		message := fmt.Sprintf("%s %s: %s (unconfirmed %s, %s, %s)", prefix, label, formatValues(obs.Previous), formatValues(obs.Values), obs.Message, formatRTT(obs.RTT))
+		m.printColored(message, ColorYellow)
+		m.logger.Printf("UNCONFIRMED: %s - %s, %s", label, formatValues(obs.Values), obs.Message)
+		return false
+
+	case StatusFlapping:
+		message := fmt.Sprintf("%s %s: %s (FLAPPING, %s)", prefix, label, formatValues(obs.Values), obs.Message)
+		m.printColored(message, ColorRed)
+		m.logger.Printf("FLAPPING: %s - %s", label, obs.Message)
+		return true
+
+	case StatusSuppressed:
+		message := fmt.Sprintf("%s %s: %s (flapping, %s)", prefix, label, formatValues(obs.Values), formatRTT(obs.RTT))
+		m.printColored(message, ColorYellow)
+		return false
 	}
 
-	message := fmt.Sprintf("%s %s (%s): %s (no change)", prefix, domain, m.config.RecordType, record.String())
+	detail := formatRTT(obs.RTT)
+	if obs.Message != "" {
+		detail = obs.Message + ", " + detail
+		m.logger.Printf("NO CHANGE: %s - %s", label, obs.Message)
+	}
+	message := fmt.Sprintf("%s %s: %s (no change, %s)", prefix, label, formatValues(obs.Values), detail)
 	m.printColored(message, ColorGreen)
 	return false
 }
 
+// emit publishes an observation to the metrics, queues it for the notifiers
+// when it is a change, error or recovery and, in --json mode, writes it to
+// stdout as a single JSON line.
+func (m *Monitor) emit(obs Observation) {
+	if m.failures == nil {
+		m.failures = make(map[string]int)
+	}
+	failures := m.failures[m.failureKey(obs)]
+	if obs.Status == StatusError {
+		m.failures[m.failureKey(obs)]++
+	} else {
+		delete(m.failures, m.failureKey(obs))
+	}
+
+	if m.metrics != nil {
+		m.metrics.Observe(obs)
+	}
+	m.recordHistory(obs)
+	if m.tui != nil {
+		m.tui.Observe(obs)
+	}
+	var events []Event
+	if obs.Status != StatusError && failures > 0 {
+		events = append(events, recoveryEvent(obs, failures))
+	}
+	if event, ok := eventFromObservation(obs); ok {
+		if event.Kind == EventError {
+			event.Failures = m.failures[m.failureKey(obs)]
+		}
+		events = append(events, event)
+	}
+	if m.api != nil {
+		m.api.Observe(obs)
+		for _, event := range events {
+			m.api.Publish(event)
+		}
+	}
+	if len(m.notifiers) > 0 {
+		m.pending = append(m.pending, events...)
+	}
+	if !m.config.JSON {
+		return
+	}
+	b, err := json.Marshal(obs)
+	if err != nil {
+		log.Printf("Warning: Failed to encode observation: %v", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// recordHistory appends initial answers and changes to the history file.
+func (m *Monitor) recordHistory(obs Observation) {
+	if m.history == nil || (obs.Status != StatusInitial && obs.Status != StatusChanged) {
+		return
+	}
+	entry := HistoryEntry{
+		Time:   obs.Time,
+		Domain: obs.Domain,
+		Type:   obs.Type,
+		Status: obs.Status,
+		Before: obs.Previous,
+		After:  obs.Values,
+	}
+	if len(m.config.Servers) > 1 {
+		entry.Server = obs.Server
+	}
+	if err := m.history.Record(entry); err != nil {
+		log.Printf("Warning: Failed to record history: %v", err)
+	}
+}
+
+// failureKey identifies the check obs belongs to when counting consecutive
+// failures. Servers are only told apart when each one is queried separately.
+func (m *Monitor) failureKey(obs Observation) string {
+	key := obs.Domain + ":" + obs.Type
+	if len(m.config.Servers) > 1 {
+		key += "@" + obs.Server
+	}
+	return key
+}
+
+// flushEvents hands the events collected during the tick to every notifier.
+func (m *Monitor) flushEvents() {
+	if len(m.pending) == 0 {
+		return
+	}
+	for _, notifier := range m.notifiers {
+		notifier.Notify(m.pending)
+	}
+	m.pending = nil
+}
+
+func (m *Monitor) closeNotifiers() {
+	for _, notifier := range m.notifiers {
+		notifier.Close()
+	}
+}
+
+func (m *Monitor) printStats() {
+	stats := m.dnsClient.Stats()
+	if len(stats) == 0 {
+		return
+	}
+
+	if m.config.JSON {
+		b, err := json.Marshal(struct {
+			Stats []ServerStats `json:"stats"`
+		}{stats})
+		if err == nil {
+			fmt.Println(string(b))
+		}
+		return
+	}
+
+	fmt.Println()
+	fmt.Println(m.paint("Query statistics:", ColorBold))
+	printStats(os.Stdout, stats)
+}
+
+func formatValues(values []string) string {
+	return (&DNSRecord{Values: values}).String()
+}
+
+// printColored writes message to stdout. Nothing is printed in --json mode,
+// where stdout carries only JSON lines, or when the dashboard is shown.
 func (m *Monitor) printColored(message string, color string) {
+	if !m.textOutput() {
+		return
+	}
 	if m.config.NoColor {
 		fmt.Println(message)
-	} else {
-		fmt.Printf("%s%s%s\n", color, message, ColorReset)
+		return
 	}
-}
\ No newline at end of file
+
+	// Dim a leading "[timestamp]" so the record stands out.
+	if strings.HasPrefix(message, "[") {
+		if end := strings.Index(message, "] "); end > 0 {
+			fmt.Println(m.paint(message[:end+1], ColorDim) + " " + m.paint(message[end+2:], color))
+			return
+		}
+	}
+	fmt.Println(m.paint(message, color))
+}
+
+// paint wraps text in the given color, unless colors are disabled.
+func (m *Monitor) paint(text, color string) string {
+	if m.config.NoColor {
+		return text
+	}
+	return color + text + ColorReset
+}
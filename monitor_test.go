@@ -2,11 +2,32 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// newTestMonitor returns a monitor for config that queries server, or the
+// system's servers when server is "", and logs to the returned buffer.
+func newTestMonitor(t *testing.T, config *Config, server string) (*Monitor, *bytes.Buffer) {
+	t.Helper()
+	var servers []string
+	if server != "" {
+		servers = []string{server}
+	}
+	var buf bytes.Buffer
+	return &Monitor{
+		config:      config,
+		dnsClient:   NewDNSClient(servers, 2*time.Second),
+		lastRecords: make(map[string]*DNSRecord),
+		logger:      log.New(&buf, "", 0),
+	}, &buf
+}
+
 func TestNewMonitor(t *testing.T) {
 	config := &Config{
 		Domains:    []string{"example.com"},
@@ -106,7 +127,7 @@ func TestMonitor_checkDomainInGroup(t *testing.T) {
 	key := "example.com:A"
 	monitor.lastRecords[key] = record1
 
-	changed := monitor.checkDomainInGroup("example.com", true)
+	changed := monitor.checkDomainInGroup(config.targets()[0], "", true)
 
 	if !changed {
 		t.Error("Expected change detection when record is different")
@@ -134,4 +155,135 @@ func TestColors(t *testing.T) {
 	if ColorBlue != "\033[34m" {
 		t.Errorf("ColorBlue should be \\033[34m, got %s", ColorBlue)
 	}
-}
\ No newline at end of file
+}
+
+func TestMonitor_observe(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", NoColor: true, Expected: []string{"192.0.2.1"}}, server)
+
+	obs := monitor.observe(monitor.config.targets()[0], server)
+	if obs.Status != StatusInitial || obs.Server != server || obs.RTT <= 0 {
+		t.Errorf("unexpected initial observation: %+v", obs)
+	}
+	if obs.Matches == nil || !*obs.Matches {
+		t.Error("expected the initial answer to match the expected value")
+	}
+	if _, ok := monitor.lastRecords["example.com:A@"+server]; !ok {
+		t.Error("per-server observations should be keyed by server")
+	}
+
+	if obs := monitor.observe(monitor.config.targets()[0], server); obs.Status != StatusUnchanged {
+		t.Errorf("expected unchanged, got %s", obs.Status)
+	}
+
+	answer.set([]byte{192, 0, 2, 2})
+	obs = monitor.observe(monitor.config.targets()[0], server)
+	if obs.Status != StatusChanged {
+		t.Fatalf("expected changed, got %s", obs.Status)
+	}
+	if len(obs.Previous) != 1 || obs.Previous[0] != "192.0.2.1" || obs.Values[0] != "192.0.2.2" {
+		t.Errorf("unexpected before/after values: %v -> %v", obs.Previous, obs.Values)
+	}
+	if obs.Matches == nil || *obs.Matches {
+		t.Error("expected the changed answer not to match the expected value")
+	}
+
+	obs = monitor.observe(Target{Domain: "missing.example.com", Type: "A"}, "127.0.0.1:1")
+	if obs.Status != StatusError || obs.Rcode != "NETWORK" {
+		t.Errorf("expected network error, got %+v", obs)
+	}
+	if obs.Matches != nil {
+		t.Error("errors of targets without expected values should not set matches")
+	}
+
+	obs = monitor.observe(Target{Domain: "example.com", Type: "A", Expected: []string{"192.0.2.1"}}, "127.0.0.1:1")
+	if obs.Status != StatusError || obs.Matches == nil || *obs.Matches {
+		t.Errorf("expected a failed query not to match the expected value, got %+v", obs)
+	}
+}
+
+func TestObservation_JSON(t *testing.T) {
+	obs := Observation{
+		Time:   time.Date(2025, 6, 5, 15, 30, 45, 0, time.UTC),
+		Domain: "example.com",
+		Type:   "A",
+		Server: "8.8.8.8:53",
+		Status: StatusChanged,
+		Values: []string{"192.0.2.2"},
+		RTT:    12500 * time.Microsecond,
+	}
+	b, err := json.Marshal(obs)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	expected := `{"time":"2025-06-05T15:30:45Z","domain":"example.com","type":"A","server":"8.8.8.8:53","status":"changed","values":["192.0.2.2"],"rtt_ms":12.5}`
+	if string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+}
+
+type recordingNotifier struct {
+	batches [][]Event
+	closed  bool
+}
+
+func (n *recordingNotifier) Notify(events []Event) { n.batches = append(n.batches, events) }
+func (n *recordingNotifier) Close()                { n.closed = true }
+
+func TestMonitor_notifiers(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+
+	notifier := &recordingNotifier{}
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"a.example.com", "b.example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true, JSON: true}, server)
+	monitor.notifiers = []Notifier{notifier}
+
+	monitor.checkDomains()
+	if len(notifier.batches) != 0 {
+		t.Errorf("initial observations should not notify, got %v", notifier.batches)
+	}
+
+	answer.set([]byte{192, 0, 2, 2})
+	monitor.checkDomains()
+	if len(notifier.batches) != 1 || len(notifier.batches[0]) != 2 {
+		t.Fatalf("expected both changes in a single batch, got %v", notifier.batches)
+	}
+	if e := notifier.batches[0][0]; e.Kind != EventChange || e.Domain != "a.example.com" || e.Added[0] != "192.0.2.2" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	monitor.closeNotifiers()
+	if !notifier.closed {
+		t.Error("expected notifiers to be closed")
+	}
+}
+
+func TestMonitor_consecutiveFailures(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := startStubServer(t, func(q *dnsMessage) *dnsMessage {
+		if fail.Load() {
+			return &dnsMessage{Rcode: RcodeServFail}
+		}
+		return &dnsMessage{Answers: []dnsRR{mustRR(t, q.Questions[0].Name, TypeA, []byte{192, 0, 2, 1})}}
+	})
+
+	notifier := &recordingNotifier{}
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true}, server)
+	monitor.notifiers = []Notifier{notifier}
+
+	monitor.checkDomains()
+	monitor.checkDomains()
+	fail.Store(false)
+	monitor.checkDomains()
+	fail.Store(true)
+	monitor.checkDomains()
+
+	var got []string
+	for _, batch := range notifier.batches {
+		got = append(got, fmt.Sprintf("%s:%d", batch[0].Kind, batch[0].Failures))
+	}
+	if want := "error:1 error:2 recovered:2 error:1"; strings.Join(got, " ") != want {
+		t.Errorf("expected events %s, got %v", want, got)
+	}
+}
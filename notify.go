@@ -0,0 +1,87 @@
+package main
+
+import "time"
+
+const (
+	EventChange    = "change"
+	EventError     = "error"
+	EventFlapping  = "flapping"
+	EventRecovered = "recovered"
+)
+
+// Event is a change or error detected during a tick, as delivered to
+// notifiers. Failures counts the consecutive failed checks of an error, or
+// those that preceded a recovery.
+type Event struct {
+	Kind      string    `json:"event"`
+	Domain    string    `json:"domain"`
+	Type      string    `json:"type"`
+	Server    string    `json:"server,omitempty"`
+	Before    []string  `json:"before,omitempty"`
+	After     []string  `json:"after,omitempty"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier delivers events. Notify is called once per tick with every event
+// of that tick, from the polling loop, so it must not block. Close flushes
+// pending deliveries on shutdown.
+type Notifier interface {
+	Notify(events []Event)
+	Close()
+}
+
+// eventFromObservation returns the event to notify for obs, if any.
+func eventFromObservation(obs Observation) (Event, bool) {
+	event := Event{
+		Domain:    obs.Domain,
+		Type:      obs.Type,
+		Server:    obs.Server,
+		Timestamp: obs.Time,
+	}
+
+	switch obs.Status {
+	case StatusChanged:
+		event.Kind = EventChange
+		event.Before = obs.Previous
+		event.After = obs.Values
+		event.Added, event.Removed = (&DNSRecord{Values: obs.Previous}).Diff(&DNSRecord{Values: obs.Values})
+		event.Message = obs.Message
+		return event, true
+	case StatusError:
+		event.Kind = EventError
+		event.Error = obs.Error
+		return event, true
+	case StatusFlapping:
+		event.Kind = EventFlapping
+		event.Before = obs.Previous
+		event.After = obs.Values
+		event.Message = obs.Message
+		return event, true
+	}
+	return Event{}, false
+}
+
+// recoveryEvent returns the event announcing that the check of obs succeeded
+// again after failures consecutive failed checks.
+func recoveryEvent(obs Observation, failures int) Event {
+	return Event{
+		Kind:      EventRecovered,
+		Domain:    obs.Domain,
+		Type:      obs.Type,
+		Server:    obs.Server,
+		After:     obs.Values,
+		Failures:  failures,
+		Timestamp: obs.Time,
+	}
+}
+
+// firstFailure reports whether event is sent by notifiers that announce a
+// failing check once and then its recovery, rather than on every round.
+func firstFailure(event Event) bool {
+	return event.Kind != EventError || event.Failures == 1
+}
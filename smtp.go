@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTPConfig describes the mail server and envelope used for email
+// notifications.
+type SMTPConfig struct {
+	Addr       string
+	StartTLS   bool
+	Username   string
+	Password   string
+	From       string
+	To         []string
+	Digest     time.Duration
+	ErrorAfter int
+	Timeout    time.Duration
+}
+
+// SMTPNotifier mails changes and persistent errors. Events are collected for
+// the digest window following the first one, so a change propagating to many
+// domains results in a single message.
+type SMTPNotifier struct {
+	config SMTPConfig
+	logger *log.Logger
+	queue  chan []Event
+	done   sync.WaitGroup
+}
+
+func NewSMTPNotifier(config SMTPConfig, logger *log.Logger) *SMTPNotifier {
+	n := &SMTPNotifier{
+		config: config,
+		logger: logger,
+		queue:  make(chan []Event, webhookQueueSize),
+	}
+
+	n.done.Add(1)
+	go n.run()
+	return n
+}
+
+func (n *SMTPNotifier) run() {
+	defer n.done.Done()
+
+	var pending []Event
+	var digest <-chan time.Time
+	for {
+		select {
+		case events, ok := <-n.queue:
+			if !ok {
+				n.send(pending)
+				return
+			}
+			if len(pending) == 0 {
+				digest = time.After(n.config.Digest)
+			}
+			pending = append(pending, events...)
+		case <-digest:
+			n.send(pending)
+			pending = nil
+			digest = nil
+		}
+	}
+}
+
+// Notify queues changes, errors once they have persisted for ErrorAfter
+// consecutive checks, and the recovery of those errors.
+func (n *SMTPNotifier) Notify(events []Event) {
+	var selected []Event
+	for _, event := range events {
+		if event.Kind == EventError && event.Failures != n.config.ErrorAfter {
+			continue
+		}
+		if event.Kind == EventRecovered && event.Failures < n.config.ErrorAfter {
+			continue
+		}
+		selected = append(selected, event)
+	}
+	if len(selected) == 0 {
+		return
+	}
+
+	select {
+	case n.queue <- selected:
+	default:
+		n.logger.Printf("SMTP: queue full, dropping %d event(s)", len(selected))
+	}
+}
+
+// Close stops accepting events and sends any digest still being collected.
+func (n *SMTPNotifier) Close() {
+	close(n.queue)
+	n.done.Wait()
+}
+
+func (n *SMTPNotifier) send(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	if err := n.sendMail(n.message(events, time.Now())); err != nil {
+		n.logger.Printf("SMTP: sending %d event(s) via %s failed: %v", len(events), n.config.Addr, err)
+	}
+}
+
+// message renders events as a plain text email, including headers.
+func (n *SMTPNotifier) message(events []Event, now time.Time) []byte {
+	var id [12]byte
+	rand.Read(id[:])
+	host, _, _ := net.SplitHostPort(n.config.Addr)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", summarizeEvents(events))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), host)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+
+	for _, event := range events {
+		timestamp := event.Timestamp.Format("2006-01-02 15:04:05")
+		switch event.Kind {
+		case EventError:
+			fmt.Fprintf(&b, "[%s] %s failed %d times in a row:\r\n    %s\r\n\r\n", timestamp, eventTitle(event), event.Failures, event.Error)
+			continue
+		case EventFlapping:
+			fmt.Fprintf(&b, "[%s] %s is flapping: %s\r\n    now %s\r\n\r\n", timestamp, eventTitle(event), event.Message, strings.Join(event.After, ", "))
+			continue
+		case EventRecovered:
+			fmt.Fprintf(&b, "[%s] %s recovered after %s\r\n\r\n", timestamp, eventTitle(event), plural(event.Failures, "failed check"))
+			continue
+		}
+		fmt.Fprintf(&b, "[%s] %s changed:\r\n", timestamp, eventTitle(event))
+		for _, line := range strings.Split(eventDiff(event), "\n") {
+			fmt.Fprintf(&b, "    %s\r\n", line)
+		}
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+func (n *SMTPNotifier) sendMail(msg []byte) error {
+	host, _, err := net.SplitHostPort(n.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", n.config.Addr, n.config.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.config.Timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.config.StartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if n.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, host)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
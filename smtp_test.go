@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMail struct {
+	from string
+	to   []string
+	auth string
+	data string
+}
+
+// startFakeSMTPServer accepts mail on a loopback port and returns its address
+// along with a function returning the messages received so far.
+func startFakeSMTPServer(t *testing.T) (string, func() []fakeMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var mails []fakeMail
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+				var mail fakeMail
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimRight(line, "\r\n")
+					cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+					switch cmd {
+					case "EHLO", "HELO":
+						reply("250-localhost")
+						reply("250 AUTH PLAIN")
+					case "AUTH":
+						parts := strings.Fields(line)
+						decoded, _ := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+						mail.auth = string(decoded)
+						reply("235 2.7.0 Authentication successful")
+					case "MAIL":
+						mail.from = line[len("MAIL FROM:"):]
+						reply("250 OK")
+					case "RCPT":
+						mail.to = append(mail.to, line[len("RCPT TO:"):])
+						reply("250 OK")
+					case "DATA":
+						reply("354 End data with <CR><LF>.<CR><LF>")
+						var data strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							data.WriteString(l)
+						}
+						mail.data = data.String()
+						mu.Lock()
+						mails = append(mails, mail)
+						mu.Unlock()
+						mail = fakeMail{}
+						reply("250 OK")
+					case "QUIT":
+						reply("221 Bye")
+						return
+					default:
+						reply("502 Command not implemented")
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), func() []fakeMail {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]fakeMail(nil), mails...)
+	}
+}
+
+func TestSMTPNotifier_Digest(t *testing.T) {
+	addr, received := startFakeSMTPServer(t)
+
+	var logs bytes.Buffer
+	n := NewSMTPNotifier(SMTPConfig{
+		Addr:       addr,
+		Username:   "monitor",
+		Password:   "s3cret",
+		From:       "dns-monitor@example.com",
+		To:         []string{"ops@example.com", "noc@example.com"},
+		Digest:     200 * time.Millisecond,
+		ErrorAfter: 3,
+		Timeout:    2 * time.Second,
+	}, log.New(&logs, "", 0))
+
+	for i := 0; i < 30; i++ {
+		n.Notify([]Event{{Kind: EventChange, Domain: "example.com", Type: "A", Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2"}, Added: []string{"192.0.2.2"}, Removed: []string{"192.0.2.1"}}})
+	}
+	n.Notify([]Event{{Kind: EventError, Domain: "flaky.example.com", Type: "A", Error: "TIMEOUT", Failures: 1}})
+	n.Notify([]Event{{Kind: EventError, Domain: "down.example.com", Type: "A", Error: "SERVFAIL", Failures: 3}})
+	n.Notify([]Event{{Kind: EventRecovered, Domain: "flaky.example.com", Type: "A", Failures: 1}})
+	n.Notify([]Event{{Kind: EventRecovered, Domain: "back.example.com", Type: "A", Failures: 4}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	n.Close()
+
+	mails := received()
+	if len(mails) != 1 {
+		t.Fatalf("expected a single digest mail, got %d (log: %s)", len(mails), logs.String())
+	}
+	mail := mails[0]
+	if mail.from != "<dns-monitor@example.com>" || len(mail.to) != 2 {
+		t.Errorf("unexpected envelope: %s -> %v", mail.from, mail.to)
+	}
+	if mail.auth != "\x00monitor\x00s3cret" {
+		t.Errorf("unexpected credentials: %q", mail.auth)
+	}
+	if !strings.Contains(mail.data, "Subject: DNS Monitor: 30 changes, 1 error, 1 recovered\r\n") {
+		t.Errorf("unexpected subject in %q", mail.data)
+	}
+	if !strings.Contains(mail.data, "example.com (A) changed:\r\n    - 192.0.2.1\r\n    + 192.0.2.2\r\n") {
+		t.Errorf("expected diff in body, got %q", mail.data)
+	}
+	if !strings.Contains(mail.data, "down.example.com (A) failed 3 times in a row:\r\n    SERVFAIL") {
+		t.Errorf("expected persistent error in body, got %q", mail.data)
+	}
+	if !strings.Contains(mail.data, "back.example.com (A) recovered after 4 failed checks\r\n") {
+		t.Errorf("expected recovery in body, got %q", mail.data)
+	}
+	if strings.Contains(mail.data, "flaky.example.com") {
+		t.Error("transient errors and their recovery should not be mailed")
+	}
+}
+
+func TestSMTPNotifier_FlushOnClose(t *testing.T) {
+	addr, received := startFakeSMTPServer(t)
+
+	var logs bytes.Buffer
+	n := NewSMTPNotifier(SMTPConfig{
+		Addr:       addr,
+		From:       "dns-monitor@example.com",
+		To:         []string{"ops@example.com"},
+		Digest:     time.Hour,
+		ErrorAfter: 1,
+		Timeout:    2 * time.Second,
+	}, log.New(&logs, "", 0))
+
+	n.Notify([]Event{{Kind: EventError, Domain: "example.com", Type: "A", Error: "TIMEOUT", Failures: 1}})
+	n.Close()
+
+	if mails := received(); len(mails) != 1 || mails[0].auth != "" {
+		t.Errorf("expected pending digest to be sent on close without auth, got %+v (log: %s)", mails, logs.String())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EventObservation is the SSE event name of every check result; change,
+	// error and flapping events are sent under their Event kind.
+	EventObservation = "observation"
+
+	maxSSEReplay      = 1000
+	sseSubscriberSize = 256
+	sseHeartbeat      = 15 * time.Second
+)
+
+// sseMessage is one message on the /events stream.
+type sseMessage struct {
+	ID     uint64
+	Name   string
+	Domain string
+	Type   string
+	Data   []byte
+}
+
+func (msg sseMessage) write(w http.ResponseWriter) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Name, msg.Data)
+	return err
+}
+
+// sseFilter selects messages by domain pattern, record type and event name.
+// An empty list matches everything.
+type sseFilter struct {
+	Domains []string
+	Types   []string
+	Kinds   []string
+}
+
+// queryList returns the values of key, splitting comma-separated values.
+func queryList(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func parseSSEFilter(query url.Values) (sseFilter, error) {
+	var filter sseFilter
+	for _, pattern := range queryList(query, "domain") {
+		filter.Domains = append(filter.Domains, strings.ToLower(strings.TrimSuffix(pattern, ".")))
+	}
+	for _, recordType := range queryList(query, "type") {
+		filter.Types = append(filter.Types, strings.ToUpper(recordType))
+	}
+	for _, kind := range queryList(query, "kind") {
+		kind = strings.ToLower(kind)
+		switch kind {
+		case EventObservation, EventChange, EventError, EventFlapping, EventRecovered:
+		default:
+			return filter, fmt.Errorf("unknown event kind: %s (use observation, change, error, flapping or recovered)", kind)
+		}
+		filter.Kinds = append(filter.Kinds, kind)
+	}
+	return filter, nil
+}
+
+func (f sseFilter) Match(msg sseMessage) bool {
+	if len(f.Kinds) > 0 && !containsString(f.Kinds, msg.Name) {
+		return false
+	}
+	if len(f.Types) > 0 && !containsString(f.Types, msg.Type) {
+		return false
+	}
+	if len(f.Domains) == 0 {
+		return true
+	}
+	for _, pattern := range f.Domains {
+		if matchDomain(pattern, msg.Domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// publish numbers msg, keeps it for replay and hands it to every matching
+// subscriber. Subscribers too slow to keep up are disconnected; they can
+// resume from the last ID they received. The caller must hold api.mu.
+func (api *APIServer) publish(name, domain, recordType string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Warning: Failed to encode %s event: %v", name, err)
+		return
+	}
+	api.seq++
+	msg := sseMessage{ID: api.seq, Name: name, Domain: domain, Type: recordType, Data: data}
+
+	api.replay = append(api.replay, msg)
+	if len(api.replay) > maxSSEReplay {
+		api.replay = api.replay[len(api.replay)-maxSSEReplay:]
+	}
+
+	for ch, filter := range api.subscribers {
+		if !filter.Match(msg) {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			delete(api.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends a notifier event on the /events stream.
+func (api *APIServer) Publish(event Event) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.publish(event.Kind, event.Domain, event.Type, event)
+}
+
+// subscribe registers a subscriber for filter and returns its channel along
+// with the retained messages to replay: those after lastID if it is set,
+// otherwise the last n matching ones.
+func (api *APIServer) subscribe(filter sseFilter, lastID uint64, n int) (chan sseMessage, []sseMessage) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var replay []sseMessage
+	for _, msg := range api.replay {
+		if filter.Match(msg) && (lastID == 0 || msg.ID > lastID) {
+			replay = append(replay, msg)
+		}
+	}
+	if lastID == 0 && len(replay) > n {
+		replay = replay[len(replay)-n:]
+	}
+
+	ch := make(chan sseMessage, sseSubscriberSize)
+	if api.subscribers == nil {
+		api.subscribers = make(map[chan sseMessage]sseFilter)
+	}
+	api.subscribers[ch] = filter
+	return ch, replay
+}
+
+func (api *APIServer) unsubscribe(ch chan sseMessage) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if _, ok := api.subscribers[ch]; ok {
+		delete(api.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (api *APIServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	filter, err := parseSSEFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	n := 0
+	if s := r.URL.Query().Get("replay"); s != "" {
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid replay: "+s)
+			return
+		}
+	}
+	var lastID uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		lastID, _ = strconv.ParseUint(s, 10, 64)
+	}
+
+	ch, replay := api.subscribe(filter, lastID, n)
+	defer api.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, msg := range replay {
+		if err := msg.write(w); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := msg.write(w); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
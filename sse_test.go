@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEFilter_Match(t *testing.T) {
+	tests := []struct {
+		query    string
+		msg      sseMessage
+		expected bool
+	}{
+		{"", sseMessage{Name: EventObservation, Domain: "example.com", Type: "A"}, true},
+		{"domain=*.example.com", sseMessage{Name: EventChange, Domain: "WWW.example.com.", Type: "A"}, true},
+		{"domain=*.example.com", sseMessage{Name: EventChange, Domain: "example.com", Type: "A"}, false},
+		{"domain=example.org,example.com", sseMessage{Name: EventChange, Domain: "example.com", Type: "A"}, true},
+		{"type=aaaa", sseMessage{Name: EventChange, Domain: "example.com", Type: "A"}, false},
+		{"kind=change&kind=error", sseMessage{Name: EventError, Domain: "example.com", Type: "A"}, true},
+		{"kind=change,error", sseMessage{Name: EventObservation, Domain: "example.com", Type: "A"}, false},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		filter, err := parseSSEFilter(query)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.query, err)
+		}
+		if got := filter.Match(tt.msg); got != tt.expected {
+			t.Errorf("%q matching %+v: expected %t, got %t", tt.query, tt.msg, tt.expected, got)
+		}
+	}
+
+	if _, err := parseSSEFilter(url.Values{"kind": {"changes"}}); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+}
+
+// readSSE reads n messages from r and returns their event names and data.
+func readSSE(t *testing.T, r *bufio.Reader, n int) ([]string, []string) {
+	t.Helper()
+	var names, data []string
+	for len(data) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream failed after %d message(s): %v", len(data), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			names = append(names, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return names, data
+}
+
+func TestAPIServer_stream(t *testing.T) {
+	monitor, srv := newTestAPI(t, "example.com", "example.org")
+	now := time.Now()
+
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusInitial, Values: []string{"1.1.1.1"}})
+	monitor.emit(Observation{Time: now, Domain: "example.org", Type: "A", Server: "192.0.2.1:53", Status: StatusChanged, Values: []string{"3.3.3.3"}, Previous: []string{"4.4.4.4"}})
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusChanged, Values: []string{"2.2.2.2"}, Previous: []string{"1.1.1.1"}})
+
+	resp, err := http.Get(srv.URL + "/events?domain=example.com&replay=5")
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	names, data := readSSE(t, r, 3)
+	expected := []string{EventObservation, EventObservation, EventChange}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected replayed %v, got %v", expected, names)
+	}
+	if !strings.Contains(data[2], `"before":["1.1.1.1"]`) || !strings.Contains(data[2], `"after":["2.2.2.2"]`) {
+		t.Errorf("unexpected change event data: %s", data[2])
+	}
+
+	monitor.emit(Observation{Time: now, Domain: "example.org", Type: "A", Server: "192.0.2.1:53", Status: StatusUnchanged, Values: []string{"3.3.3.3"}})
+	monitor.emit(Observation{Time: now, Domain: "example.com", Type: "A", Server: "192.0.2.1:53", Status: StatusError, Error: "timeout"})
+
+	names, data = readSSE(t, r, 2)
+	if names[0] != EventObservation || names[1] != EventError {
+		t.Errorf("expected live observation and error for example.com only, got %v", names)
+	}
+	if !strings.Contains(data[1], `"failures":1`) {
+		t.Errorf("expected failure count in error event, got %s", data[1])
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?kind=change", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events failed: %v", err)
+	}
+	defer resumed.Body.Close()
+	names, data = readSSE(t, bufio.NewReader(resumed.Body), 2)
+	if names[0] != EventChange || !strings.Contains(data[0], "example.org") || !strings.Contains(data[1], "example.com") {
+		t.Errorf("expected both changes after ID 1, got %v %v", names, data)
+	}
+
+	if status := getJSON(t, srv.URL+"/events?kind=bogus", nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", status)
+	}
+}
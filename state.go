@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateVersion = 1
+
+// stateFile is the on-disk form of the last records seen, keyed like
+// Monitor.lastRecords, and of what the union and contains comparison
+// policies remember about them.
+type stateFile struct {
+	Version int                     `json:"version"`
+	Saved   time.Time               `json:"saved"`
+	Records map[string]stateRecord  `json:"records"`
+	Compare map[string]stateCompare `json:"compare,omitempty"`
+}
+
+type stateRecord struct {
+	Domain string   `json:"domain"`
+	Type   string   `json:"type"`
+	Server string   `json:"server,omitempty"`
+	Values []string `json:"values"`
+}
+
+type stateCompare struct {
+	Window   [][]string `json:"window,omitempty"`
+	Baseline []string   `json:"baseline,omitempty"`
+}
+
+// loadState reads the records and comparison state saved at path. A missing
+// file yields neither and no error.
+func loadState(path string) (map[string]*DNSRecord, map[string]*compareState, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	if state.Version != stateVersion {
+		return nil, nil, fmt.Errorf("unsupported state file version %d in %s", state.Version, path)
+	}
+
+	records := make(map[string]*DNSRecord, len(state.Records))
+	for key, r := range state.Records {
+		records[key] = &DNSRecord{Domain: r.Domain, Type: r.Type, Server: r.Server, Values: r.Values}
+	}
+	compare := make(map[string]*compareState, len(state.Compare))
+	for key, c := range state.Compare {
+		compare[key] = &compareState{window: c.Window, baseline: c.Baseline}
+	}
+	return records, compare, nil
+}
+
+// saveState writes records and comparison state to path atomically, by
+// renaming a fully written temporary file over it.
+func saveState(path string, records map[string]*DNSRecord, compare map[string]*compareState) error {
+	state := stateFile{
+		Version: stateVersion,
+		Saved:   time.Now(),
+		Records: make(map[string]stateRecord, len(records)),
+	}
+	for key, r := range records {
+		state.Records[key] = stateRecord{Domain: r.Domain, Type: r.Type, Server: r.Server, Values: r.Values}
+	}
+	if len(compare) > 0 {
+		state.Compare = make(map[string]stateCompare, len(compare))
+		for key, c := range compare {
+			state.Compare[key] = stateCompare{Window: c.window, Baseline: c.baseline}
+		}
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestState_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	records, compare, err := loadState(path)
+	if err != nil || records != nil || compare != nil {
+		t.Fatalf("expected no records for a missing file, got %v, %v, %v", records, compare, err)
+	}
+
+	saved := map[string]*DNSRecord{
+		"example.com:A@8.8.8.8:53": {Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Values: []string{"192.0.2.1"}},
+		"example.com:DNSKEY":       {Domain: "example.com", Type: "DNSKEY", Values: []string{"257 3 13 keytag=2 (KSK)"}},
+	}
+	savedCompare := map[string]*compareState{
+		"cdn.example.com:A": {window: [][]string{{"192.0.2.1"}, {"192.0.2.2"}}},
+		"example.com:MX":    {baseline: []string{"10 mx.example.com."}},
+	}
+	if err := saveState(path, saved, savedCompare); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	records, compare, err = loadState(path)
+	if err != nil {
+		t.Fatalf("loadState failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	for key, want := range saved {
+		got := records[key]
+		if got == nil || !got.Equals(want) || got.Server != want.Server {
+			t.Errorf("record %s: expected %+v, got %+v", key, want, got)
+		}
+	}
+
+	if c := compare["cdn.example.com:A"]; c == nil || len(c.window) != 2 || c.window[1][0] != "192.0.2.2" {
+		t.Errorf("expected union window to be restored, got %+v", c)
+	}
+	if c := compare["example.com:MX"]; c == nil || len(c.baseline) != 1 || c.baseline[0] != "10 mx.example.com." {
+		t.Errorf("expected contains baseline to be restored, got %+v", c)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected temporary files to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestState_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	os.WriteFile(path, []byte("{not json"), 0o644)
+	if _, _, err := loadState(path); err == nil {
+		t.Error("expected error for corrupt state file")
+	}
+
+	os.WriteFile(path, []byte(`{"version": 99, "records": {}}`), 0o644)
+	if _, _, err := loadState(path); err == nil {
+		t.Error("expected error for unknown state file version")
+	}
+}
+
+func TestMonitor_stateAcrossRestart(t *testing.T) {
+	server, answer := startAnswerServer(t, []byte{192, 0, 2, 1})
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	config := func() *Config {
+		return &Config{Domains: []string{"example.com"}, RecordType: "A", Servers: []string{server}, NoColor: true, StateFile: path}
+	}
+
+	monitor, _ := newTestMonitor(t, config(), server)
+	if n, err := monitor.restoreState(); err != nil || n != 0 {
+		t.Fatalf("expected empty state, got %d, %v", n, err)
+	}
+	if monitor.checkDomains() {
+		t.Error("first check should not report a change")
+	}
+
+	// The record changes while the monitor is down.
+	answer.set([]byte{192, 0, 2, 2})
+
+	monitor, second := newTestMonitor(t, config(), server)
+	if n, err := monitor.restoreState(); err != nil || n != 1 {
+		t.Fatalf("expected one restored record, got %d, %v", n, err)
+	}
+	if !monitor.checkDomains() {
+		t.Error("expected change made while stopped to be reported")
+	}
+	if !strings.Contains(second.String(), "192.0.2.1") || !strings.Contains(second.String(), "192.0.2.2") {
+		t.Errorf("expected before and after values in log, got %q", second.String())
+	}
+}
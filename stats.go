@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// maxLatencySamples bounds the memory kept per server on long runs; the
+// percentiles then describe the most recent queries.
+const maxLatencySamples = 10000
+
+type ServerStats struct {
+	Server  string
+	Queries int
+	Errors  int
+	P50     time.Duration
+	P95     time.Duration
+	Max     time.Duration
+}
+
+type serverSamples struct {
+	rtts    []time.Duration
+	next    int
+	queries int
+	errors  int
+}
+
+type queryStats struct {
+	mu      sync.Mutex
+	servers map[string]*serverSamples
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{servers: make(map[string]*serverSamples)}
+}
+
+// record adds one query to the statistics of server. Only successful queries
+// contribute to the latency percentiles so that timeouts don't mask them.
+func (s *queryStats) record(server string, rtt time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples, exists := s.servers[server]
+	if !exists {
+		samples = &serverSamples{}
+		s.servers[server] = samples
+	}
+	samples.queries++
+	if !ok {
+		samples.errors++
+		return
+	}
+	if len(samples.rtts) < maxLatencySamples {
+		samples.rtts = append(samples.rtts, rtt)
+	} else {
+		samples.rtts[samples.next] = rtt
+		samples.next = (samples.next + 1) % maxLatencySamples
+	}
+}
+
+func (s *queryStats) snapshot() []ServerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]ServerStats, 0, len(s.servers))
+	for server, samples := range s.servers {
+		sorted := append([]time.Duration(nil), samples.rtts...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats = append(stats, ServerStats{
+			Server:  server,
+			Queries: samples.queries,
+			Errors:  samples.errors,
+			P50:     percentile(sorted, 50),
+			P95:     percentile(sorted, 95),
+			Max:     percentile(sorted, 100),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Server < stats[j].Server })
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (s ServerStats) ErrorRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Queries)
+}
+
+func (s ServerStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Server    string  `json:"server"`
+		Queries   int     `json:"queries"`
+		Errors    int     `json:"errors"`
+		ErrorRate float64 `json:"error_rate"`
+		P50       float64 `json:"p50_ms"`
+		P95       float64 `json:"p95_ms"`
+		Max       float64 `json:"max_ms"`
+	}{s.Server, s.Queries, s.Errors, s.ErrorRate(), millis(s.P50), millis(s.P95), millis(s.Max)})
+}
+
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+func formatRTT(d time.Duration) string {
+	ms := float64(d) / float64(time.Millisecond)
+	if ms < 10 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
+}
+
+func printStats(w io.Writer, stats []ServerStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tQUERIES\tERRORS\tP50\tP95\tMAX")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\t%s\n",
+			s.Server, s.Queries, s.ErrorRate()*100, formatRTT(s.P50), formatRTT(s.P95), formatRTT(s.Max))
+	}
+	tw.Flush()
+}
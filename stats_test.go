@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 20; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 10 * time.Millisecond},
+		{95, 19 * time.Millisecond},
+		{100, 20 * time.Millisecond},
+		{0, 1 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.expected {
+			t.Errorf("p%.0f: expected %s, got %s", tt.p, tt.expected, got)
+		}
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("expected 0 for empty samples, got %s", got)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	stats := newQueryStats()
+	stats.record("8.8.8.8:53", 30*time.Millisecond, true)
+	stats.record("8.8.8.8:53", 10*time.Millisecond, true)
+	stats.record("8.8.8.8:53", 5*time.Second, false)
+	stats.record("1.1.1.1:53", 5*time.Millisecond, true)
+
+	snapshot := stats.snapshot()
+	if len(snapshot) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(snapshot))
+	}
+	if snapshot[0].Server != "1.1.1.1:53" {
+		t.Errorf("expected servers sorted by name, got %s first", snapshot[0].Server)
+	}
+
+	google := snapshot[1]
+	if google.Queries != 3 || google.Errors != 1 {
+		t.Errorf("expected 3 queries and 1 error, got %d and %d", google.Queries, google.Errors)
+	}
+	if google.Max != 30*time.Millisecond {
+		t.Errorf("failed queries should not count towards latency, got max %s", google.Max)
+	}
+	if rate := google.ErrorRate(); rate < 0.33 || rate > 0.34 {
+		t.Errorf("expected error rate 1/3, got %f", rate)
+	}
+}
+
+func TestQueryStats_SampleLimit(t *testing.T) {
+	stats := newQueryStats()
+	for i := 0; i < maxLatencySamples+10; i++ {
+		stats.record("server", time.Millisecond, true)
+	}
+	if n := len(stats.servers["server"].rtts); n != maxLatencySamples {
+		t.Errorf("expected %d samples, got %d", maxLatencySamples, n)
+	}
+	if q := stats.snapshot()[0].Queries; q != maxLatencySamples+10 {
+		t.Errorf("expected query count to keep growing, got %d", q)
+	}
+}
+
+func TestServerStats_JSON(t *testing.T) {
+	b, err := json.Marshal(ServerStats{Server: "8.8.8.8:53", Queries: 4, Errors: 1, P50: 1500 * time.Microsecond})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	for _, want := range []string{`"server":"8.8.8.8:53"`, `"error_rate":0.25`, `"p50_ms":1.5`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %s in %s", want, b)
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	var buf bytes.Buffer
+	printStats(&buf, []ServerStats{{Server: "8.8.8.8:53", Queries: 2, P50: 12 * time.Millisecond, P95: 20 * time.Millisecond, Max: 20 * time.Millisecond}})
+
+	out := buf.String()
+	if !strings.Contains(out, "SERVER") || !strings.Contains(out, "8.8.8.8:53") || !strings.Contains(out, "12ms") {
+		t.Errorf("unexpected stats table:\n%s", out)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Target is one monitored domain and record type, with the values it is
+// expected to have, if any.
+type Target struct {
+	Domain   string
+	Type     string
+	Expected []string
+}
+
+// Key identifies the target in lastRecords and the other per-record maps,
+// which add "@server" when servers are queried separately.
+func (t Target) Key() string {
+	return t.Domain + ":" + t.Type
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s (%s)", t.Domain, t.Type)
+}
+
+// targets returns every configured target: the positional domains with the
+// global record type and expected values, followed by Targets.
+func (c *Config) targets() []Target {
+	targets := make([]Target, 0, len(c.Domains)+len(c.Targets))
+	for _, domain := range c.Domains {
+		targets = append(targets, Target{Domain: domain, Type: c.RecordType, Expected: c.Expected})
+	}
+	return append(targets, c.Targets...)
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// filesChanged reports whether a domains or zone file was modified since the
+// last call. The first call only records the modification times; files that
+// cannot be read, and standard input, are ignored.
+func (m *Monitor) filesChanged() bool {
+	if m.watched == nil {
+		m.watched = make(map[string]time.Time)
+	}
+	changed := false
+	for _, path := range append(append([]string(nil), m.config.DomainsFiles...), m.config.ZoneFiles...) {
+		if path == "-" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last, ok := m.watched[path]; ok && !info.ModTime().Equal(last) {
+			changed = true
+		}
+		m.watched[path] = info.ModTime()
+	}
+	return changed
+}
+
+// reloadTargets re-reads the configuration and switches to its targets.
+// Other options keep their values until restart.
+func (m *Monitor) reloadTargets() {
+	if m.reload == nil {
+		return
+	}
+	config, err := m.reload()
+	if err != nil {
+		log.Printf("Warning: Reload failed, keeping current targets: %v", err)
+		return
+	}
+	message := m.applyTargets(config)
+	m.printColored(message, ColorBlue)
+	m.logger.Println(message)
+}
+
+// applyTargets replaces the targets with those of config, keeping the ones
+// added through the API unless config now has them too. Records of targets
+// kept are remembered, so only real changes are reported on the next check.
+// It returns a summary of what was added, removed and modified.
+func (m *Monitor) applyTargets(config *Config) string {
+	before := make(map[string]Target)
+	for _, t := range m.config.targets() {
+		before[t.Key()] = t
+	}
+
+	added := m.added[:0:0]
+	for _, t := range m.added {
+		if !hasTarget(config.targets(), t) {
+			added = append(added, t)
+		}
+	}
+	m.added = added
+	m.config.Domains = config.Domains
+	m.config.Targets = append(append([]Target(nil), config.Targets...), added...)
+	m.config.RecordType = config.RecordType
+	m.config.Expected = config.Expected
+
+	var appeared, modified []string
+	after := make(map[string]bool)
+	for _, t := range m.config.targets() {
+		after[t.Key()] = true
+		old, ok := before[t.Key()]
+		switch {
+		case !ok:
+			appeared = append(appeared, t.String())
+			if m.api != nil {
+				m.api.addTarget(t)
+			}
+		case !sameValues(old.Expected, t.Expected):
+			modified = append(modified, t.String())
+			m.forgetBaseline(t)
+		}
+	}
+	var removed []string
+	for key, t := range before {
+		if after[key] {
+			continue
+		}
+		removed = append(removed, t.String())
+		m.forget(t)
+	}
+
+	if len(appeared)+len(removed)+len(modified) == 0 {
+		return "Reloaded targets: no changes"
+	}
+	var parts []string
+	for _, group := range []struct {
+		verb    string
+		targets []string
+	}{{"added", appeared}, {"removed", removed}, {"modified", modified}} {
+		if len(group.targets) > 0 {
+			sort.Strings(group.targets)
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", len(group.targets), group.verb, strings.Join(group.targets, ", ")))
+		}
+	}
+	return "Reloaded targets: " + strings.Join(parts, ", ")
+}
+
+// hasTarget reports whether targets monitor the domain and type of target.
+func hasTarget(targets []Target, target Target) bool {
+	for _, t := range targets {
+		if strings.EqualFold(t.Domain, target.Domain) && t.Type == target.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops everything remembered about a target that is no longer
+// monitored, including its domain's DNSSEC key sets once no other target
+// uses the domain.
+func (m *Monitor) forget(target Target) {
+	prefix := target.Key()
+	domainGone := true
+	for _, t := range m.config.targets() {
+		if strings.EqualFold(t.Domain, target.Domain) {
+			domainGone = false
+		}
+	}
+	if domainGone {
+		prefix = target.Domain + ":"
+	}
+	matches := func(key string) bool {
+		if domainGone {
+			return strings.HasPrefix(key, prefix)
+		}
+		return key == prefix || strings.HasPrefix(key, prefix+"@")
+	}
+
+	for key := range m.lastRecords {
+		if matches(key) {
+			delete(m.lastRecords, key)
+		}
+	}
+	for key := range m.trackers {
+		if matches(key) {
+			delete(m.trackers, key)
+		}
+	}
+	for key := range m.compare {
+		if matches(key) {
+			delete(m.compare, key)
+		}
+	}
+	for key := range m.failures {
+		if matches(key) {
+			delete(m.failures, key)
+		}
+	}
+	if m.api != nil {
+		m.api.removeTarget(target)
+	}
+	if m.tui != nil {
+		m.tui.Remove(target.Domain, target.Type)
+	}
+}
+
+// forgetBaseline drops the values the contains comparison learned for
+// target, so that new expected values take effect.
+func (m *Monitor) forgetBaseline(target Target) {
+	prefix := target.Key()
+	for key := range m.compare {
+		if key == prefix || strings.HasPrefix(key, prefix+"@") {
+			delete(m.compare, key)
+		}
+	}
+}
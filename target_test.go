@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_targets(t *testing.T) {
+	config := &Config{
+		Domains:    []string{"example.com", "example.org"},
+		RecordType: "AAAA",
+		Expected:   []string{"2001:db8::1"},
+		Targets:    []Target{{Domain: "example.com", Type: "MX"}},
+	}
+
+	targets := config.targets()
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %v", targets)
+	}
+	if targets[1].Key() != "example.org:AAAA" || len(targets[1].Expected) != 1 {
+		t.Errorf("expected positional domains to use the global type and expected values, got %+v", targets[1])
+	}
+	if targets[2].Key() != "example.com:MX" {
+		t.Errorf("expected extra targets last, got %+v", targets[2])
+	}
+}
+
+func TestMonitor_applyTargets(t *testing.T) {
+	monitor, _ := newTestMonitor(t, &Config{
+		Domains:    []string{"a.example.com", "b.example.com"},
+		RecordType: "A",
+		NoColor:    true,
+	}, "")
+	monitor.lastRecords = map[string]*DNSRecord{
+		"a.example.com:A":      {Domain: "a.example.com", Type: "A"},
+		"a.example.com:DNSKEY": {Domain: "a.example.com", Type: "DNSKEY"},
+		"b.example.com:A":      {Domain: "b.example.com", Type: "A"},
+	}
+	monitor.failures = map[string]int{"a.example.com:A": 2}
+
+	summary := monitor.applyTargets(&Config{
+		Domains:    []string{"b.example.com", "c.example.com"},
+		RecordType: "A",
+	})
+	if summary != "Reloaded targets: 1 added (c.example.com (A)), 1 removed (a.example.com (A))" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if _, ok := monitor.lastRecords["b.example.com:A"]; !ok {
+		t.Error("expected the record of a kept target to be remembered")
+	}
+	for _, key := range []string{"a.example.com:A", "a.example.com:DNSKEY"} {
+		if _, ok := monitor.lastRecords[key]; ok {
+			t.Errorf("expected %s of the removed domain to be forgotten", key)
+		}
+	}
+	if len(monitor.failures) != 0 {
+		t.Errorf("expected failures of the removed domain to be forgotten, got %v", monitor.failures)
+	}
+
+	summary = monitor.applyTargets(&Config{
+		Domains:    []string{"b.example.com", "c.example.com"},
+		RecordType: "A",
+		Expected:   []string{"192.0.2.1"},
+	})
+	if summary != "Reloaded targets: 2 modified (b.example.com (A), c.example.com (A))" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	if summary := monitor.applyTargets(monitor.config); summary != "Reloaded targets: no changes" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+}
+
+func TestMonitor_reloadTargets(t *testing.T) {
+	monitor, buf := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", NoColor: true, JSON: true}, "")
+
+	monitor.reload = func() (*Config, error) { return nil, errors.New("bad arguments") }
+	monitor.reloadTargets()
+	if len(monitor.config.Domains) != 1 || buf.Len() != 0 {
+		t.Errorf("expected a failed reload to keep the targets, got %v", monitor.config.Domains)
+	}
+
+	monitor.reload = func() (*Config, error) {
+		return ParseArgs([]string{"dns-monitor", "-t", "MX", "example.com"})
+	}
+	monitor.reloadTargets()
+	if monitor.config.RecordType != "MX" {
+		t.Errorf("expected record type MX after reload, got %s", monitor.config.RecordType)
+	}
+	if !strings.Contains(buf.String(), "1 added (example.com (MX)), 1 removed (example.com (A))") {
+		t.Errorf("expected summary in log, got %q", buf.String())
+	}
+}
+
+func TestMonitor_reloadKeepsAddedTargets(t *testing.T) {
+	monitor, _ := newTestMonitor(t, &Config{Domains: []string{"example.com"}, RecordType: "A", NoColor: true}, "")
+	monitor.addDomain("api-added.example")
+	monitor.addDomain("moved.example")
+
+	summary := monitor.applyTargets(&Config{Domains: []string{"example.com", "moved.example"}, RecordType: "A"})
+	if summary != "Reloaded targets: no changes" {
+		t.Errorf("expected targets added through the API to survive a reload, got %s", summary)
+	}
+	if len(monitor.added) != 1 || monitor.added[0].Domain != "api-added.example" {
+		t.Errorf("expected a target now in the configuration to be owned by it, got %v", monitor.added)
+	}
+
+	summary = monitor.applyTargets(&Config{Domains: []string{"example.com"}, RecordType: "A"})
+	if summary != "Reloaded targets: 1 removed (moved.example (A))" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	monitor.removeDomain("api-added.example")
+	if summary := monitor.applyTargets(&Config{Domains: []string{"example.com"}, RecordType: "A"}); summary != "Reloaded targets: no changes" || len(monitor.added) != 0 {
+		t.Errorf("expected a removed target to stay removed, got %s %v", summary, monitor.added)
+	}
+}
+
+func TestMonitor_filesChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	os.WriteFile(path, []byte("example.com\n"), 0o644)
+	monitor := &Monitor{config: &Config{DomainsFiles: []string{path, "-", filepath.Join(t.TempDir(), "missing.txt")}}}
+
+	if monitor.filesChanged() {
+		t.Error("the first call should only record modification times")
+	}
+	if monitor.filesChanged() {
+		t.Error("unmodified files should not be reported")
+	}
+	os.WriteFile(path, []byte("example.com\nexample.org\n"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if !monitor.filesChanged() {
+		t.Error("expected a modified file to be reported")
+	}
+	if monitor.filesChanged() {
+		t.Error("a modification should only be reported once")
+	}
+}
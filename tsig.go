@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// Transaction signatures (RFC 8945) for zone transfers. A signed transfer
+// is answered by a series of messages, each covering the MAC of the one
+// before it, so the MAC of the previous message is threaded through.
+
+const tsigFudge = 300
+
+const (
+	tsigBadSig   = 16
+	tsigBadKey   = 17
+	tsigBadTime  = 18
+	tsigBadTrunc = 22
+)
+
+var tsigErrorNames = map[uint16]string{
+	tsigBadSig:   "BADSIG",
+	tsigBadKey:   "BADKEY",
+	tsigBadTime:  "BADTIME",
+	tsigBadTrunc: "BADTRUNC",
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-md5.sig-alg.reg.int.": md5.New,
+	"hmac-sha1.":                sha1.New,
+	"hmac-sha224.":              sha256.New224,
+	"hmac-sha256.":              sha256.New,
+	"hmac-sha384.":              sha512.New384,
+	"hmac-sha512.":              sha512.New,
+}
+
+type TSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// TSIGError is returned when a server rejected the signature of a request.
+type TSIGError struct {
+	Code uint16
+}
+
+func (e *TSIGError) Error() string {
+	if name, ok := tsigErrorNames[e.Code]; ok {
+		return "TSIG " + name
+	}
+	return fmt.Sprintf("TSIG error %d", e.Code)
+}
+
+// parseTSIGKey parses a key given as [ALGORITHM:]NAME:SECRET, as with dig -y,
+// where SECRET is base64. The algorithm defaults to hmac-sha256.
+func parseTSIGKey(s string) (*TSIGKey, error) {
+	parts := strings.Split(s, ":")
+	algorithm := "hmac-sha256"
+	switch len(parts) {
+	case 2:
+	case 3:
+		algorithm, parts = parts[0], parts[1:]
+	default:
+		return nil, fmt.Errorf("invalid TSIG key (use [ALGORITHM:]NAME:SECRET)")
+	}
+	if parts[0] == "" {
+		return nil, fmt.Errorf("invalid TSIG key: missing key name")
+	}
+
+	algorithm = canonicalName(algorithm)
+	if algorithm == "hmac-md5." {
+		algorithm = "hmac-md5.sig-alg.reg.int."
+	}
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm: %s", strings.TrimSuffix(algorithm, "."))
+	}
+	secret, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(secret) == 0 {
+		return nil, fmt.Errorf("invalid TSIG secret for key %s", parts[0])
+	}
+	return &TSIGKey{Name: canonicalName(parts[0]), Algorithm: algorithm, Secret: secret}, nil
+}
+
+func (k *TSIGKey) String() string {
+	return fmt.Sprintf("%s (%s)", strings.TrimSuffix(k.Name, "."), strings.TrimSuffix(k.Algorithm, "."))
+}
+
+func (k *TSIGKey) mac(parts ...[]byte) []byte {
+	h := hmac.New(tsigAlgorithms[k.Algorithm], k.Secret)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// tsigRecord is the rdata of a TSIG record.
+type tsigRecord struct {
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	Other      []byte
+}
+
+func parseTSIG(data []byte) (*tsigRecord, error) {
+	algorithm, off, err := rdataName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if off+10 > len(data) {
+		return nil, errShortMessage
+	}
+	t := &tsigRecord{
+		Algorithm:  algorithm,
+		TimeSigned: uint64(binary.BigEndian.Uint16(data[off:]))<<32 | uint64(binary.BigEndian.Uint32(data[off+2:])),
+		Fudge:      binary.BigEndian.Uint16(data[off+6:]),
+	}
+	macLen := int(binary.BigEndian.Uint16(data[off+8:]))
+	off += 10
+	if off+macLen+6 > len(data) {
+		return nil, errShortMessage
+	}
+	t.MAC = data[off : off+macLen]
+	off += macLen
+	t.OriginalID = binary.BigEndian.Uint16(data[off:])
+	t.Error = binary.BigEndian.Uint16(data[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(data[off+4:]))
+	off += 6
+	if off+otherLen > len(data) {
+		return nil, errShortMessage
+	}
+	t.Other = data[off : off+otherLen]
+	return t, nil
+}
+
+func (t *tsigRecord) pack() ([]byte, error) {
+	b, err := appendName(nil, t.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	b = t.appendTimers(b)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.MAC)))
+	b = append(b, t.MAC...)
+	b = binary.BigEndian.AppendUint16(b, t.OriginalID)
+	b = binary.BigEndian.AppendUint16(b, t.Error)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...), nil
+}
+
+func (t *tsigRecord) appendTimers(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(t.TimeSigned>>32))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.TimeSigned))
+	return binary.BigEndian.AppendUint16(b, t.Fudge)
+}
+
+// variables returns the TSIG fields covered by the MAC. Messages after the
+// first of a transfer only cover the timers.
+func (t *tsigRecord) variables(keyName string, timersOnly bool) []byte {
+	if timersOnly {
+		return t.appendTimers(nil)
+	}
+	b, _ := appendName(nil, strings.ToLower(keyName))
+	b = binary.BigEndian.AppendUint16(b, ClassANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b, _ = appendName(b, strings.ToLower(t.Algorithm))
+	b = t.appendTimers(b)
+	b = binary.BigEndian.AppendUint16(b, t.Error)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(t.Other)))
+	return append(b, t.Other...)
+}
+
+// macPrefix returns a prior MAC as it is fed into the next digest.
+func macPrefix(mac []byte) []byte {
+	if mac == nil {
+		return nil
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(mac))), mac...)
+}
+
+// sign packs msg with a TSIG record appended. prior is the MAC of the
+// request being answered, or of the previous message of a transfer when
+// timersOnly is set; it is nil for a request. The new MAC is returned so it
+// can be passed on.
+func (k *TSIGKey) sign(msg *dnsMessage, prior []byte, timersOnly bool, now time.Time) ([]byte, []byte, error) {
+	body, err := msg.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &tsigRecord{Algorithm: k.Algorithm, TimeSigned: uint64(now.Unix()), Fudge: tsigFudge, OriginalID: msg.ID}
+	t.MAC = k.mac(macPrefix(prior), body, t.variables(k.Name, timersOnly))
+	data, err := t.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signed := *msg
+	signed.Additional = append(append([]dnsRR(nil), msg.Additional...), dnsRR{Name: k.Name, Type: TypeTSIG, Class: ClassANY, Data: data})
+	b, err := signed.pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, t.MAC, nil
+}
+
+// stripTSIG returns msg without its TSIG record, with the additional count
+// adjusted, and the TSIG record itself. The record is nil when the message
+// is not signed.
+func stripTSIG(msg []byte) ([]byte, *dnsRR, error) {
+	if len(msg) < 12 {
+		return nil, nil, errShortMessage
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		off = next + 4
+	}
+	arcount := int(binary.BigEndian.Uint16(msg[10:]))
+	total := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + arcount
+
+	start := off
+	var rr dnsRR
+	for i := 0; i < total; i++ {
+		var err error
+		start = off
+		if rr, off, err = readRR(msg, off); err != nil {
+			return nil, nil, err
+		}
+	}
+	if arcount == 0 || rr.Type != TypeTSIG {
+		return msg, nil, nil
+	}
+	body := append([]byte(nil), msg[:start]...)
+	binary.BigEndian.PutUint16(body[10:], uint16(arcount-1))
+	return body, &rr, nil
+}
+
+// tsigVerifier checks the signatures on a series of messages, such as the
+// responses to a signed transfer request. Up to 99 messages in a row may be
+// unsigned; they are covered by the next signed one.
+type tsigVerifier struct {
+	key      *TSIGKey
+	mac      []byte
+	signed   bool
+	pending  []byte
+	unsigned int
+}
+
+func newTSIGVerifier(key *TSIGKey, requestMAC []byte) *tsigVerifier {
+	return &tsigVerifier{key: key, mac: requestMAC}
+}
+
+func (v *tsigVerifier) verify(msg []byte, now time.Time) error {
+	body, rr, err := stripTSIG(msg)
+	if err != nil {
+		return err
+	}
+	if rr == nil {
+		if !v.signed {
+			return errors.New("message is not signed")
+		}
+		if v.unsigned++; v.unsigned > 99 {
+			return errors.New("too many unsigned messages")
+		}
+		v.pending = append(v.pending, body...)
+		return nil
+	}
+
+	t, err := parseTSIG(rr.Data)
+	if err != nil {
+		return fmt.Errorf("invalid TSIG record: %v", err)
+	}
+	if t.Error != 0 {
+		return &TSIGError{Code: t.Error}
+	}
+	if canonicalName(rr.Name) != v.key.Name || canonicalName(t.Algorithm) != v.key.Algorithm {
+		return fmt.Errorf("message signed with unknown key %s", strings.TrimSuffix(rr.Name, "."))
+	}
+
+	binary.BigEndian.PutUint16(body, t.OriginalID)
+	mac := v.key.mac(macPrefix(v.mac), v.pending, body, t.variables(rr.Name, v.signed))
+	if !hmac.Equal(mac, t.MAC) {
+		return errors.New("TSIG signature mismatch")
+	}
+	if skew := now.Unix() - int64(t.TimeSigned); skew > int64(t.Fudge) || -skew > int64(t.Fudge) {
+		return fmt.Errorf("TSIG time off by %ds", skew)
+	}
+
+	v.mac, v.signed = t.MAC, true
+	v.pending, v.unsigned = nil, 0
+	return nil
+}
+
+// done reports an error when the last message verified was not signed.
+func (v *tsigVerifier) done() error {
+	if v.unsigned > 0 {
+		return errors.New("last message is not signed")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTSIGKey(t *testing.T) {
+	tests := []struct {
+		input     string
+		name      string
+		algorithm string
+	}{
+		{"xfr-key:c2VjcmV0", "xfr-key.", "hmac-sha256."},
+		{"hmac-sha512:Xfr-Key.example.:c2VjcmV0", "xfr-key.example.", "hmac-sha512."},
+		{"hmac-md5:xfr-key:c2VjcmV0", "xfr-key.", "hmac-md5.sig-alg.reg.int."},
+	}
+	for _, tt := range tests {
+		key, err := parseTSIGKey(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if key.Name != tt.name || key.Algorithm != tt.algorithm || string(key.Secret) != "secret" {
+			t.Errorf("%s: unexpected key %+v", tt.input, key)
+		}
+	}
+
+	for _, bad := range []string{"c2VjcmV0", ":c2VjcmV0", "xfr-key:not base64!", "hmac-foo:xfr-key:c2VjcmV0", "a:b:c:d"} {
+		_, err := parseTSIGKey(bad)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		} else if strings.Contains(err.Error(), "c2VjcmV0") {
+			t.Errorf("%s: error should not contain the secret: %v", bad, err)
+		}
+	}
+}
+
+func TestTSIG_SignVerify(t *testing.T) {
+	key, _ := parseTSIGKey("xfr-key:c2VjcmV0")
+	other, _ := parseTSIGKey("xfr-key:b3RoZXI=")
+	now := time.Now()
+
+	query := newQuery("example.com", TypeAXFR)
+	signed, requestMAC, err := key.sign(query, nil, false, now)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := newTSIGVerifier(key, nil).verify(signed, now); err != nil {
+		t.Errorf("expected signed request to verify, got %v", err)
+	}
+	if err := newTSIGVerifier(other, nil).verify(signed, now); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("expected signature mismatch with another secret, got %v", err)
+	}
+	if err := newTSIGVerifier(key, nil).verify(signed, now.Add(time.Hour)); err == nil || !strings.Contains(err.Error(), "TSIG time") {
+		t.Errorf("expected time error outside the fudge window, got %v", err)
+	}
+	tampered := append([]byte(nil), signed...)
+	tampered[3] ^= 0x01
+	if err := newTSIGVerifier(key, nil).verify(tampered, now); err == nil {
+		t.Error("expected tampered message to fail verification")
+	}
+
+	// A transfer: each response covers the MAC of the one before.
+	verifier := newTSIGVerifier(key, requestMAC)
+	prior := requestMAC
+	for i := 0; i < 3; i++ {
+		resp := &dnsMessage{ID: query.ID, Response: true, Questions: query.Questions}
+		b, mac, err := key.sign(resp, prior, i > 0, now)
+		if err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		if err := verifier.verify(b, now); err != nil {
+			t.Fatalf("response %d: unexpected error: %v", i, err)
+		}
+		prior = mac
+	}
+	unsigned, _ := (&dnsMessage{ID: query.ID, Response: true}).pack()
+	if err := verifier.verify(unsigned, now); err != nil {
+		t.Errorf("expected an unsigned message within a transfer to be accepted, got %v", err)
+	}
+	if err := verifier.done(); err == nil {
+		t.Error("expected error when the last message is unsigned")
+	}
+	if err := newTSIGVerifier(key, requestMAC).verify(unsigned, now); err == nil {
+		t.Error("expected error when the first response is unsigned")
+	}
+}
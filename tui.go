@@ -0,0 +1,510 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxDashboardEvents = 200
+	maxCellWidth       = 32
+)
+
+// Actions requested by a key press on the dashboard.
+const (
+	dashboardNone = iota
+	dashboardRefresh
+	dashboardQuit
+)
+
+type dashboardCell struct {
+	Values []string
+	Status string
+	RTT    time.Duration
+	Rcode  string
+}
+
+type dashboardRow struct {
+	Domain     string
+	Type       string
+	Cells      map[string]*dashboardCell
+	LastChange time.Time
+}
+
+// Dashboard is the full-screen view used with --tui: a table with one row
+// per domain and record type and one column per server, above a log of the
+// most recent events.
+type Dashboard struct {
+	out       io.Writer
+	noColor   bool
+	title     string
+	columns   []string
+	perServer bool
+	rows      []*dashboardRow
+	index     map[string]*dashboardRow
+	events    []string
+	lastCheck time.Time
+	paused    bool
+	filter    string
+	editing   bool
+	size      func() (int, int)
+
+	keys     chan byte
+	stopOnce sync.Once
+	restore  func()
+}
+
+func NewDashboard(out io.Writer, config *Config) *Dashboard {
+	d := &Dashboard{
+		out:       out,
+		noColor:   config.NoColor,
+		title:     fmt.Sprintf("DNS Monitor Tool v%s - %s every %s", Version, config.RecordType, config.Interval),
+		perServer: len(config.Servers) > 1,
+		index:     make(map[string]*dashboardRow),
+		size:      terminalSize,
+	}
+	if d.perServer {
+		d.columns = config.Servers
+	} else {
+		d.columns = []string{""}
+	}
+	for _, t := range config.targets() {
+		d.row(t.Domain, t.Type)
+	}
+	return d
+}
+
+func (d *Dashboard) row(domain, recordType string) *dashboardRow {
+	key := domain + ":" + recordType
+	row, ok := d.index[key]
+	if !ok {
+		row = &dashboardRow{Domain: domain, Type: recordType, Cells: make(map[string]*dashboardCell)}
+		d.index[key] = row
+		d.rows = append(d.rows, row)
+	}
+	return row
+}
+
+// Remove drops the row of a target that is no longer monitored.
+func (d *Dashboard) Remove(domain, recordType string) {
+	key := domain + ":" + recordType
+	row, ok := d.index[key]
+	if !ok {
+		return
+	}
+	delete(d.index, key)
+	for i, r := range d.rows {
+		if r == row {
+			d.rows = append(d.rows[:i:i], d.rows[i+1:]...)
+			break
+		}
+	}
+}
+
+// Observe updates the table with obs and adds it to the event log unless
+// nothing happened.
+func (d *Dashboard) Observe(obs Observation) {
+	column := ""
+	if d.perServer {
+		column = obs.Server
+	}
+
+	row := d.row(obs.Domain, obs.Type)
+	cell := &dashboardCell{Values: obs.Values, Status: obs.Status, RTT: obs.RTT, Rcode: obs.Rcode}
+	if obs.Status == StatusError || obs.Status == StatusWarning {
+		// Keep showing the last known values next to the failure.
+		if prev, ok := row.Cells[column]; ok {
+			cell.Values = prev.Values
+		}
+	}
+	row.Cells[column] = cell
+
+	if obs.Status == StatusChanged || obs.Status == StatusFlapping {
+		row.LastChange = obs.Time
+	}
+
+	label := fmt.Sprintf("%s (%s)", obs.Domain, obs.Type)
+	if obs.Server != "" {
+		label += " @" + obs.Server
+	}
+	var event string
+	switch obs.Status {
+	case StatusChanged:
+		event = fmt.Sprintf("CHANGED %s: %s → %s", label, formatValues(obs.Previous), formatValues(obs.Values))
+	case StatusError:
+		event = fmt.Sprintf("ERROR %s: %s", label, obs.Error)
+	case StatusWarning:
+		event = fmt.Sprintf("WARNING %s: %s", label, obs.Message)
+	case StatusPending:
+		event = fmt.Sprintf("UNCONFIRMED %s: %s, %s", label, formatValues(obs.Values), obs.Message)
+	case StatusFlapping:
+		event = fmt.Sprintf("FLAPPING %s: %s", label, obs.Message)
+	default:
+		if obs.Message == "" {
+			return
+		}
+		event = fmt.Sprintf("NOTE %s: %s", label, obs.Message)
+	}
+	d.log(obs.Time, event)
+}
+
+func (d *Dashboard) log(t time.Time, event string) {
+	d.events = append(d.events, t.Format("15:04:05")+" "+event)
+	if len(d.events) > maxDashboardEvents {
+		d.events = d.events[len(d.events)-maxDashboardEvents:]
+	}
+}
+
+// CheckDone records the time of the latest completed round of checks.
+func (d *Dashboard) CheckDone(t time.Time) {
+	d.lastCheck = t
+}
+
+func (d *Dashboard) Paused() bool {
+	return d.paused
+}
+
+// HandleKey applies a key press and returns the action the monitor should
+// take.
+func (d *Dashboard) HandleKey(key byte) int {
+	if d.editing {
+		switch key {
+		case '\r', '\n':
+			d.editing = false
+		case 27: // Esc
+			d.editing = false
+			d.filter = ""
+		case 127, 8: // Backspace
+			if d.filter != "" {
+				_, size := utf8.DecodeLastRuneInString(d.filter)
+				d.filter = d.filter[:len(d.filter)-size]
+			}
+		default:
+			if key >= ' ' {
+				d.filter += string(key)
+			}
+		}
+		return dashboardNone
+	}
+
+	switch key {
+	case 'q', 'Q':
+		return dashboardQuit
+	case 'p', 'P', ' ':
+		d.paused = !d.paused
+		if d.paused {
+			d.log(time.Now(), "paused")
+		} else {
+			d.log(time.Now(), "resumed")
+		}
+	case 'r', 'R':
+		return dashboardRefresh
+	case '/':
+		d.editing = true
+		d.filter = ""
+	case 'c', 'C', 27:
+		d.filter = ""
+	}
+	return dashboardNone
+}
+
+func (d *Dashboard) visibleRows() []*dashboardRow {
+	if d.filter == "" {
+		return d.rows
+	}
+	filter := strings.ToLower(d.filter)
+	var rows []*dashboardRow
+	for _, row := range d.rows {
+		if strings.Contains(strings.ToLower(row.Domain), filter) || strings.ToLower(row.Type) == filter {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// Render redraws the whole screen.
+func (d *Dashboard) Render(now time.Time) {
+	width, height := d.size()
+	rows := d.visibleRows()
+
+	headers := []string{"DOMAIN", "TYPE"}
+	for _, server := range d.columns {
+		if server == "" {
+			server = "ANSWER"
+		}
+		headers = append(headers, server)
+	}
+	headers = append(headers, "LAST CHANGE", "AGE")
+
+	table := make([][]string, len(rows))
+	colors := make([][]string, len(rows))
+	for i, row := range rows {
+		table[i] = []string{row.Domain, row.Type}
+		colors[i] = []string{"", ""}
+		for _, server := range d.columns {
+			text, color := cellText(row.Cells[server])
+			table[i] = append(table[i], text)
+			colors[i] = append(colors[i], color)
+		}
+		lastChange, age := "-", "-"
+		if !row.LastChange.IsZero() {
+			lastChange = row.LastChange.Format("15:04:05")
+			age = formatRemaining(now.Sub(row.LastChange))
+		}
+		table[i] = append(table[i], lastChange, age)
+		colors[i] = append(colors[i], "", "")
+	}
+
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, cells := range table {
+		for i, c := range cells {
+			widths[i] = max(widths[i], min(utf8.RuneCountInString(c), maxCellWidth))
+		}
+	}
+
+	var lines []string
+	status := "last check " + d.lastCheck.Format("15:04:05")
+	if d.lastCheck.IsZero() {
+		status = "waiting for first check"
+	}
+	if d.paused {
+		status += " - PAUSED"
+	}
+	title := d.line(width)
+	title.add(d.title+" - "+status, ColorBold)
+	lines = append(lines, title.String(), "")
+
+	header := d.line(width)
+	for i, h := range headers {
+		header.add(pad(h, widths[i]), ColorBold)
+		header.add("  ", "")
+	}
+	lines = append(lines, header.String())
+	for i, cells := range table {
+		l := d.line(width)
+		for j, c := range cells {
+			l.add(pad(c, widths[j]), colors[i][j])
+			l.add("  ", "")
+		}
+		lines = append(lines, l.String())
+	}
+	if len(rows) < len(d.rows) {
+		l := d.line(width)
+		l.add(fmt.Sprintf("(%d of %d rows match filter %q)", len(rows), len(d.rows), d.filter), ColorDim)
+		lines = append(lines, l.String())
+	}
+
+	lines = append(lines, "")
+	sep := d.line(width)
+	sep.add("Events", ColorBold)
+	lines = append(lines, sep.String())
+
+	footer := "q quit  p pause  r refresh  / filter  c clear filter"
+	if d.editing {
+		footer = "filter: " + d.filter + "_  (Enter apply, Esc cancel)"
+	} else if d.filter != "" {
+		footer += "  [filter: " + d.filter + "]"
+	}
+
+	// The event pane takes the lines left above the footer.
+	room := height - len(lines) - 1
+	events := d.events
+	if room < 0 {
+		room = 0
+	}
+	if len(events) > room {
+		events = events[len(events)-room:]
+	}
+	for _, e := range events {
+		l := d.line(width)
+		l.add(e, eventColor(e))
+		lines = append(lines, l.String())
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	f := d.line(width)
+	f.add(footer, ColorDim)
+	lines = append(lines, f.String())
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\033[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\033[J")
+	io.WriteString(d.out, b.String())
+}
+
+func cellText(cell *dashboardCell) (string, string) {
+	if cell == nil {
+		return "…", ColorDim
+	}
+	values := formatValues(cell.Values)
+	switch cell.Status {
+	case StatusError:
+		return "ERROR " + cell.Rcode, ColorRed
+	case StatusChanged, StatusFlapping:
+		return values, ColorRed
+	case StatusPending, StatusSuppressed, StatusWarning:
+		return values, ColorYellow
+	}
+	return values, ColorGreen
+}
+
+func eventColor(event string) string {
+	switch {
+	case strings.Contains(event, " CHANGED "), strings.Contains(event, " ERROR "), strings.Contains(event, " FLAPPING "):
+		return ColorRed
+	case strings.Contains(event, " WARNING "), strings.Contains(event, " UNCONFIRMED "):
+		return ColorYellow
+	}
+	return ""
+}
+
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n > width {
+		return string([]rune(s)[:width-1]) + "…"
+	} else if n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// screenLine builds one line of at most width visible characters, with
+// color codes that do not count towards the width.
+type screenLine struct {
+	b       strings.Builder
+	n       int
+	width   int
+	noColor bool
+}
+
+func (d *Dashboard) line(width int) *screenLine {
+	return &screenLine{width: width, noColor: d.noColor}
+}
+
+func (l *screenLine) add(text, color string) {
+	room := l.width - l.n
+	if room <= 0 {
+		return
+	}
+	if utf8.RuneCountInString(text) > room {
+		text = string([]rune(text)[:room])
+	}
+	l.n += utf8.RuneCountInString(text)
+	if color == "" || l.noColor {
+		l.b.WriteString(text)
+		return
+	}
+	l.b.WriteString(color + text + ColorReset)
+}
+
+func (l *screenLine) String() string {
+	return l.b.String()
+}
+
+// Start switches the terminal to the alternate screen with keys delivered
+// without waiting for Enter, and starts reading them.
+func (d *Dashboard) Start() error {
+	restoreTTY := func() {}
+	if runtime.GOOS != "windows" {
+		saved, err := stty("-g")
+		if err != nil {
+			return fmt.Errorf("failed to read terminal settings: %v", err)
+		}
+		if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+			return fmt.Errorf("failed to configure terminal: %v", err)
+		}
+		restoreTTY = func() { stty(saved) }
+	}
+	io.WriteString(d.out, "\033[?1049h\033[?25l\033[H\033[2J")
+	d.restore = func() {
+		io.WriteString(d.out, "\033[?25h\033[?1049l")
+		restoreTTY()
+	}
+
+	d.keys = make(chan byte, 16)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				d.keys <- buf[0]
+			}
+		}
+	}()
+	return nil
+}
+
+// Keys returns the key presses read since Start.
+func (d *Dashboard) Keys() <-chan byte {
+	return d.keys
+}
+
+// Stop restores the terminal. It is safe to call more than once.
+func (d *Dashboard) Stop() {
+	d.stopOnce.Do(func() {
+		if d.restore != nil {
+			d.restore()
+		}
+	})
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminalSize returns the width and height of the terminal, falling back
+// to $COLUMNS and $LINES, then to 80x24.
+func terminalSize() (int, int) {
+	width, height := 80, 24
+	if runtime.GOOS != "windows" {
+		if out, err := stty("size"); err == nil {
+			if fields := strings.Fields(out); len(fields) == 2 {
+				h, errH := strconv.Atoi(fields[0])
+				w, errW := strconv.Atoi(fields[1])
+				if errH == nil && errW == nil && w > 0 && h > 0 {
+					return w, h
+				}
+			}
+		}
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		width = w
+	}
+	if h, err := strconv.Atoi(os.Getenv("LINES")); err == nil && h > 0 {
+		height = h
+	}
+	return width, height
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDashboard(config *Config) (*Dashboard, *bytes.Buffer) {
+	var buf bytes.Buffer
+	config.NoColor = true
+	config.RecordType = "A"
+	d := NewDashboard(&buf, config)
+	d.size = func() (int, int) { return 120, 20 }
+	return d, &buf
+}
+
+func TestDashboard_Render(t *testing.T) {
+	d, buf := newTestDashboard(&Config{Domains: []string{"example.com", "api.example.com"}, Servers: []string{"8.8.8.8:53", "1.1.1.1:53"}, Interval: 5 * time.Second})
+	now := time.Date(2024, 1, 15, 14, 30, 40, 0, time.UTC)
+
+	d.Observe(Observation{Time: now.Add(-time.Hour), Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusInitial, Values: []string{"192.0.2.1"}})
+	d.Observe(Observation{Time: now.Add(-90 * time.Minute), Domain: "example.com", Type: "A", Server: "1.1.1.1:53", Status: StatusChanged, Previous: []string{"192.0.2.1"}, Values: []string{"192.0.2.2"}})
+	d.Observe(Observation{Time: now, Domain: "api.example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusError, Error: "SERVFAIL", Rcode: "SERVFAIL"})
+	d.CheckDone(now)
+	d.Render(now)
+
+	out := buf.String()
+	for _, want := range []string{
+		"DNS Monitor Tool v" + Version,
+		"last check 14:30:40",
+		"DOMAIN",
+		"8.8.8.8:53",
+		"1.1.1.1:53",
+		"[192.0.2.2]",
+		"ERROR SERVFAIL",
+		"1h30m",
+		"CHANGED example.com (A) @1.1.1.1:53: [192.0.2.1] → [192.0.2.2]",
+		"ERROR api.example.com (A) @8.8.8.8:53: SERVFAIL",
+		"q quit",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected dashboard to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\033[3") {
+		t.Error("expected no colors with NoColor")
+	}
+	if lines := strings.Count(out, "\r\n") + 1; lines != 20 {
+		t.Errorf("expected the dashboard to fill 20 lines, got %d", lines)
+	}
+}
+
+func TestDashboard_Keys(t *testing.T) {
+	d, buf := newTestDashboard(&Config{Domains: []string{"example.com", "api.example.com"}, Interval: 5 * time.Second})
+
+	if d.HandleKey('p'); !d.Paused() {
+		t.Error("expected p to pause")
+	}
+	if d.HandleKey('p'); d.Paused() {
+		t.Error("expected p to resume")
+	}
+	if action := d.HandleKey('r'); action != dashboardRefresh {
+		t.Errorf("expected r to refresh, got %d", action)
+	}
+
+	for _, key := range []byte("/api") {
+		d.HandleKey(key)
+	}
+	if action := d.HandleKey('q'); action != dashboardNone {
+		t.Error("keys typed into the filter should not trigger actions")
+	}
+	d.HandleKey(127)
+	d.HandleKey('\r')
+	if d.filter != "api" || d.editing {
+		t.Errorf("expected filter %q to be applied, got %q (editing %t)", "api", d.filter, d.editing)
+	}
+
+	d.Render(time.Now())
+	if out := buf.String(); !strings.Contains(out, "api.example.com") || strings.Contains(out, "\nexample.com") || !strings.Contains(out, "1 of 2 rows") {
+		t.Errorf("expected only matching rows, got:\n%s", out)
+	}
+
+	d.HandleKey('c')
+	if len(d.visibleRows()) != 2 {
+		t.Error("expected c to clear the filter")
+	}
+	if action := d.HandleKey('q'); action != dashboardQuit {
+		t.Errorf("expected q to quit, got %d", action)
+	}
+}
+
+func TestDashboard_EventLogScrolls(t *testing.T) {
+	d, buf := newTestDashboard(&Config{Domains: []string{"example.com"}, Interval: time.Second})
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		d.Observe(Observation{Time: start.Add(time.Duration(i) * time.Minute), Domain: "example.com", Type: "A", Status: StatusChanged, Values: []string{"192.0.2.1"}})
+	}
+	d.Render(start)
+
+	out := buf.String()
+	if !strings.Contains(out, "00:49:00 CHANGED") {
+		t.Error("expected the newest event to be shown")
+	}
+	if strings.Contains(out, "00:00:00 CHANGED") {
+		t.Error("expected the oldest events to scroll out")
+	}
+}
+
+func TestPad(t *testing.T) {
+	if got := pad("abc", 5); got != "abc  " {
+		t.Errorf("unexpected padding: %q", got)
+	}
+	if got := pad("abcdef", 4); got != "abc…" {
+		t.Errorf("unexpected truncation: %q", got)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const webhookQueueSize = 1000
+
+// notifierCloseTimeout bounds how long Close waits for queued deliveries.
+const notifierCloseTimeout = 10 * time.Second
+
+// WebhookNotifier POSTs every event as JSON to a URL. Deliveries run in the
+// background from a bounded queue so a slow endpoint never delays polling;
+// when the queue is full new events are dropped and logged. A failing check
+// is sent once, on its first failure, and then on recovery.
+type WebhookNotifier struct {
+	httpPoster
+	url    string
+	secret []byte
+	logger *log.Logger
+	queue  chan Event
+	done   sync.WaitGroup
+}
+
+// httpPoster POSTs JSON bodies, retrying with exponential backoff on network
+// errors, 5xx and 429 responses. Once draining is closed on shutdown failed
+// deliveries are no longer retried, and once ctx is cancelled nothing more
+// is sent.
+type httpPoster struct {
+	client       *http.Client
+	retries      int
+	backoff      time.Duration
+	closeTimeout time.Duration
+	draining     chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+func newHTTPPoster(timeout time.Duration, retries int) httpPoster {
+	ctx, cancel := context.WithCancel(context.Background())
+	return httpPoster{
+		client:       &http.Client{Timeout: timeout},
+		retries:      retries,
+		backoff:      time.Second,
+		closeTimeout: notifierCloseTimeout,
+		draining:     make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// shutdown stops retries and waits for done, cancelling the deliveries still
+// running or queued when closeTimeout has passed.
+func (p *httpPoster) shutdown(done *sync.WaitGroup) {
+	close(p.draining)
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(p.closeTimeout):
+		p.cancel()
+		<-finished
+	}
+	p.cancel()
+}
+
+func NewWebhookNotifier(url, secret string, timeout time.Duration, retries int, logger *log.Logger) *WebhookNotifier {
+	w := &WebhookNotifier{
+		httpPoster: newHTTPPoster(timeout, retries),
+		url:        url,
+		logger:     logger,
+		queue:      make(chan Event, webhookQueueSize),
+	}
+	if secret != "" {
+		w.secret = []byte(secret)
+	}
+
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		dropped := 0
+		for event := range w.queue {
+			if w.ctx.Err() != nil {
+				dropped++
+				continue
+			}
+			if err := w.deliver(event); err != nil {
+				w.logger.Printf("WEBHOOK: delivery of %s event for %s (%s) to %s failed: %v", event.Kind, event.Domain, event.Type, w.url, err)
+			}
+		}
+		if dropped > 0 {
+			w.logger.Printf("WEBHOOK: shutdown timed out, dropping %d queued event(s)", dropped)
+		}
+	}()
+	return w
+}
+
+func (w *WebhookNotifier) Notify(events []Event) {
+	for _, event := range events {
+		if !firstFailure(event) {
+			continue
+		}
+		select {
+		case w.queue <- event:
+		default:
+			w.logger.Printf("WEBHOOK: queue full, dropping %s event for %s (%s)", event.Kind, event.Domain, event.Type)
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries to finish,
+// without retrying them and for at most closeTimeout.
+func (w *WebhookNotifier) Close() {
+	close(w.queue)
+	w.shutdown(&w.done)
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body, sent in the
+// X-DNS-Monitor-Signature header as "sha256=<hex>".
+func (w *WebhookNotifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts event, signed when a secret is configured.
+func (w *WebhookNotifier) deliver(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	if w.secret != nil {
+		header.Set("X-DNS-Monitor-Signature", w.sign(body))
+	}
+	return w.post(w.url, body, header)
+}
+
+func (p *httpPoster) post(url string, body []byte, header http.Header) error {
+	var lastErr error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(p.backoff << (attempt - 1)):
+			case <-p.draining:
+				return lastErr
+			}
+		}
+
+		req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "dns-monitor/"+Version)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventFromObservation(t *testing.T) {
+	now := time.Now()
+
+	event, ok := eventFromObservation(Observation{Time: now, Domain: "example.com", Type: "A", Server: "8.8.8.8:53", Status: StatusChanged,
+		Previous: []string{"192.0.2.1", "192.0.2.2"}, Values: []string{"192.0.2.2", "192.0.2.3"}})
+	if !ok || event.Kind != EventChange {
+		t.Fatalf("expected change event, got %+v", event)
+	}
+	if len(event.Added) != 1 || event.Added[0] != "192.0.2.3" || len(event.Removed) != 1 || event.Removed[0] != "192.0.2.1" {
+		t.Errorf("unexpected added/removed: %v / %v", event.Added, event.Removed)
+	}
+
+	event, ok = eventFromObservation(Observation{Time: now, Domain: "example.com", Type: "A", Status: StatusError, Error: "timeout"})
+	if !ok || event.Kind != EventError || event.Error != "timeout" {
+		t.Errorf("expected error event, got %+v", event)
+	}
+
+	if _, ok := eventFromObservation(Observation{Status: StatusUnchanged}); ok {
+		t.Error("unchanged observations should not produce events")
+	}
+}
+
+func TestWebhookNotifier_Deliver(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var signatures []string
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		signatures = append(signatures, r.Header.Get("X-DNS-Monitor-Signature"))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	notifier := NewWebhookNotifier(srv.URL, "s3cret", time.Second, 2, log.New(&logs, "", 0))
+	notifier.backoff = time.Millisecond
+
+	notifier.Notify([]Event{{Kind: EventChange, Domain: "example.com", Type: "A", Before: []string{"192.0.2.1"}, After: []string{"192.0.2.2"}}})
+	// Retries stop once the notifier is closed, so wait for the delivery.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		delivered := len(bodies)
+		mu.Unlock()
+		if delivered > 0 {
+			break
+		}
+	}
+	notifier.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(bodies) != 1 {
+		t.Fatalf("expected one retry and one delivery, got %d attempts and %d deliveries (log: %s)", attempts, len(bodies), logs.String())
+	}
+
+	var payload Event
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.Domain != "example.com" || payload.Kind != EventChange || payload.After[0] != "192.0.2.2" {
+		t.Errorf("unexpected payload: %s", bodies[0])
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(bodies[0])
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signatures[0] != want {
+		t.Errorf("expected signature %s, got %s", want, signatures[0])
+	}
+}
+
+func TestWebhookNotifier_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("X-DNS-Monitor-Signature") != "" {
+			t.Error("unsigned webhooks should not send a signature header")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	notifier := NewWebhookNotifier(srv.URL, "", time.Second, 3, log.New(&logs, "", 0))
+	notifier.backoff = time.Millisecond
+	notifier.Notify([]Event{{Kind: EventError, Domain: "example.com", Type: "A", Error: "timeout", Failures: 1}})
+	notifier.Close()
+
+	if attempts != 1 {
+		t.Errorf("expected a single attempt for a 4xx response, got %d", attempts)
+	}
+	if !strings.Contains(logs.String(), "400 Bad Request") {
+		t.Errorf("expected failure to be logged, got %q", logs.String())
+	}
+}
+
+func TestWebhookNotifier_FirstFailureAndRecovery(t *testing.T) {
+	var mu sync.Mutex
+	var kinds []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		kinds = append(kinds, fmt.Sprintf("%s:%d", event.Kind, event.Failures))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	notifier := NewWebhookNotifier(srv.URL, "", time.Second, 0, log.New(io.Discard, "", 0))
+	for failures := 1; failures <= 3; failures++ {
+		notifier.Notify([]Event{{Kind: EventError, Domain: "example.com", Type: "A", Error: "timeout", Failures: failures}})
+	}
+	notifier.Notify([]Event{{Kind: EventRecovered, Domain: "example.com", Type: "A", Failures: 3}})
+	notifier.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(kinds, " "); got != "error:1 recovered:3" {
+		t.Errorf("expected the first failure and the recovery to be sent, got %s", got)
+	}
+}
+
+func TestWebhookNotifier_CloseDeadline(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var logs bytes.Buffer
+	notifier := NewWebhookNotifier(srv.URL, "", 5*time.Second, 3, log.New(&logs, "", 0))
+	notifier.closeTimeout = 50 * time.Millisecond
+	notifier.Notify(make([]Event, 5))
+
+	start := time.Now()
+	notifier.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Close took %s despite the deadline", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("expected a single attempt and no retries on shutdown, got %d", attempts)
+	}
+	if !strings.Contains(logs.String(), "dropping 4 queued event(s)") {
+		t.Errorf("expected dropped events to be logged, got %q", logs.String())
+	}
+}
+
+func TestWebhookNotifier_DoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	notifier := NewWebhookNotifier(srv.URL, "", 5*time.Second, 0, log.New(&logs, "", 0))
+
+	events := make([]Event, webhookQueueSize+10)
+	start := time.Now()
+	notifier.Notify(events)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify blocked for %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "queue full") {
+		t.Error("expected overflowing events to be dropped and logged")
+	}
+
+	close(release)
+	notifier.Close()
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rrsetKey identifies an RRset by owner name (lower case, without the
+// trailing dot) and type.
+type rrsetKey struct {
+	Name string
+	Type string
+}
+
+func (k rrsetKey) String() string {
+	return k.Name + " " + k.Type
+}
+
+// zoneSnapshot holds the contents of a zone as transferred. The SOA is kept
+// apart from the RRsets so that a new serial alone is not reported as a
+// change.
+type zoneSnapshot struct {
+	Zone   string
+	Serial uint32
+	RRsets map[rrsetKey][]string
+	soa    dnsRR
+}
+
+func newZoneSnapshot(zone string, soa dnsRR) (*zoneSnapshot, error) {
+	data, err := parseSOA(soa.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA record: %v", err)
+	}
+	return &zoneSnapshot{Zone: zone, Serial: data.Serial, RRsets: make(map[rrsetKey][]string), soa: soa}, nil
+}
+
+func (z *zoneSnapshot) clone() *zoneSnapshot {
+	c := *z
+	c.RRsets = make(map[rrsetKey][]string, len(z.RRsets))
+	for k, v := range z.RRsets {
+		c.RRsets[k] = v
+	}
+	return &c
+}
+
+func rrKey(rr dnsRR) rrsetKey {
+	return rrsetKey{Name: strings.TrimSuffix(strings.ToLower(rr.Name), "."), Type: typeToString(rr.Type)}
+}
+
+func (z *zoneSnapshot) add(rr dnsRR) {
+	if rr.Type == TypeSOA {
+		return
+	}
+	key, value := rrKey(rr), rr.Value()
+	values := z.RRsets[key]
+	for _, v := range values {
+		if v == value {
+			return
+		}
+	}
+	values = append(append([]string(nil), values...), value)
+	sort.Strings(values)
+	z.RRsets[key] = values
+}
+
+func (z *zoneSnapshot) remove(rr dnsRR) {
+	if rr.Type == TypeSOA {
+		return
+	}
+	key, value := rrKey(rr), rr.Value()
+	var values []string
+	for _, v := range z.RRsets[key] {
+		if v != value {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		delete(z.RRsets, key)
+		return
+	}
+	z.RRsets[key] = values
+}
+
+func soaSerial(rr dnsRR) (uint32, error) {
+	if rr.Type != TypeSOA {
+		return 0, fmt.Errorf("expected SOA record, got %s", typeToString(rr.Type))
+	}
+	data, err := parseSOA(rr.Data)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SOA record: %v", err)
+	}
+	return data.Serial, nil
+}
+
+// QuerySerial returns the SOA serial of zone on server.
+func (c *DNSClient) QuerySerial(server, zone string) (uint32, error) {
+	resp, err := c.exchange(server, newQuery(zone, TypeSOA))
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup SOA record for %s: %w", zone, err)
+	}
+	if resp.Rcode != RcodeSuccess {
+		return 0, fmt.Errorf("failed to lookup SOA record for %s: %w", zone, &ResponseError{Rcode: resp.Rcode})
+	}
+	for _, rr := range resp.Answers {
+		if rr.Type == TypeSOA && canonicalName(rr.Name) == canonicalName(zone) {
+			return soaSerial(rr)
+		}
+	}
+	return 0, &NoRecordsError{Domain: zone, Type: "SOA"}
+}
+
+// Transfer fetches zone from server over TCP, signing the request with key
+// if one is given. With a previous snapshot an IXFR is requested and its
+// differences are applied to a copy of it; servers may answer that with the
+// full zone instead. The returned string names the kind of transfer the
+// server performed.
+func (c *DNSClient) Transfer(server, zone string, key *TSIGKey, from *zoneSnapshot) (*zoneSnapshot, string, error) {
+	qtype := TypeAXFR
+	if from != nil {
+		qtype = TypeIXFR
+	}
+	msg := newQuery(zone, qtype)
+	msg.RecursionDesired = false
+	if from != nil {
+		msg.Authority = []dnsRR{from.soa}
+	}
+
+	records, err := c.transferRecords(server, msg, key)
+	var respErr *ResponseError
+	if from != nil && errors.As(err, &respErr) && (respErr.Rcode == RcodeNotImp || respErr.Rcode == RcodeFormErr) {
+		// The server does not do IXFR.
+		return c.Transfer(server, zone, key, nil)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%s of %s failed: %w", typeToString(qtype), zone, err)
+	}
+
+	serial, _ := soaSerial(records[0])
+	if from != nil && len(records) == 1 {
+		// The server has nothing newer than what we have.
+		return from, "IXFR", nil
+	}
+	if from != nil && records[1].Type == TypeSOA {
+		if s, _ := soaSerial(records[1]); s != serial {
+			z, err := applyIXFR(from, records)
+			if err != nil {
+				return nil, "", fmt.Errorf("IXFR of %s failed: %v", zone, err)
+			}
+			return z, "IXFR", nil
+		}
+	}
+
+	z, err := newZoneSnapshot(zone, records[0])
+	if err != nil {
+		return nil, "", err
+	}
+	for _, rr := range records[1 : len(records)-1] {
+		z.add(rr)
+	}
+	return z, "AXFR", nil
+}
+
+// transferRecords sends msg and collects the answer records of every
+// response message until the transfer is complete.
+func (c *DNSClient) transferRecords(server string, msg *dnsMessage, key *TSIGKey) ([]dnsRR, error) {
+	conn, err := net.DialTimeout("tcp", server, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	var query []byte
+	var verifier *tsigVerifier
+	if key != nil {
+		var mac []byte
+		if query, mac, err = key.sign(msg, nil, false, time.Now()); err != nil {
+			return nil, err
+		}
+		verifier = newTSIGVerifier(key, mac)
+	} else if query, err = msg.pack(); err != nil {
+		return nil, err
+	}
+	if err := writeTCPFrame(conn, query); err != nil {
+		return nil, err
+	}
+
+	var records []dnsRR
+	var from uint32
+	if len(msg.Authority) > 0 {
+		from, _ = soaSerial(msg.Authority[0])
+	}
+	for {
+		// Large zones take many messages; the timeout applies to each.
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		raw, err := readTCPFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := unpackMessage(raw)
+		if err != nil {
+			return nil, err
+		}
+		if resp.ID != msg.ID {
+			return nil, fmt.Errorf("response ID mismatch from %s", server)
+		}
+
+		var verifyErr error
+		if verifier != nil {
+			verifyErr = verifier.verify(raw, time.Now())
+		}
+		if resp.Rcode != RcodeSuccess {
+			var tsigErr *TSIGError
+			if errors.As(verifyErr, &tsigErr) {
+				return nil, tsigErr
+			}
+			return nil, &ResponseError{Rcode: resp.Rcode}
+		}
+		if verifyErr != nil {
+			return nil, verifyErr
+		}
+
+		records = append(records, resp.Answers...)
+		done, err := transferComplete(records, msg.Questions[0].Type == TypeIXFR, from)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	if verifier != nil {
+		if err := verifier.done(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// transferComplete reports whether records hold a whole transfer. Both end
+// with the SOA they started with (RFC 5936 section 2.2, RFC 1995 section 4);
+// an IXFR answered by a lone SOA that is not newer than from means there is
+// nothing to transfer.
+func transferComplete(records []dnsRR, ixfr bool, from uint32) (bool, error) {
+	if len(records) == 0 {
+		return false, nil
+	}
+	serial, err := soaSerial(records[0])
+	if err != nil {
+		return false, fmt.Errorf("transfer does not start with SOA: %v", err)
+	}
+	if ixfr && len(records) == 1 && int32(serial-from) <= 0 {
+		return true, nil
+	}
+	if len(records) < 2 {
+		return false, nil
+	}
+
+	if !ixfr || records[1].Type != TypeSOA {
+		return records[len(records)-1].Type == TypeSOA, nil
+	}
+	if s, _ := soaSerial(records[1]); s == serial {
+		// An empty zone sent in full.
+		return len(records) == 2, nil
+	}
+
+	// Incremental: SOA(new) [SOA(old) deleted... SOA(next) added...]... SOA(new).
+	i := 1
+	for {
+		if i >= len(records) {
+			return false, nil
+		}
+		s, err := soaSerial(records[i])
+		if err != nil {
+			return false, fmt.Errorf("malformed IXFR response: %v", err)
+		}
+		if s == serial && i > 1 {
+			if i != len(records)-1 {
+				return false, errors.New("malformed IXFR response: records after final SOA")
+			}
+			return true, nil
+		}
+		for i++; i < len(records) && records[i].Type != TypeSOA; i++ {
+		}
+		if i >= len(records) {
+			return false, nil
+		}
+		for i++; i < len(records) && records[i].Type != TypeSOA; i++ {
+		}
+	}
+}
+
+// applyIXFR applies the differences of an incremental transfer to a copy of
+// from.
+func applyIXFR(from *zoneSnapshot, records []dnsRR) (*zoneSnapshot, error) {
+	z := from.clone()
+	deleting := false
+	for i, rr := range records[1 : len(records)-1] {
+		if rr.Type == TypeSOA {
+			deleting = !deleting
+			if deleting {
+				if serial, _ := soaSerial(rr); i == 0 && serial != from.Serial {
+					return nil, fmt.Errorf("differences start at serial %d, not %d", serial, from.Serial)
+				}
+			}
+			continue
+		}
+		if deleting {
+			z.remove(rr)
+		} else {
+			z.add(rr)
+		}
+	}
+	z.soa = records[0]
+	z.Serial, _ = soaSerial(records[0])
+	return z, nil
+}
+
+// rrsetChange is an RRset that differs between two snapshots. Before is
+// empty for added RRsets and After for removed ones.
+type rrsetChange struct {
+	Key    rrsetKey
+	Before []string
+	After  []string
+}
+
+func diffZones(old, new *zoneSnapshot) []rrsetChange {
+	var changes []rrsetChange
+	for key, after := range new.RRsets {
+		before := old.RRsets[key]
+		if !(&DNSRecord{Values: before}).Equals(&DNSRecord{Values: after}) {
+			changes = append(changes, rrsetChange{Key: key, Before: before, After: after})
+		}
+	}
+	for key, before := range old.RRsets {
+		if _, ok := new.RRsets[key]; !ok {
+			changes = append(changes, rrsetChange{Key: key, Before: before})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Key.Name != changes[j].Key.Name {
+			return changes[i].Key.Name < changes[j].Key.Name
+		}
+		return changes[i].Key.Type < changes[j].Key.Type
+	})
+	return changes
+}
+
+// transferServer returns the server zones are transferred from: the one
+// given with --xfr-server, or else the first --server.
+func (c *Config) transferServer() string {
+	if c.TransferServer != "" || len(c.Servers) == 0 {
+		return c.TransferServer
+	}
+	return c.Servers[0]
+}
+
+// checkTransfers polls the SOA serial of each zone and, when it changed,
+// transfers the zone again and reports the RRsets that differ.
+func (m *Monitor) checkTransfers(timestamp string, zones []string) bool {
+	if m.zones == nil {
+		m.zones = make(map[string]*zoneSnapshot)
+	}
+	hasChanges := false
+	for _, zone := range zones {
+		if m.checkTransfer(timestamp, zone) {
+			hasChanges = true
+		}
+	}
+	return hasChanges
+}
+
+func (m *Monitor) checkTransfer(timestamp, zone string) bool {
+	server := m.config.transferServer()
+	obs := Observation{Time: time.Now(), Domain: zone, Type: "AXFR", Server: server}
+	previous := m.zones[zone]
+
+	fail := func(err error) bool {
+		obs.Status = StatusError
+		obs.Error = err.Error()
+		obs.Rcode = errorCode(err)
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - ERROR: %v", timestamp, zone, obs.Type, err)
+		m.printColored(message, ColorYellow)
+		m.logger.Println(message)
+		return false
+	}
+
+	if previous != nil {
+		obs.Previous = []string{strconv.FormatUint(uint64(previous.Serial), 10)}
+		serial, err := m.dnsClient.QuerySerial(server, zone)
+		if err != nil {
+			return fail(err)
+		}
+		if serial == previous.Serial {
+			obs.Status = StatusUnchanged
+			obs.Values = obs.Previous
+			obs.Previous = nil
+			m.emit(obs)
+			message := fmt.Sprintf("[%s] %s (%s) - No change: serial %d, %d RRset(s)", timestamp, zone, obs.Type, serial, len(previous.RRsets))
+			m.printColored(message, ColorGreen)
+			m.logger.Println(message)
+			return false
+		}
+	}
+
+	snapshot, kind, err := m.dnsClient.Transfer(server, zone, m.config.TSIGKey, previous)
+	if err != nil {
+		return fail(err)
+	}
+	m.zones[zone] = snapshot
+	obs.Type = kind
+	obs.Values = []string{strconv.FormatUint(uint64(snapshot.Serial), 10)}
+
+	if previous == nil {
+		obs.Status = StatusInitial
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - Initial: serial %d, %d RRset(s)", timestamp, zone, kind, snapshot.Serial, len(snapshot.RRsets))
+		m.printColored(message, ColorGreen)
+		m.logger.Println(message)
+		return false
+	}
+
+	changes := diffZones(previous, snapshot)
+	if len(changes) == 0 {
+		obs.Status = StatusUnchanged
+		obs.Previous = nil
+		m.emit(obs)
+		message := fmt.Sprintf("[%s] %s (%s) - Serial %d -> %d, no RRsets changed", timestamp, zone, kind, previous.Serial, snapshot.Serial)
+		m.printColored(message, ColorGreen)
+		m.logger.Println(message)
+		return false
+	}
+
+	obs.Status = StatusChanged
+	obs.Message = fmt.Sprintf("%d RRset(s) changed", len(changes))
+	m.emit(obs)
+	message := fmt.Sprintf("[%s] %s (%s) - CHANGE DETECTED: serial %d -> %d", timestamp, zone, kind, previous.Serial, snapshot.Serial)
+	m.printColored(message, ColorRed)
+	m.logger.Println(message)
+
+	for _, change := range changes {
+		m.emit(Observation{Time: obs.Time, Domain: change.Key.Name, Type: change.Key.Type, Server: server,
+			Status: StatusChanged, Values: change.After, Previous: change.Before})
+
+		var line, color string
+		switch {
+		case len(change.Before) == 0:
+			line, color = fmt.Sprintf("  Added:   %s %s", change.Key, formatValues(change.After)), ColorBlue
+		case len(change.After) == 0:
+			line, color = fmt.Sprintf("  Removed: %s %s", change.Key, formatValues(change.Before)), ColorRed
+		default:
+			line, color = fmt.Sprintf("  Changed: %s %s -> %s", change.Key, formatValues(change.Before), formatValues(change.After)), ColorBlue
+		}
+		m.printColored(line, color)
+		m.logger.Println(line)
+	}
+	return true
+}
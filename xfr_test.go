@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testZoneServer serves a zone over UDP for SOA queries and over TCP for
+// AXFR and IXFR, keeping every version so that incremental transfers can be
+// answered. With a key, transfer requests must be signed and responses are.
+type testZoneServer struct {
+	t          *testing.T
+	addr       string
+	key        *TSIGKey
+	perMessage int
+
+	mu       sync.Mutex
+	versions [][]dnsRR
+	noIXFR   bool
+}
+
+func startZoneServer(t *testing.T, key *TSIGKey, records []dnsRR) *testZoneServer {
+	t.Helper()
+	s := &testZoneServer{t: t, key: key, perMessage: 2, versions: [][]dnsRR{records}}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on UDP: %v", err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("failed to listen on TCP: %v", err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+	})
+	s.addr = pc.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, err := unpackMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			resp := &dnsMessage{ID: q.ID, Response: true, Authoritative: true, Questions: q.Questions}
+			if q.Questions[0].Type == TypeSOA {
+				resp.Answers = []dnsRR{s.soa(s.serial())}
+			}
+			b, _ := resp.pack()
+			pc.WriteTo(b, addr)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				raw, err := readTCPFrame(conn)
+				if err != nil {
+					return
+				}
+				for _, b := range s.transfer(raw) {
+					if writeTCPFrame(conn, b) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testZoneServer) soa(serial uint32) dnsRR {
+	data := nameData(s.t, nil, "ns1.example.com.", "hostmaster.example.com.")
+	for _, v := range []uint32{serial, 3600, 600, 86400, 300} {
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	return mustRR(s.t, "example.com", TypeSOA, data)
+}
+
+func (s *testZoneServer) serial() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return uint32(len(s.versions))
+}
+
+// update publishes a new version of the zone under the next serial.
+func (s *testZoneServer) update(records []dnsRR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions = append(s.versions, records)
+}
+
+func (s *testZoneServer) transfer(raw []byte) [][]byte {
+	q, err := unpackMessage(raw)
+	if err != nil {
+		return nil
+	}
+	resp := &dnsMessage{ID: q.ID, Response: true, Authoritative: true, Questions: q.Questions}
+
+	var requestMAC []byte
+	if s.key != nil {
+		_, rr, _ := stripTSIG(raw)
+		if rr == nil {
+			resp.Rcode = RcodeRefused
+			b, _ := resp.pack()
+			return [][]byte{b}
+		}
+		if err := newTSIGVerifier(s.key, nil).verify(raw, time.Now()); err != nil {
+			t, _ := parseTSIG(rr.Data)
+			rejected := &tsigRecord{Algorithm: t.Algorithm, TimeSigned: t.TimeSigned, Fudge: t.Fudge, OriginalID: q.ID, Error: tsigBadSig}
+			data, _ := rejected.pack()
+			resp.Rcode = RcodeNotAuth
+			resp.Additional = []dnsRR{{Name: rr.Name, Type: TypeTSIG, Class: ClassANY, Data: data}}
+			b, _ := resp.pack()
+			return [][]byte{b}
+		}
+		t, _ := parseTSIG(rr.Data)
+		requestMAC = t.MAC
+	}
+
+	s.mu.Lock()
+	versions, noIXFR := s.versions, s.noIXFR
+	s.mu.Unlock()
+	serial := uint32(len(versions))
+	current := versions[len(versions)-1]
+
+	var records []dnsRR
+	switch {
+	case q.Questions[0].Type == TypeIXFR && noIXFR:
+		resp.Rcode = RcodeNotImp
+	case q.Questions[0].Type == TypeIXFR:
+		from, _ := soaSerial(q.Authority[0])
+		if from >= serial {
+			records = []dnsRR{s.soa(serial)}
+			break
+		}
+		deleted, added := diffRRs(versions[from-1], current)
+		records = append([]dnsRR{s.soa(serial), s.soa(from)}, deleted...)
+		records = append(append(append(records, s.soa(serial)), added...), s.soa(serial))
+	default:
+		records = append(append([]dnsRR{s.soa(serial)}, current...), s.soa(serial))
+	}
+
+	var messages [][]byte
+	prior := requestMAC
+	for i := 0; i == 0 || i < len(records); i += s.perMessage {
+		msg := *resp
+		msg.Answers = records[i:min(i+s.perMessage, len(records))]
+		var b []byte
+		if s.key != nil {
+			b, prior, _ = s.key.sign(&msg, prior, i > 0, time.Now())
+		} else {
+			b, _ = msg.pack()
+		}
+		messages = append(messages, b)
+	}
+	return messages
+}
+
+func diffRRs(before, after []dnsRR) (deleted, added []dnsRR) {
+	contains := func(set []dnsRR, rr dnsRR) bool {
+		for _, r := range set {
+			if rrKey(r) == rrKey(rr) && r.Value() == rr.Value() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rr := range before {
+		if !contains(after, rr) {
+			deleted = append(deleted, rr)
+		}
+	}
+	for _, rr := range after {
+		if !contains(before, rr) {
+			added = append(added, rr)
+		}
+	}
+	return deleted, added
+}
+
+func testZoneRecords(t *testing.T, www string, extra ...dnsRR) []dnsRR {
+	records := []dnsRR{
+		mustRR(t, "example.com", TypeNS, nameData(t, nil, "ns1.example.com.")),
+		mustRR(t, "example.com", TypeMX, nameData(t, []byte{0, 10}, "mail.example.com.")),
+		mustRR(t, "www.example.com", TypeA, net.ParseIP(www).To4()),
+	}
+	return append(records, extra...)
+}
+
+func TestDNSClient_Transfer(t *testing.T) {
+	key, _ := parseTSIGKey("xfr-key:c2VjcmV0")
+	mail := mustRR(t, "mail.example.com", TypeA, []byte{192, 0, 2, 25})
+	api := mustRR(t, "api.example.com", TypeA, []byte{192, 0, 2, 7})
+	server := startZoneServer(t, key, testZoneRecords(t, "192.0.2.1", mail))
+	client := NewDNSClient(nil, 2*time.Second)
+
+	v1, kind, err := client.Transfer(server.addr, "example.com", key, nil)
+	if err != nil {
+		t.Fatalf("AXFR failed: %v", err)
+	}
+	if kind != "AXFR" || v1.Serial != 1 || len(v1.RRsets) != 4 {
+		t.Fatalf("expected AXFR of 4 RRsets at serial 1, got %s %d %v", kind, v1.Serial, v1.RRsets)
+	}
+	if got := v1.RRsets[rrsetKey{"example.com", "MX"}]; len(got) != 1 || got[0] != "10 mail.example.com" {
+		t.Errorf("unexpected MX RRset: %v", got)
+	}
+
+	same, kind, err := client.Transfer(server.addr, "example.com", key, v1)
+	if err != nil || kind != "IXFR" || same != v1 {
+		t.Errorf("expected IXFR without changes to keep the snapshot, got %s %v", kind, err)
+	}
+
+	server.update(testZoneRecords(t, "192.0.2.2", api))
+	v2, kind, err := client.Transfer(server.addr, "example.com", key, v1)
+	if err != nil {
+		t.Fatalf("IXFR failed: %v", err)
+	}
+	if kind != "IXFR" || v2.Serial != 2 {
+		t.Errorf("expected IXFR to serial 2, got %s %d", kind, v2.Serial)
+	}
+	var changes []string
+	for _, c := range diffZones(v1, v2) {
+		changes = append(changes, c.Key.String()+" "+formatValues(c.Before)+" -> "+formatValues(c.After))
+	}
+	want := "api.example.com A [] -> [192.0.2.7]|mail.example.com A [192.0.2.25] -> []|www.example.com A [192.0.2.1] -> [192.0.2.2]"
+	if got := strings.Join(changes, "|"); got != want {
+		t.Errorf("expected changes %s, got %s", want, got)
+	}
+	if len(v1.RRsets) != 4 {
+		t.Error("IXFR must not modify the previous snapshot")
+	}
+
+	server.mu.Lock()
+	server.noIXFR = true
+	server.mu.Unlock()
+	full, kind, err := client.Transfer(server.addr, "example.com", key, v1)
+	if err != nil || kind != "AXFR" || len(diffZones(v2, full)) != 0 {
+		t.Errorf("expected fallback to AXFR when IXFR is not implemented, got %s %v", kind, err)
+	}
+
+	other, _ := parseTSIGKey("xfr-key:b3RoZXI=")
+	var tsigErr *TSIGError
+	if _, _, err := client.Transfer(server.addr, "example.com", other, nil); !errors.As(err, &tsigErr) || tsigErr.Code != tsigBadSig {
+		t.Errorf("expected TSIG BADSIG with the wrong secret, got %v", err)
+	}
+	if _, _, err := client.Transfer(server.addr, "example.com", nil, nil); errorCode(err) != "REFUSED" {
+		t.Errorf("expected REFUSED without a key, got %v", err)
+	}
+}
+
+func TestTransferComplete(t *testing.T) {
+	s := &testZoneServer{t: t}
+	a := mustRR(t, "www.example.com", TypeA, []byte{192, 0, 2, 1})
+	tests := []struct {
+		name    string
+		records []dnsRR
+		ixfr    bool
+		done    bool
+	}{
+		{"axfr partial", []dnsRR{s.soa(2), a}, false, false},
+		{"axfr", []dnsRR{s.soa(2), a, s.soa(2)}, false, true},
+		{"ixfr up to date", []dnsRR{s.soa(1)}, true, true},
+		{"ixfr partial", []dnsRR{s.soa(2), s.soa(1), a, s.soa(2)}, true, false},
+		{"ixfr", []dnsRR{s.soa(2), s.soa(1), a, s.soa(2), a, s.soa(2)}, true, true},
+		{"ixfr empty delta", []dnsRR{s.soa(3), s.soa(1), s.soa(2), s.soa(2), s.soa(3), s.soa(3)}, true, true},
+		{"ixfr answered in full", []dnsRR{s.soa(2), a, s.soa(2)}, true, true},
+	}
+	for _, tt := range tests {
+		done, err := transferComplete(tt.records, tt.ixfr, 1)
+		if err != nil || done != tt.done {
+			t.Errorf("%s: expected %t, got %t, %v", tt.name, tt.done, done, err)
+		}
+	}
+	if _, err := transferComplete([]dnsRR{a}, false, 0); err == nil {
+		t.Error("expected error when a transfer does not start with SOA")
+	}
+}
+
+func TestMonitor_checkTransfers(t *testing.T) {
+	server := startZoneServer(t, nil, testZoneRecords(t, "192.0.2.1"))
+	monitor, buf := newTestMonitor(t, &Config{NoColor: true, TransferZones: []string{"example.com"}, Servers: []string{server.addr}}, "")
+
+	if monitor.checkTransfers("ts", monitor.config.TransferZones) {
+		t.Error("initial transfer should not be reported as a change")
+	}
+	if monitor.checkTransfers("ts", monitor.config.TransferZones) {
+		t.Error("unchanged serial should not be reported as a change")
+	}
+	server.update(testZoneRecords(t, "192.0.2.1", mustRR(t, "api.example.com", TypeA, []byte{192, 0, 2, 7})))
+	if !monitor.checkTransfers("ts", monitor.config.TransferZones) {
+		t.Error("expected added RRset to be reported as a change")
+	}
+
+	for _, want := range []string{
+		"example.com (AXFR) - Initial: serial 1, 3 RRset(s)",
+		"example.com (AXFR) - No change: serial 1, 3 RRset(s)",
+		"example.com (IXFR) - CHANGE DETECTED: serial 1 -> 2",
+		"  Added:   api.example.com A [192.0.2.7]",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in log, got %q", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zoneRecord is one resource record from a master file, with its owner as an
+// absolute name without the trailing dot and its data in the presentation
+// format the monitor prints.
+type zoneRecord struct {
+	Name  string
+	TTL   uint32
+	Type  string
+	Value string
+	Line  int
+}
+
+// zoneToken is a word of a master file entry. Quoted tokens are character
+// strings and never directives, comments or names.
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneEntry is one logical entry: a line, or several joined by parentheses.
+type zoneEntry struct {
+	line   int
+	indent bool
+	tokens []zoneToken
+}
+
+// readZoneFile parses the master file at path. Without an $ORIGIN the origin
+// is origin, or else taken from a file name such as "db.example.com" or
+// "example.com.zone".
+func readZoneFile(path, origin string) ([]zoneRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zone file: %v", err)
+	}
+	defer f.Close()
+
+	if origin == "" {
+		origin = zoneOriginFromPath(path)
+	}
+	records, err := parseZone(f, origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return records, nil
+}
+
+// zoneOriginFromPath returns NAME for a file named db.NAME, NAME.zone or
+// NAME.db, and "" for any other file name.
+func zoneOriginFromPath(path string) string {
+	base := filepath.Base(path)
+	name := strings.TrimPrefix(base, "db.")
+	if name == base {
+		for _, suffix := range []string{".zone", ".db"} {
+			name = strings.TrimSuffix(name, suffix)
+		}
+	}
+	if name == base || name == "" {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// parseZone parses an RFC 1035 master file with the $ORIGIN and $TTL
+// directives, "@", relative names, omitted owners, TTLs and classes, and
+// entries continued over several lines in parentheses. Errors are prefixed
+// with the line number.
+func parseZone(r io.Reader, origin string) ([]zoneRecord, error) {
+	entries, err := scanZone(r)
+	if err != nil {
+		return nil, err
+	}
+
+	origin = strings.TrimSuffix(origin, ".")
+	var defaultTTL uint32 = 3600
+	var owner string
+	var records []zoneRecord
+
+	for _, e := range entries {
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%d: %s", e.line, fmt.Sprintf(format, args...))
+		}
+		tokens := e.tokens
+
+		if !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+			directive := strings.ToUpper(tokens[0].text)
+			switch {
+			case directive == "$ORIGIN" && len(tokens) >= 2:
+				origin = absoluteName(tokens[1].text, origin)
+			case directive == "$TTL" && len(tokens) >= 2:
+				ttl, err := parseTTL(tokens[1].text)
+				if err != nil {
+					return nil, fail("%v", err)
+				}
+				defaultTTL = ttl
+			case directive == "$ORIGIN" || directive == "$TTL":
+				return nil, fail("%s requires a value", directive)
+			default:
+				return nil, fail("unsupported directive: %s", tokens[0].text)
+			}
+			continue
+		}
+
+		if origin == "" {
+			return nil, fail("no $ORIGIN; use --origin")
+		}
+		if !e.indent {
+			owner = absoluteName(tokens[0].text, origin)
+			tokens = tokens[1:]
+		}
+		if owner == "" {
+			return nil, fail("record without owner name")
+		}
+
+		record := zoneRecord{Name: owner, TTL: defaultTTL, Line: e.line}
+		// TTL and class may appear in either order before the type.
+		for len(tokens) > 0 && !tokens[0].quoted {
+			word := strings.ToUpper(tokens[0].text)
+			if word == "IN" || word == "CH" || word == "HS" || word == "CS" {
+				tokens = tokens[1:]
+				continue
+			}
+			if ttl, err := parseTTL(word); err == nil {
+				record.TTL = ttl
+				tokens = tokens[1:]
+				continue
+			}
+			break
+		}
+		if len(tokens) == 0 {
+			return nil, fail("missing record type")
+		}
+		record.Type = strings.ToUpper(tokens[0].text)
+		if tokens[0].quoted || !isTypeMnemonic(record.Type) {
+			return nil, fail("invalid record type: %s", tokens[0].text)
+		}
+
+		value, err := zoneValue(record.Type, tokens[1:], origin)
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		record.Value = value
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// scanZone splits a master file into entries, removing comments and joining
+// lines inside parentheses.
+func scanZone(r io.Reader) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	var current *zoneEntry
+	depth := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if current == nil {
+			current = &zoneEntry{line: n, indent: line != "" && (line[0] == ' ' || line[0] == '\t')}
+		}
+
+		var word strings.Builder
+		inWord, quoted, escaped := false, false, false
+		flush := func() {
+			if inWord {
+				current.tokens = append(current.tokens, zoneToken{text: word.String(), quoted: quoted})
+			}
+			word.Reset()
+			inWord, quoted = false, false
+		}
+
+	scan:
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case escaped:
+				word.WriteByte(c)
+				escaped = false
+			case c == '\\':
+				// Escapes are decoded by zoneValue; here they only keep
+				// the next character from ending a string or word.
+				word.WriteByte(c)
+				inWord, escaped = true, true
+			case quoted && c == '"':
+				current.tokens = append(current.tokens, zoneToken{text: word.String(), quoted: true})
+				word.Reset()
+				inWord, quoted = false, false
+			case quoted:
+				word.WriteByte(c)
+			case c == '"':
+				flush()
+				inWord, quoted = true, true
+			case c == ';':
+				break scan
+			case c == '(':
+				flush()
+				depth++
+			case c == ')':
+				flush()
+				if depth == 0 {
+					return nil, fmt.Errorf("%d: unbalanced parentheses", n)
+				}
+				depth--
+			case c == ' ' || c == '\t':
+				flush()
+			default:
+				word.WriteByte(c)
+				inWord = true
+			}
+		}
+		if quoted {
+			return nil, fmt.Errorf("%d: unterminated quote", n)
+		}
+		flush()
+
+		if depth > 0 {
+			continue
+		}
+		if len(current.tokens) > 0 {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("%d: unbalanced parentheses", current.line)
+	}
+	return entries, nil
+}
+
+// isTypeMnemonic reports whether s looks like a record type, such as A,
+// NSEC3PARAM or TYPE65534. Types the monitor does not know are still parsed.
+func isTypeMnemonic(s string) bool {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// absoluteName resolves name against origin and returns it without the
+// trailing dot.
+func absoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin == "":
+		return name
+	default:
+		return name + "." + origin
+	}
+}
+
+// parseTTL parses a TTL in seconds or with BIND units, such as 1h30m or 1W.
+func parseTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	var total, n uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		unit := map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}[c]
+		if unit == 0 || !digits {
+			return 0, fmt.Errorf("invalid TTL: %s", s)
+		}
+		total += n * unit
+		n, digits = 0, false
+	}
+	if digits || total > 1<<32-1 || s == "" {
+		return 0, fmt.Errorf("invalid TTL: %s", s)
+	}
+	return uint32(total), nil
+}
+
+// zoneValue converts record data to the presentation format of
+// dnsRR.Value: addresses in canonical form, names absolute without the
+// trailing dot, and TXT strings concatenated.
+func zoneValue(recordType string, tokens []zoneToken, origin string) (string, error) {
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.text
+	}
+	need := func(n int) error {
+		if len(words) != n {
+			return fmt.Errorf("%s record needs %d field(s), got %d", recordType, n, len(words))
+		}
+		return nil
+	}
+
+	switch recordType {
+	case "A", "AAAA":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		ip := net.ParseIP(words[0])
+		if ip == nil || (recordType == "A") != (ip.To4() != nil) {
+			return "", fmt.Errorf("invalid %s address: %s", recordType, words[0])
+		}
+		return ip.String(), nil
+	case "CNAME", "NS", "PTR":
+		if err := need(1); err != nil {
+			return "", err
+		}
+		return absoluteName(words[0], origin), nil
+	case "MX":
+		if err := need(2); err != nil {
+			return "", err
+		}
+		pref, err := strconv.ParseUint(words[0], 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("invalid MX preference: %s", words[0])
+		}
+		return fmt.Sprintf("%d %s", pref, absoluteName(words[1], origin)), nil
+	case "TXT", "SPF":
+		if len(words) == 0 {
+			return "", fmt.Errorf("%s record needs at least one string", recordType)
+		}
+		var sb strings.Builder
+		for _, w := range words {
+			sb.WriteString(unescapeZoneString(w))
+		}
+		return sb.String(), nil
+	case "SOA":
+		if err := need(7); err != nil {
+			return "", err
+		}
+		words[0] = absoluteName(words[0], origin)
+		words[1] = absoluteName(words[1], origin)
+		for i := 2; i < 7; i++ {
+			n, err := parseTTL(words[i])
+			if err != nil {
+				return "", fmt.Errorf("invalid SOA field: %s", words[i])
+			}
+			words[i] = strconv.FormatUint(uint64(n), 10)
+		}
+	}
+	if len(words) == 0 {
+		return "", fmt.Errorf("%s record has no data", recordType)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// unescapeZoneString decodes the \X and \DDD escapes of a character string.
+func unescapeZoneString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if i+4 <= len(s) {
+				if n, err := strconv.ParseUint(s[i+1:i+4], 10, 8); err == nil {
+					b.WriteByte(byte(n))
+					i += 3
+					continue
+				}
+			}
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// zoneTargets groups records by name and type into targets expecting the
+// zone's values. Records of types for which supported returns false are left
+// out and their types returned.
+func zoneTargets(records []zoneRecord, supported func(string) bool) ([]Target, []string) {
+	var targets []Target
+	index := make(map[string]int)
+	skipped := make(map[string]bool)
+	for _, r := range records {
+		if !supported(r.Type) {
+			skipped[r.Type] = true
+			continue
+		}
+		key := strings.ToLower(r.Name) + ":" + r.Type
+		i, ok := index[key]
+		if !ok {
+			i = len(targets)
+			index[key] = i
+			targets = append(targets, Target{Domain: r.Name, Type: r.Type})
+		}
+		targets[i].Expected = append(targets[i].Expected, r.Value)
+	}
+	var types []string
+	for t := range skipped {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return targets, types
+}
+
+func runImportZoneCommand(args []string) int {
+	// "import-zone FILE [OPTIONS]" is "--zone-file FILE [OPTIONS]".
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		args = append([]string{args[0], "--zone-file"}, args[1:]...)
+	}
+	config, err := ParseArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if config.ShowHelp {
+		printImportZoneUsage()
+		return 0
+	}
+	if config.ShowVersion {
+		fmt.Printf("DNS Monitor Tool v%s\n", Version)
+		return 0
+	}
+
+	monitor := NewMonitor(config)
+	monitor.reload = func() (*Config, error) { return ParseArgs(args) }
+	if err := monitor.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printImportZoneUsage() {
+	fmt.Fprintf(os.Stderr, `USAGE:
+    dns-monitor import-zone ZONEFILE [OPTIONS] [DOMAIN...]
+
+Monitors every record of a BIND master file, expecting the values in the
+file. Accepts every monitoring option; --zone-file adds further zone files.
+
+The origin is taken from $ORIGIN, or else from --origin, or else from a file
+named db.NAME, NAME.zone or NAME.db: db.example.com and example.com.zone both
+default to example.com. Other files without $ORIGIN need --origin. Records of
+types the monitor cannot query (SOA, NS, SRV, ...) are skipped with a warning.
+
+EXAMPLES:
+    dns-monitor import-zone db.example.com -s ns1.example.com
+    dns-monitor check --zone-file zones/example.com.zone -s ns1.example.com
+`)
+}
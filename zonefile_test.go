@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testZone = `$TTL 1h
+$ORIGIN example.com.
+@   IN  SOA ns1 hostmaster (
+        2024050101 ; serial
+        3h 15m 1w 1d )
+    IN  NS  ns1
+    IN  NS  ns2.example.net.
+    IN  MX  10 mail
+    IN  MX  20 mail.backup.example.net.
+    300 IN  A   192.0.2.1
+        IN  A   192.0.2.2
+    TXT "v=spf1 mx -all"
+www IN  CNAME @
+mail 600 IN A 192.0.2.25
+ns1 AAAA 2001:DB8:0:0::53
+_dmarc TXT ( "v=DMARC1; p=reject; "
+             "rua=mailto:dmarc@example.com" )
+quoted TXT "semi;colon" "say \"hi\"" "tab\009"
+$ORIGIN sub.example.com.
+api A 198.51.100.7
+`
+
+func TestParseZone(t *testing.T) {
+	records, err := parseZone(strings.NewReader(testZone), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		name, recordType, value string
+		ttl                     uint32
+	}{
+		{"example.com", "SOA", "ns1.example.com hostmaster.example.com 2024050101 10800 900 604800 86400", 3600},
+		{"example.com", "NS", "ns1.example.com", 3600},
+		{"example.com", "NS", "ns2.example.net", 3600},
+		{"example.com", "MX", "10 mail.example.com", 3600},
+		{"example.com", "MX", "20 mail.backup.example.net", 3600},
+		{"example.com", "A", "192.0.2.1", 300},
+		{"example.com", "A", "192.0.2.2", 3600},
+		{"example.com", "TXT", "v=spf1 mx -all", 3600},
+		{"www.example.com", "CNAME", "example.com", 3600},
+		{"mail.example.com", "A", "192.0.2.25", 600},
+		{"ns1.example.com", "AAAA", "2001:db8::53", 3600},
+		{"_dmarc.example.com", "TXT", "v=DMARC1; p=reject; rua=mailto:dmarc@example.com", 3600},
+		{"quoted.example.com", "TXT", "semi;colon" + `say "hi"` + "tab\t", 3600},
+		{"api.sub.example.com", "A", "198.51.100.7", 3600},
+	}
+	if len(records) != len(expected) {
+		for _, r := range records {
+			t.Logf("%+v", r)
+		}
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, want := range expected {
+		r := records[i]
+		if r.Name != want.name || r.Type != want.recordType || r.Value != want.value || r.TTL != want.ttl {
+			t.Errorf("record %d: expected %s %d %s %q, got %s %d %s %q", i, want.name, want.ttl, want.recordType, want.value, r.Name, r.TTL, r.Type, r.Value)
+		}
+	}
+}
+
+func TestParseZone_Errors(t *testing.T) {
+	tests := []struct {
+		zone string
+		err  string
+	}{
+		{"$INCLUDE other.zone", "1: unsupported directive"},
+		{"@ A 192.0.2.1\nwww A 2001:db8::1", "2: invalid A address"},
+		{"@ SOA ns1 hostmaster ( 1 2 3 4 5", "1: unbalanced parentheses"},
+		{"@ TXT \"open", "1: unterminated quote"},
+		{"  A 192.0.2.1", "1: record without owner name"},
+		{"@ 3600 IN", "1: missing record type"},
+		{"@ MX mail", "1: MX record needs 2 field(s)"},
+		{"$TTL 1x", "1: invalid TTL"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseZone(strings.NewReader(tt.zone), "example.com")
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: expected error %q, got %v", tt.zone, tt.err, err)
+		}
+	}
+
+	if _, err := parseZone(strings.NewReader("$TTL 1h\nwww A 192.0.2.1"), ""); err == nil || err.Error() != "2: no $ORIGIN; use --origin" {
+		t.Errorf("expected missing origin to be reported, got %v", err)
+	}
+}
+
+func TestZoneTargets(t *testing.T) {
+	records, err := parseZone(strings.NewReader(testZone), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets, skipped := zoneTargets(records, isValidRecordType)
+
+	if strings.Join(skipped, ",") != "NS,SOA" {
+		t.Errorf("expected NS and SOA to be skipped, got %v", skipped)
+	}
+	if len(targets) != 9 {
+		t.Fatalf("expected 9 targets, got %+v", targets)
+	}
+	if targets[0].Key() != "example.com:MX" || len(targets[0].Expected) != 2 {
+		t.Errorf("expected both MX values in one target, got %+v", targets[0])
+	}
+	if targets[1].Key() != "example.com:A" || strings.Join(targets[1].Expected, ",") != "192.0.2.1,192.0.2.2" {
+		t.Errorf("expected both A values in one target, got %+v", targets[1])
+	}
+}
+
+func TestZoneOriginFromPath(t *testing.T) {
+	for path, origin := range map[string]string{
+		"zones/db.example.com":     "example.com",
+		"example.org.zone":         "example.org",
+		"/etc/bind/Example.NET.db": "example.net",
+		"zones/example.com":        "",
+		"example.com.txt":          "",
+		"db.":                      "",
+		".zone":                    "",
+	} {
+		if got := zoneOriginFromPath(path); got != origin {
+			t.Errorf("%s: expected origin %s, got %s", path, origin, got)
+		}
+	}
+}
+
+func TestParseArgs_ZoneFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.example.com")
+	zone := "@ 300 IN A 192.0.2.1\nwww CNAME @\n"
+	if err := os.WriteFile(path, []byte(zone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseArgs([]string{"dns-monitor", "--zone-file", path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := config.targets()
+	if len(targets) != 2 || targets[1].Key() != "www.example.com:CNAME" || targets[1].Expected[0] != "example.com" {
+		t.Errorf("unexpected targets from zone file: %+v", targets)
+	}
+
+	path = filepath.Join(filepath.Dir(path), "primary.txt")
+	if err := os.WriteFile(path, []byte(zone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseArgs([]string{"dns-monitor", "--zone-file", path}); err == nil || !strings.Contains(err.Error(), "no $ORIGIN; use --origin") {
+		t.Errorf("expected zone file without origin to be rejected, got %v", err)
+	}
+	config, err = ParseArgs([]string{"dns-monitor", "--zone-file", path, "--origin", "Example.ORG."})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets := config.targets(); len(targets) != 2 || targets[0].Key() != "example.org:A" {
+		t.Errorf("expected --origin to be used, got %+v", targets)
+	}
+}